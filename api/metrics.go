@@ -0,0 +1,174 @@
+package api
+
+/*
+In this file we export task metrics in the Prometheus text exposition format.
+See https://prometheus.io/docs/instrumenting/exposition_formats/
+*/
+
+import (
+	"bufio"
+	"fmt"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/recording"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var taskStatuses = []recording.TaskStatus{
+	recording.StNotStarted,
+	recording.StRunning,
+	recording.StRestarting,
+	recording.StStopped,
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %v", r.Method))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeMetrics(bw, s.tasks.Metrics())
+	_ = bw.Flush()
+}
+
+// metricFamily is a set of samples with the same name.
+type metricFamily struct {
+	name string
+	typ  string
+	help string
+	// samples reports the samples of a task by calling add
+	samples func(m recording.TaskMetrics, add func(value float64, labels ...string))
+}
+
+var metricFamilies = []metricFamily{
+	{
+		name: "slbr_task_status",
+		typ:  "gauge",
+		help: "Current status of the task, 1 for the current status and 0 for others.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			for _, st := range taskStatuses {
+				v := 0.0
+				if m.Status == st {
+					v = 1
+				}
+				add(v, "status", st.String())
+			}
+		},
+	},
+	{
+		name: "slbr_recording",
+		typ:  "gauge",
+		help: "Whether a file is being recorded.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			v := 0.0
+			if m.Recording {
+				v = 1
+			}
+			add(v)
+		},
+	},
+	{
+		name: "slbr_downloaded_bytes_total",
+		typ:  "counter",
+		help: "Total number of bytes of the live stream saved to disk.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			add(float64(m.BytesWritten))
+		},
+	},
+	{
+		name: "slbr_recording_duration_seconds",
+		typ:  "gauge",
+		help: "How long the current file has been recorded, 0 if not recording.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			add(m.RecordingDuration.Seconds())
+		},
+	},
+	{
+		name: "slbr_recorded_seconds_total",
+		typ:  "counter",
+		help: "Total duration of all recorded files.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			add(m.RecordedDuration.Seconds())
+		},
+	},
+	{
+		name: "slbr_task_retries_total",
+		typ:  "counter",
+		help: "How many times the task is retried because of recoverable errors, by error type.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			keys := make([]errs.Type, 0, len(m.Retries))
+			for k := range m.Retries {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+			for _, k := range keys {
+				add(float64(m.Retries[k]), "type", k.Name())
+			}
+		},
+	},
+	{
+		name: "slbr_danmaku_messages_total",
+		typ:  "counter",
+		help: "How many messages are received from the danmaku server, by command.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			keys := make([]string, 0, len(m.Messages))
+			for k := range m.Messages {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				add(float64(m.Messages[k]), "cmd", k)
+			}
+		},
+	},
+	{
+		name: "slbr_heartbeat_failures_total",
+		typ:  "counter",
+		help: "How many heartbeat messages failed to be sent to the danmaku server.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			add(float64(m.HeartbeatFailures))
+		},
+	},
+	{
+		name: "slbr_viewers",
+		typ:  "gauge",
+		help: "The number of viewers reported by the last WATCHED_CHANGE message.",
+		samples: func(m recording.TaskMetrics, add func(float64, ...string)) {
+			add(float64(m.Viewers))
+		},
+	},
+}
+
+func writeMetrics(w io.Writer, metrics []recording.TaskMetrics) {
+	for _, f := range metricFamilies {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, m := range metrics {
+			room := strconv.FormatUint(uint64(m.RoomId), 10)
+			f.samples(m, func(value float64, labels ...string) {
+				sb := strings.Builder{}
+				sb.WriteString(`room_id="`)
+				sb.WriteString(room)
+				sb.WriteString(`"`)
+				if m.Uid != 0 {
+					// the room of a streamer may change
+					sb.WriteString(fmt.Sprintf(`,uid="%d"`, m.Uid))
+				}
+				for i := 0; i+1 < len(labels); i += 2 {
+					sb.WriteString(fmt.Sprintf(`,%s="%s"`, labels[i], escapeLabelValue(labels[i+1])))
+				}
+				_, _ = fmt.Fprintf(w, "%s{%s} %s\n",
+					f.name, sb.String(), strconv.FormatFloat(value, 'f', -1, 64))
+			})
+		}
+	}
+}
+
+var escapeLabelValue = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+).Replace
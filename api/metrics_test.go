@@ -0,0 +1,76 @@
+package api
+
+import (
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/recording"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	tasks := newFakeTasks()
+	tasks.metrics = []recording.TaskMetrics{
+		{
+			RoomId:            1234,
+			Status:            recording.StRunning,
+			Recording:         true,
+			BytesWritten:      1048576,
+			RecordingDuration: 90 * time.Second,
+			RecordedDuration:  150 * time.Second,
+			Retries:           map[errs.Type]int64{errs.StreamCopy: 2, errs.GetLiveInfo: 1},
+			Messages:          map[string]int64{"DANMU_MSG": 10, `WEIRD"CMD`: 1},
+			HeartbeatFailures: 3,
+			Viewers:           4567,
+		},
+		{
+			RoomId: 5678,
+			Status: recording.StStopped,
+		},
+		{
+			RoomId: 7734200,
+			Uid:    9617619,
+			Status: recording.StRunning,
+		},
+	}
+	ts := newTestServer(tasks, "")
+	defer ts.Close()
+
+	code, body := doRequest(t, http.MethodGet, ts.URL+"/metrics", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", code, body)
+	}
+	expected := []string{
+		"# TYPE slbr_task_status gauge",
+		`slbr_task_status{room_id="1234",status="running"} 1`,
+		`slbr_task_status{room_id="1234",status="stopped"} 0`,
+		`slbr_task_status{room_id="5678",status="stopped"} 1`,
+		`slbr_recording{room_id="1234"} 1`,
+		`slbr_recording{room_id="5678"} 0`,
+		"# TYPE slbr_downloaded_bytes_total counter",
+		`slbr_downloaded_bytes_total{room_id="1234"} 1048576`,
+		`slbr_recording_duration_seconds{room_id="1234"} 90`,
+		`slbr_recorded_seconds_total{room_id="1234"} 150`,
+		`slbr_task_retries_total{room_id="1234",type="get_live_info"} 1`,
+		`slbr_task_retries_total{room_id="1234",type="stream_copy"} 2`,
+		`slbr_danmaku_messages_total{room_id="1234",cmd="DANMU_MSG"} 10`,
+		`slbr_danmaku_messages_total{room_id="1234",cmd="WEIRD\"CMD"} 1`,
+		`slbr_heartbeat_failures_total{room_id="1234"} 3`,
+		`slbr_viewers{room_id="1234"} 4567`,
+		`slbr_recording{room_id="7734200",uid="9617619"} 0`,
+	}
+	lines := strings.Split(body, "\n")
+	for _, e := range expected {
+		found := false
+		for _, l := range lines {
+			if l == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("missing line %q in metrics:\n%v", e, body)
+		}
+	}
+}
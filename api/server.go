@@ -0,0 +1,276 @@
+/*
+Package api implements the optional HTTP control and status API.
+Tasks can be listed, added, stopped, started and removed at runtime.
+
+Endpoints:
+  - GET    /tasks              list all tasks
+  - POST   /tasks              add a task, the body is a task config in JSON, with the same keys as the config file.
+    The room is resolved to the canonical room id, which identifies the task in other endpoints.
+    Command hooks and paths on the host (cookie_file, save_directory and file_name_template) are rejected,
+    since API clients should not be able to run commands or access files
+  - GET    /tasks/{key}        get a task
+  - DELETE /tasks/{key}        stop and remove a task
+  - POST   /tasks/{key}/stop   stop a task
+  - POST   /tasks/{key}/start  start a stopped task
+
+{key} is the room id of a task, or "uid:" followed by the uid for tasks of a streamer.
+  - GET    /metrics            metrics of all tasks in Prometheus text format
+*/
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/recording"
+	"github.com/mitchellh/mapstructure"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxRequestBodyBytes = 1024 * 1024
+
+// TaskController manages tasks. It is implemented by recording.TaskManager.
+type TaskController interface {
+	Add(config recording.TaskConfig) error
+	Start(key recording.TaskKey) error
+	Stop(key recording.TaskKey) error
+	Remove(key recording.TaskKey) error
+	Task(key recording.TaskKey) (recording.TaskInfo, bool)
+	Tasks() []recording.TaskInfo
+	Metrics() []recording.TaskMetrics
+}
+
+// RoomResolver sets the canonical room id of task configs. It is implemented by recording.RoomResolver.
+type RoomResolver interface {
+	Resolve(config *recording.TaskConfig) error
+}
+
+type Server struct {
+	tasks TaskController
+	rooms RoomResolver
+	// token: if not empty, requests must have header `Authorization: Bearer <token>`
+	token string
+	// newConfig returns the default config of tasks added with the API
+	newConfig func() recording.TaskConfig
+	logger    logging.Logger
+	mux       *http.ServeMux
+}
+
+func NewServer(
+	tasks TaskController,
+	rooms RoomResolver,
+	token string,
+	newConfig func() recording.TaskConfig,
+	logger logging.Logger,
+) *Server {
+	s := &Server{
+		tasks:     tasks,
+		rooms:     rooms,
+		token:     token,
+		newConfig: newConfig,
+		logger:    logger,
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/tasks", s.handleTasks)
+	s.mux.HandleFunc("/tasks/", s.handleTask)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on the given address until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	s.logger.Info("HTTP API is listening on %v", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, s.tasks.Tasks())
+	case http.MethodPost:
+		config, err := s.decodeConfig(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// the room may be given as a short id or an URL
+		if err := s.rooms.Resolve(&config); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := config.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = s.tasks.Add(config)
+		if errors.Is(err, recording.ErrTaskExists) {
+			writeError(w, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.logger.Info("Task is added: %v", config)
+		info, _ := s.tasks.Task(config.Key())
+		writeJson(w, http.StatusCreated, info)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %v", r.Method))
+	}
+}
+
+func (s *Server) handleTask(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/")
+	if len(parts) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found: %v", r.URL.Path))
+		return
+	}
+	key, err := recording.ParseTaskKey(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		info, ok := s.tasks.Task(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%w: %v", recording.ErrTaskNotFound, key))
+			return
+		}
+		writeJson(w, http.StatusOK, info)
+	case action == "" && r.Method == http.MethodDelete:
+		s.doAction(w, key, "removed", s.tasks.Remove)
+	case action == "stop" && r.Method == http.MethodPost:
+		s.doAction(w, key, "stopped", s.tasks.Stop)
+	case action == "start" && r.Method == http.MethodPost:
+		s.doAction(w, key, "started", s.tasks.Start)
+	case action == "" || action == "stop" || action == "start":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %v", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found: %v", r.URL.Path))
+	}
+}
+
+func (s *Server) doAction(
+	w http.ResponseWriter,
+	key recording.TaskKey,
+	name string,
+	action func(key recording.TaskKey) error,
+) {
+	err := action(key)
+	if errors.Is(err, recording.ErrTaskNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if errors.Is(err, recording.ErrTaskIsAlreadyStarted) {
+		writeError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("Task is %v: %v", name, key)
+	info, ok := s.tasks.Task(key)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJson(w, http.StatusOK, info)
+}
+
+// decodeConfig reads a task config from the request body.
+// Fields absent in the body are set to default values. Fields which are only allowed in the config file
+// must not be changed, see checkUnsafeFields.
+func (s *Server) decodeConfig(r *http.Request) (config recording.TaskConfig, err error) {
+	var m map[string]interface{}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBodyBytes))
+	if err = dec.Decode(&m); err != nil {
+		err = fmt.Errorf("invalid JSON body: %w", err)
+		return
+	}
+	defaults := s.newConfig()
+	config = s.newConfig()
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       recording.ConfigDecodeHook,
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           &config,
+	})
+	if err != nil {
+		return
+	}
+	if err = decoder.Decode(m); err != nil {
+		err = fmt.Errorf("invalid task config: %w", err)
+		return
+	}
+	err = checkUnsafeFields(config, defaults)
+	return
+}
+
+// checkUnsafeFields returns an error if config changes fields which run commands or access files on the host.
+// Keys are matched case-insensitively when decoding, so decoded values are compared instead of keys.
+func checkUnsafeFields(config recording.TaskConfig, defaults recording.TaskConfig) error {
+	for _, h := range config.Hooks {
+		if h.Command != "" {
+			return errors.New("command hooks cannot be added with the API, use webhooks instead")
+		}
+	}
+	var field string
+	switch {
+	case config.CookieFile != defaults.CookieFile:
+		field = "cookie_file"
+	case config.Download.SaveDirectory != defaults.Download.SaveDirectory:
+		field = "download.save_directory"
+	case config.Download.FileNameTemplate != defaults.Download.FileNameTemplate:
+		field = "download.file_name_template"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%v cannot be set with the API, set it in the config file or command line arguments", field)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJson(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
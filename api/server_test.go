@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/recording"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeTasks struct {
+	configs map[recording.TaskKey]recording.TaskConfig
+	status  map[recording.TaskKey]recording.TaskStatus
+	order   []recording.TaskKey
+	metrics []recording.TaskMetrics
+}
+
+func newFakeTasks() *fakeTasks {
+	return &fakeTasks{
+		configs: make(map[recording.TaskKey]recording.TaskConfig),
+		status:  make(map[recording.TaskKey]recording.TaskStatus),
+	}
+}
+
+func (f *fakeTasks) Add(config recording.TaskConfig) error {
+	key := config.Key()
+	if _, ok := f.configs[key]; ok {
+		return fmt.Errorf("%w: %v", recording.ErrTaskExists, key)
+	}
+	f.configs[key] = config
+	f.status[key] = recording.StRunning
+	f.order = append(f.order, key)
+	return nil
+}
+
+func (f *fakeTasks) Start(key recording.TaskKey) error {
+	st, ok := f.status[key]
+	if !ok {
+		return recording.ErrTaskNotFound
+	}
+	if st != recording.StStopped {
+		return recording.ErrTaskIsAlreadyStarted
+	}
+	f.status[key] = recording.StRunning
+	return nil
+}
+
+func (f *fakeTasks) Stop(key recording.TaskKey) error {
+	if _, ok := f.status[key]; !ok {
+		return recording.ErrTaskNotFound
+	}
+	f.status[key] = recording.StStopped
+	return nil
+}
+
+func (f *fakeTasks) Remove(key recording.TaskKey) error {
+	if _, ok := f.status[key]; !ok {
+		return recording.ErrTaskNotFound
+	}
+	delete(f.configs, key)
+	delete(f.status, key)
+	for i, id := range f.order {
+		if id == key {
+			f.order = append(f.order[:i], f.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeTasks) Task(key recording.TaskKey) (recording.TaskInfo, bool) {
+	st, ok := f.status[key]
+	if !ok {
+		return recording.TaskInfo{}, false
+	}
+	config := f.configs[key]
+	return recording.TaskInfo{Key: key, RoomId: config.RoomId, Uid: config.Uid, Status: st}, true
+}
+
+func (f *fakeTasks) Tasks() []recording.TaskInfo {
+	var infos []recording.TaskInfo
+	for _, id := range f.order {
+		info, _ := f.Task(id)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (f *fakeTasks) Metrics() []recording.TaskMetrics {
+	return f.metrics
+}
+
+// fakeRooms resolves room 6 and its URL to 7734200, other room ids are not changed
+type fakeRooms struct{}
+
+func (fakeRooms) Resolve(config *recording.TaskConfig) error {
+	switch {
+	case config.Uid != 0:
+		// rooms of streamers are looked up when the task runs
+	case config.Room == "https://live.bilibili.com/6" || config.RoomId == 6:
+		config.RoomId = 7734200
+	case config.Room != "":
+		return fmt.Errorf("cannot resolve room %q", config.Room)
+	case config.RoomId == 0:
+		return errors.New("room, room_id or uid is required")
+	}
+	return nil
+}
+
+func newTestServer(tasks TaskController, token string) *httptest.Server {
+	s := NewServer(tasks, fakeRooms{}, token, func() recording.TaskConfig {
+		return recording.TaskConfig{
+			Transport: recording.DefaultTransportConfig(),
+			Download:  recording.DownloadConfig{SaveDirectory: "."},
+		}
+	}, logging.NewWrappedLogger(log.New(io.Discard, "", 0), "api"))
+	return httptest.NewServer(s)
+}
+
+func doRequest(t *testing.T, method, url, token, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v: %v", method, url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestServer_TaskLifecycle(t *testing.T) {
+	tasks := newFakeTasks()
+	ts := newTestServer(tasks, "")
+	defer ts.Close()
+
+	code, body := doRequest(t, http.MethodPost, ts.URL+"/tasks", "",
+		`{"room_id": 1234, "download": {"disk_write_buffer_bytes": 4096}}`)
+	if code != http.StatusCreated {
+		t.Fatalf("add: unexpected status %v: %v", code, body)
+	}
+	config := tasks.configs["1234"]
+	if config.Download.DiskWriteBufferBytes != 4096 {
+		t.Fatalf("unexpected write buffer size: %v", config.Download.DiskWriteBufferBytes)
+	}
+	if config.Download.SaveDirectory != "." {
+		t.Fatalf("unexpected save directory: %v", config.Download.SaveDirectory)
+	}
+	if config.Transport.MaxRetryTimes != recording.DefaultTransportConfig().MaxRetryTimes {
+		t.Fatalf("default transport config is not applied: %v", config.Transport)
+	}
+
+	code, body = doRequest(t, http.MethodPost, ts.URL+"/tasks", "", `{"room_id": 1234}`)
+	if code != http.StatusConflict {
+		t.Fatalf("add duplicated: unexpected status %v: %v", code, body)
+	}
+
+	code, body = doRequest(t, http.MethodGet, ts.URL+"/tasks", "", "")
+	if code != http.StatusOK {
+		t.Fatalf("list: unexpected status %v: %v", code, body)
+	}
+	var infos []map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &infos); err != nil {
+		t.Fatalf("invalid list response %v: %v", body, err)
+	}
+	if len(infos) != 1 || infos[0]["room_id"] != float64(1234) || infos[0]["status"] != "running" {
+		t.Fatalf("unexpected list response: %v", body)
+	}
+
+	code, body = doRequest(t, http.MethodPost, ts.URL+"/tasks/1234/stop", "", "")
+	if code != http.StatusOK || !strings.Contains(body, `"stopped"`) {
+		t.Fatalf("stop: unexpected response %v: %v", code, body)
+	}
+	code, body = doRequest(t, http.MethodPost, ts.URL+"/tasks/1234/start", "", "")
+	if code != http.StatusOK || !strings.Contains(body, `"running"`) {
+		t.Fatalf("start: unexpected response %v: %v", code, body)
+	}
+	code, body = doRequest(t, http.MethodPost, ts.URL+"/tasks/1234/start", "", "")
+	if code != http.StatusConflict {
+		t.Fatalf("start running task: unexpected status %v: %v", code, body)
+	}
+
+	code, body = doRequest(t, http.MethodDelete, ts.URL+"/tasks/1234", "", "")
+	if code != http.StatusNoContent {
+		t.Fatalf("remove: unexpected status %v: %v", code, body)
+	}
+	code, body = doRequest(t, http.MethodGet, ts.URL+"/tasks/1234", "", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("get removed: unexpected status %v: %v", code, body)
+	}
+}
+
+func TestServer_AddResolvesRoom(t *testing.T) {
+	tasks := newFakeTasks()
+	ts := newTestServer(tasks, "")
+	defer ts.Close()
+
+	code, body := doRequest(t, http.MethodPost, ts.URL+"/tasks", "", `{"room": "https://live.bilibili.com/6"}`)
+	if code != http.StatusCreated || !strings.Contains(body, `"room_id":7734200`) {
+		t.Fatalf("add: unexpected response %v: %v", code, body)
+	}
+	if _, ok := tasks.configs["7734200"]; !ok {
+		t.Fatalf("task is not added with the canonical room id: %v", tasks.order)
+	}
+	// the short id is the same room
+	code, body = doRequest(t, http.MethodPost, ts.URL+"/tasks", "", `{"room_id": 6}`)
+	if code != http.StatusConflict {
+		t.Fatalf("add short id: unexpected response %v: %v", code, body)
+	}
+}
+
+func TestServer_Streamer(t *testing.T) {
+	tasks := newFakeTasks()
+	ts := newTestServer(tasks, "")
+	defer ts.Close()
+
+	code, body := doRequest(t, http.MethodPost, ts.URL+"/tasks", "", `{"uid": 9617619}`)
+	if code != http.StatusCreated || !strings.Contains(body, `"key":"uid:9617619"`) {
+		t.Fatalf("add: unexpected response %v: %v", code, body)
+	}
+	code, body = doRequest(t, http.MethodGet, ts.URL+"/tasks/uid:9617619", "", "")
+	if code != http.StatusOK || !strings.Contains(body, `"uid":9617619`) {
+		t.Fatalf("get: unexpected response %v: %v", code, body)
+	}
+	// the uid is not a room
+	code, body = doRequest(t, http.MethodGet, ts.URL+"/tasks/9617619", "", "")
+	if code != http.StatusNotFound {
+		t.Fatalf("get by room: unexpected status %v: %v", code, body)
+	}
+	code, body = doRequest(t, http.MethodDelete, ts.URL+"/tasks/uid:9617619", "", "")
+	if code != http.StatusNoContent {
+		t.Fatalf("remove: unexpected status %v: %v", code, body)
+	}
+}
+
+func TestServer_BadRequests(t *testing.T) {
+	ts := newTestServer(newFakeTasks(), "")
+	defer ts.Close()
+
+	cases := []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/tasks", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"download": {}}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "no_such_key": 1}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": "https://live.bilibili.com/6"}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room": "https://example.com/6"}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "transport": {"allowed_network_types": ["ipv5"]}}`, http.StatusBadRequest},
+		// commands and paths on the host cannot be set with the API
+		{http.MethodPost, "/tasks", `{"room_id": 1, "hooks": [{"events": ["live_started"], "command": "id"}]}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "cookie_file": "/etc/passwd"}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "Cookie_File": "/etc/passwd"}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "download": {"save_directory": "/tmp"}}`, http.StatusBadRequest},
+		{http.MethodPost, "/tasks", `{"room_id": 1, "download": {"file_name_template": "../{title}"}}`, http.StatusBadRequest},
+		{http.MethodGet, "/tasks/abc", ``, http.StatusBadRequest},
+		{http.MethodGet, "/tasks/uid:abc", ``, http.StatusBadRequest},
+		{http.MethodPost, "/tasks/1/stop", ``, http.StatusNotFound},
+		{http.MethodGet, "/tasks/1/stop", ``, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/tasks/1/foo", ``, http.StatusNotFound},
+		{http.MethodPut, "/tasks", ``, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		code, body := doRequest(t, c.method, ts.URL+c.path, "", c.body)
+		if code != c.code {
+			t.Fatalf("%v %v %v: expected status %v, got %v: %v", c.method, c.path, c.body, c.code, code, body)
+		}
+		if !strings.Contains(body, `"error"`) {
+			t.Fatalf("%v %v: missing error message: %v", c.method, c.path, body)
+		}
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	ts := newTestServer(newFakeTasks(), "secret")
+	defer ts.Close()
+
+	if code, _ := doRequest(t, http.MethodGet, ts.URL+"/tasks", "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %v", code)
+	}
+	if code, _ := doRequest(t, http.MethodGet, ts.URL+"/tasks", "wrong", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %v", code)
+	}
+	if code, _ := doRequest(t, http.MethodGet, ts.URL+"/tasks", "secret", ""); code != http.StatusOK {
+		t.Fatalf("expected 200 with correct token, got %v", code)
+	}
+}
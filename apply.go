@@ -0,0 +1,110 @@
+package main
+
+/*
+In this file we apply task configs, from the command line, the config file or a reloaded config file.
+Rooms are resolved to room ids, then running tasks are reconciled with the resolved configs.
+A room which cannot be resolved now, e.g. because of network errors, does not stop other tasks.
+It is skipped, and resolved again with backoff until it is resolved or the config is changed.
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/keuin/slbr/recording"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	roomRetryMinInterval = 10 * time.Second
+	roomRetryMaxInterval = 10 * time.Minute
+)
+
+// taskApplier reconciles running tasks with task configs. It is safe to be used from multiple goroutines.
+type taskApplier struct {
+	ctx      context.Context
+	manager  *recording.TaskManager
+	resolver *recording.RoomResolver
+	logger   *log.Logger
+
+	lock sync.Mutex
+	// configs: tasks of the latest applied config
+	configs []recording.TaskConfig
+	// generation is increased every time a config is applied, so retries of older configs are stopped
+	generation int
+}
+
+func newTaskApplier(
+	ctx context.Context,
+	manager *recording.TaskManager,
+	resolver *recording.RoomResolver,
+	logger *log.Logger,
+) *taskApplier {
+	return &taskApplier{
+		ctx:      ctx,
+		manager:  manager,
+		resolver: resolver,
+		logger:   logger,
+	}
+}
+
+// apply resolves rooms of configs and reconciles running tasks with the resolved ones.
+// Rooms which cannot be resolved now are retried in background.
+// Invalid configs, see recording.ErrInvalidRoomConfig, are skipped if skipInvalid is set.
+// Otherwise, nothing is changed and the error is returned.
+func (a *taskApplier) apply(configs []recording.TaskConfig, skipInvalid bool) (recording.ReconcileResult, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	tasks, err := a.resolver.ResolveAll(configs)
+	if !skipInvalid && errors.Is(err, recording.ErrInvalidRoomConfig) {
+		return recording.ReconcileResult{}, err
+	}
+	a.configs = configs
+	a.generation++
+	result, err2 := a.manager.Reconcile(tasks)
+	if errors.Is(err, recording.ErrRoomUnresolved) {
+		go a.retry(a.generation)
+	}
+	return result, errors.Join(err, err2)
+}
+
+// retry resolves rooms of configs of the generation again with backoff, until all rooms are resolved,
+// another config is applied, or the applier is stopped.
+func (a *taskApplier) retry(generation int) {
+	interval := roomRetryMinInterval
+	for {
+		a.logger.Printf("Some rooms are not resolved, retry in %v.", interval)
+		timer := time.NewTimer(interval)
+		select {
+		case <-a.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		a.lock.Lock()
+		if a.generation != generation {
+			// the retry of the new config is started by apply
+			a.lock.Unlock()
+			return
+		}
+		// resolved rooms are cached, so only unresolved ones are requested
+		tasks, err := a.resolver.ResolveAll(a.configs)
+		result, err2 := a.manager.Reconcile(tasks)
+		a.lock.Unlock()
+		if len(result.Added) > 0 {
+			a.logger.Printf("Tasks of resolved rooms are added: %v", result.Added)
+		}
+		if err2 != nil {
+			a.logger.Printf("Cannot start some tasks: %v", err2)
+		}
+		if !errors.Is(err, recording.ErrRoomUnresolved) {
+			return
+		}
+		interval *= 2
+		if interval > roomRetryMaxInterval {
+			interval = roomRetryMaxInterval
+		}
+	}
+}
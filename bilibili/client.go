@@ -11,12 +11,16 @@ import (
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"time"
 )
 
 const (
 	// userAgent: the default user-agent header to use when communicating with bilibili.
 	userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) " +
 		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Safari/537.36"
+	passportUrlPrefix = "https://passport.bilibili.com"
+	mainApiUrlPrefix  = "https://api.bilibili.com"
+	apiUrlPrefix      = "https://api.live.bilibili.com"
 )
 
 type Bilibili struct {
@@ -25,6 +29,14 @@ type Bilibili struct {
 	ctx       context.Context
 	netTypes  []types.IpNetType
 	logger    logging.Logger
+	// socketTimeout: timeout of connecting and waiting for response headers, zero means no timeout
+	socketTimeout time.Duration
+	stall         StallDetection
+	// passportUrl, mainApiUrl and liveApiUrl are URL prefixes of APIs, they are replaced in tests
+	passportUrl string
+	mainApiUrl  string
+	liveApiUrl  string
+	wbi         wbiSigner
 }
 
 func NewBilibiliWithContext(ctx context.Context, netTypes []types.IpNetType, logger logging.Logger) *Bilibili {
@@ -51,6 +63,10 @@ func NewBilibiliWithContext(ctx context.Context, netTypes []types.IpNetType, log
 		http:      httpClient,
 		ctx:       ctx,
 		netTypes:  nets,
+
+		passportUrl: passportUrlPrefix,
+		mainApiUrl:  mainApiUrlPrefix,
+		liveApiUrl:  apiUrlPrefix,
 	}
 }
 
@@ -59,6 +75,12 @@ func NewBilibiliWithNetType(netTypes []types.IpNetType, logger logging.Logger) *
 	return NewBilibiliWithContext(ctx, netTypes, logger)
 }
 
+// SetSocketTimeout sets the timeout of dialing, TLS handshaking and waiting for response headers.
+// Reading response bodies is not limited, since live streams never end. Zero means no timeout.
+func (b *Bilibili) SetSocketTimeout(timeout time.Duration) {
+	b.socketTimeout = timeout
+}
+
 func NewBilibili(logger logging.Logger) *Bilibili {
 	return NewBilibiliWithNetType(nil, logger)
 }
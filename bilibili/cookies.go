@@ -0,0 +1,200 @@
+/*
+In this file we import and persist cookies of logged-in sessions.
+Cookie files are either Netscape cookies.txt files, which are exported by curl and many browser extensions,
+or JSON files. We write JSON files, which are arrays of cookies in the format of common browser extensions,
+so they can be read again by both us and the extensions.
+*/
+package bilibili
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookies of a logged-in session.
+const (
+	// CookieSessData authenticates the session
+	CookieSessData = "SESSDATA"
+	// CookieBiliJct is the CSRF token
+	CookieBiliJct = "bili_jct"
+	// CookieUid is the uid of the logged-in user
+	CookieUid = "DedeUserID"
+)
+
+// cookieDomain is used for cookies without a domain
+const cookieDomain = ".bilibili.com"
+
+// jsonCookie is a cookie in JSON cookie files.
+type jsonCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// ExpirationDate is in seconds since the epoch, zero means a session cookie
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	Secure         bool    `json:"secure,omitempty"`
+	HttpOnly       bool    `json:"httpOnly,omitempty"`
+}
+
+// ReadCookieFile reads cookies from a Netscape cookies.txt file or a JSON file.
+func ReadCookieFile(filePath string) ([]*http.Cookie, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := ParseCookies(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie file \"%v\": %w", filePath, err)
+	}
+	return cookies, nil
+}
+
+// ParseCookies parses the content of a cookie file. The format is detected automatically:
+// a JSON array of cookies, a JSON object which maps names to values, or a Netscape cookies.txt file.
+func ParseCookies(data []byte) ([]*http.Cookie, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		var jsonCookies []jsonCookie
+		if err := json.Unmarshal(data, &jsonCookies); err != nil {
+			return nil, err
+		}
+		var cookies []*http.Cookie
+		for _, c := range jsonCookies {
+			if c.Name == "" {
+				continue
+			}
+			cookie := &http.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				Secure:   c.Secure,
+				HttpOnly: c.HttpOnly,
+			}
+			if c.ExpirationDate > 0 {
+				sec, frac := math.Modf(c.ExpirationDate)
+				cookie.Expires = time.Unix(int64(sec), int64(frac*1e9))
+			}
+			cookies = append(cookies, cookie)
+		}
+		return cookies, nil
+	case bytes.HasPrefix(data, []byte("{")):
+		var values map[string]string
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		var cookies []*http.Cookie
+		for k, v := range values {
+			cookies = append(cookies, &http.Cookie{Name: k, Value: v})
+		}
+		return cookies, nil
+	default:
+		return parseNetscapeCookies(data)
+	}
+}
+
+// parseNetscapeCookies parses cookies.txt files. Each line has 7 fields separated by tabs:
+// domain, include subdomains, path, secure, expiration time, name and value.
+func parseNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, "#HttpOnly_") {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %v: expected 7 fields separated by tabs, got %v", lineNo, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid expiration time: %w", lineNo, err)
+		}
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, s.Err()
+}
+
+// WriteCookieFile writes cookies to a JSON file, which is readable only by the current user.
+func WriteCookieFile(filePath string, cookies []*http.Cookie) error {
+	jsonCookies := make([]jsonCookie, 0, len(cookies))
+	for _, c := range cookies {
+		jc := jsonCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+		if !c.Expires.IsZero() {
+			jc.ExpirationDate = float64(c.Expires.Unix())
+		}
+		jsonCookies = append(jsonCookies, jc)
+	}
+	data, err := json.MarshalIndent(jsonCookies, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// FindCookie returns the value of the cookie with the given name, empty if not found.
+func FindCookie(cookies []*http.Cookie, name string) string {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// SetCookies adds cookies of bilibili.com to the cookie jar, cookies of other domains are ignored.
+// Cookies without a domain are sent to all hosts of bilibili.com.
+func (b *Bilibili) SetCookies(cookies []*http.Cookie) {
+	for _, c := range cookies {
+		c := *c
+		if c.Domain == "" {
+			c.Domain = cookieDomain
+		}
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host != "bilibili.com" && !strings.HasSuffix(host, ".bilibili.com") {
+			continue
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		b.http.Jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: "/"}, []*http.Cookie{&c})
+	}
+}
@@ -0,0 +1,118 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCookies_Netscape(t *testing.T) {
+	data := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		"#HttpOnly_.bilibili.com\tTRUE\t/\tFALSE\t1893456000\tSESSDATA\tsess%2Cdata\n" +
+		".bilibili.com\tTRUE\t/\tFALSE\t1893456000\tbili_jct\tjct\r\n" +
+		".bilibili.com\tTRUE\t/\tFALSE\t0\tDedeUserID\t12345\n"
+	cookies, err := ParseCookies([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseCookies: %v", err)
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("expected 3 cookies, got %v", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != CookieSessData || c.Value != "sess%2Cdata" || !c.HttpOnly || c.Domain != ".bilibili.com" {
+		t.Fatalf("unexpected cookie: %+v", c)
+	}
+	if !c.Expires.Equal(time.Unix(1893456000, 0)) {
+		t.Fatalf("unexpected expiration time: %v", c.Expires)
+	}
+	if FindCookie(cookies, CookieBiliJct) != "jct" {
+		t.Fatalf("unexpected bili_jct: %v", FindCookie(cookies, CookieBiliJct))
+	}
+	if !cookies[2].Expires.IsZero() {
+		t.Fatalf("session cookie has expiration time %v", cookies[2].Expires)
+	}
+
+	_, err = ParseCookies([]byte(".bilibili.com\tTRUE\t/\tFALSE\n"))
+	if err == nil {
+		t.Fatalf("invalid line is accepted")
+	}
+}
+
+func TestParseCookies_Json(t *testing.T) {
+	data := `[
+		{"name": "SESSDATA", "value": "sess", "domain": ".bilibili.com", "path": "/",
+		 "expirationDate": 1893456000.5, "httpOnly": true, "hostOnly": false},
+		{"name": "bili_jct", "value": "jct", "domain": ".bilibili.com", "session": true}
+	]`
+	cookies, err := ParseCookies([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseCookies: %v", err)
+	}
+	if len(cookies) != 2 || FindCookie(cookies, CookieSessData) != "sess" || FindCookie(cookies, CookieBiliJct) != "jct" {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	if cookies[0].Expires.Unix() != 1893456000 || !cookies[1].Expires.IsZero() {
+		t.Fatalf("unexpected expiration time: %v, %v", cookies[0].Expires, cookies[1].Expires)
+	}
+
+	cookies, err = ParseCookies([]byte(`{"SESSDATA": "sess", "bili_jct": "jct"}`))
+	if err != nil {
+		t.Fatalf("ParseCookies: %v", err)
+	}
+	if len(cookies) != 2 || FindCookie(cookies, CookieSessData) != "sess" {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+}
+
+func TestWriteCookieFile(t *testing.T) {
+	cookies, err := ParseCookies([]byte("#HttpOnly_.bilibili.com\tTRUE\t/\tTRUE\t1893456000\tSESSDATA\tsess\n"))
+	if err != nil {
+		t.Fatalf("ParseCookies: %v", err)
+	}
+	p := filepath.Join(t.TempDir(), "cookies.json")
+	if err := WriteCookieFile(p, cookies); err != nil {
+		t.Fatalf("WriteCookieFile: %v", err)
+	}
+	read, err := ReadCookieFile(p)
+	if err != nil {
+		t.Fatalf("ReadCookieFile: %v", err)
+	}
+	if len(read) != 1 {
+		t.Fatalf("expected 1 cookie, got %v", len(read))
+	}
+	c, expected := read[0], cookies[0]
+	if c.Name != expected.Name || c.Value != expected.Value || c.Domain != expected.Domain || c.Path != expected.Path ||
+		!c.Expires.Equal(expected.Expires) || c.Secure != expected.Secure || c.HttpOnly != expected.HttpOnly {
+		t.Fatalf("unexpected cookie: %+v, expected: %+v", c, expected)
+	}
+}
+
+func TestBilibili_SetCookies(t *testing.T) {
+	cookies, err := ParseCookies([]byte(`[
+		{"name": "SESSDATA", "value": "sess", "domain": ".bilibili.com", "path": "/"},
+		{"name": "www", "value": "1", "domain": "www.bilibili.com", "path": "/"},
+		{"name": "other", "value": "1", "domain": ".example.com", "path": "/"}
+	]`))
+	if err != nil {
+		t.Fatalf("ParseCookies: %v", err)
+	}
+	cookies = append(cookies, &http.Cookie{Name: "bili_jct", Value: "jct"})
+	bi := newTestBilibili()
+	bi.SetCookies(cookies)
+
+	u, _ := url.Parse(apiUrlPrefix)
+	sent := bi.http.Jar.Cookies(u)
+	if FindCookie(sent, CookieSessData) != "sess" || FindCookie(sent, CookieBiliJct) != "jct" {
+		t.Fatalf("session cookies are not sent to %v: %v", u, sent)
+	}
+	if FindCookie(sent, "www") != "" || FindCookie(sent, "other") != "" {
+		t.Fatalf("unexpected cookies are sent to %v: %v", u, sent)
+	}
+	u, _ = url.Parse("https://www.bilibili.com")
+	if FindCookie(bi.http.Jar.Cookies(u), "www") != "1" {
+		t.Fatalf("cookie is not sent to %v", u)
+	}
+}
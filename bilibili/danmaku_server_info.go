@@ -7,11 +7,9 @@ import (
 	"net/url"
 )
 
-const apiUrlPrefix = "https://api.live.bilibili.com"
-
 func (b *Bilibili) GetDanmakuServerInfo(roomId types.RoomId) (resp types.DanmakuServerInfoResponse, err error) {
-	u := fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v1/index/getDanmuInfo?id=%d&type=0", roomId)
-	return callGet[types.DanmakuServerInfoResponse](b, u)
+	u := fmt.Sprintf("%s/xlive/web-room/v1/index/getDanmuInfo?id=%d&type=0", b.liveApiUrl, roomId)
+	return callGet[types.DanmakuServerInfoResponse](b, u, withWbi)
 }
 
 // GetBUVID initializes cookie `buvid3`. If success, returns its value.
@@ -37,8 +35,8 @@ func (b *Bilibili) GetBUVID() (string, error) {
 
 // GetLiveBUVID initializes cookie `LIVE_BUVID`. This should be called before GetDanmakuServerInfo.
 func (b *Bilibili) GetLiveBUVID(roomId types.RoomId) (resp types.WebBannerResponse, err error) {
-	u := fmt.Sprintf("https://api.live.bilibili.com/activity/v1/Common/webBanner?"+
-		"platform=web&position=6&roomid=%d&area_v2_parent_id=0&area_v2_id=0&from=", roomId)
+	u := fmt.Sprintf("%s/activity/v1/Common/webBanner?"+
+		"platform=web&position=6&roomid=%d&area_v2_parent_id=0&area_v2_id=0&from=", b.liveApiUrl, roomId)
 	resp, err = callGet[types.WebBannerResponse](b, u)
 	if err == nil {
 		uu, _ := url.Parse(apiUrlPrefix)
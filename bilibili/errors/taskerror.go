@@ -46,6 +46,12 @@ const (
 	MessageDecompression
 	// JsonDecode means we cannot decode a datum which is expected to be a JSON object string
 	JsonDecode
+	// DiskFull means the free space of the save directory is too low to go on recording
+	DiskFull
+	// LoadCookies means the cookie file cannot be read
+	LoadCookies
+	// RoomChanged means the streamer of a task has moved to another live room
+	RoomChanged
 )
 
 var recoverableErrors = []Type{
@@ -59,6 +65,7 @@ var recoverableErrors = []Type{
 	DanmakuExchangeRead,
 	GetDanmakuServerInfo,
 	RecoverLiveStatusChecker,
+	RoomChanged,
 }
 
 var errorStrings = map[Type]string{
@@ -79,6 +86,41 @@ var errorStrings = map[Type]string{
 	InvalidAuthProtocol:      "authentication failed, invalid protocol",
 	MessageDecompression:     "failed to decompress server message",
 	JsonDecode:               "invalid JSON response from server",
+	DiskFull:                 "not enough free disk space",
+	LoadCookies:              "failed to load cookies",
+	RoomChanged:              "the live room of the streamer is changed",
+}
+
+// typeNames are identifiers of error types, which are used as metric labels.
+var typeNames = map[Type]string{
+	GetRoomInfo:              "get_room_info",
+	GetLiveInfo:              "get_live_info",
+	StreamCopy:               "stream_copy",
+	LiveEnded:                "live_ended",
+	DanmakuServerConnection:  "danmaku_server_connection",
+	Heartbeat:                "heartbeat",
+	InitialLiveStatus:        "initial_live_status",
+	DanmakuExchangeRead:      "danmaku_exchange_read",
+	GetDanmakuServerInfo:     "get_danmaku_server_info",
+	RecoverLiveStatusChecker: "recover_live_status_checker",
+	FileCreation:             "file_creation",
+	InvalidLiveInfo:          "invalid_live_info",
+	LiveStatusWatch:          "live_status_watch",
+	Unknown:                  "unknown",
+	InvalidAuthProtocol:      "invalid_auth_protocol",
+	MessageDecompression:     "message_decompression",
+	JsonDecode:               "json_decode",
+	DiskFull:                 "disk_full",
+	LoadCookies:              "load_cookies",
+	RoomChanged:              "room_changed",
+}
+
+// Name returns the identifier of this error type, e.g. "stream_copy".
+func (t Type) Name() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("type_%d", int(t))
 }
 
 func (t Type) String() string {
@@ -98,6 +140,10 @@ func (e *taskError) Message() string {
 	panic("implement me")
 }
 
+func (e *taskError) Type() Type {
+	return e.typ
+}
+
 func (e *taskError) IsRecoverable() bool {
 	return lo.Contains(recoverableErrors, e.typ)
 }
@@ -128,6 +174,8 @@ func (e taskError) Error() string {
 }
 
 type TaskError interface {
+	// Type returns the type of this task error.
+	Type() Type
 	// IsRecoverable reports if this task error is safe to retry.
 	IsRecoverable() bool
 	// Unwrap returns the underneath errors which cause the task error.
@@ -144,3 +192,12 @@ func NewError(typ Type, err ...error) TaskError {
 		err: err,
 	}
 }
+
+// Wrap returns err if it is already a TaskError, so the original type is kept.
+// Otherwise, err is wrapped in a new TaskError of typ.
+func Wrap(typ Type, err error) TaskError {
+	if taskErr, ok := err.(TaskError); ok {
+		return taskErr
+	}
+	return NewError(typ, err)
+}
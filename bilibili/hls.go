@@ -0,0 +1,391 @@
+package bilibili
+
+/*
+HLS stream downloader.
+The media playlist is polled periodically, new segments are appended to the output in the order of sequence numbers.
+For fMP4 streams, the initialization section (EXT-X-MAP) is written before the first segment,
+and written again if it is changed, or if the output starts a new file (see HlsSplitter).
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/common/pretty"
+	"github.com/keuin/slbr/types"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hlsMinPollInterval limits how often the playlist is fetched
+	hlsMinPollInterval = 1 * time.Second
+	// hlsSegmentRetryTimes: how many times a segment is retried before giving up
+	hlsSegmentRetryTimes    = 3
+	hlsSegmentRetryInterval = 1 * time.Second
+)
+
+// HlsSplitter may be implemented by the writer returned by the fileCreator of CopyHlsStream,
+// if the writer splits the stream into files. NextSegment is called before every segment except the first one.
+// If it reports that a new file is started, the current initialization section is written to it again,
+// so every file starts with a complete segment and can be played.
+type HlsSplitter interface {
+	NextSegment() (newFile bool, err error)
+}
+
+// CopyHlsStream downloads an HLS live stream and writes segments to a writer.
+// The contract is the same as CopyLiveStream: the file is created by fileCreator
+// when the first segment is available, and io.EOF is returned when the live is ended.
+func (b *Bilibili) CopyHlsStream(
+	ctx context.Context,
+	roomId types.RoomId,
+	stream types.StreamingUrlInfo,
+	fileCreator func() (io.Writer, error),
+	bufSize int64,
+) (err error) {
+	playlistUrl := stream.URL
+	if !strings.HasPrefix(playlistUrl, "https://") &&
+		!strings.HasPrefix(playlistUrl, "http://") {
+		return fmt.Errorf("invalid URL: %v", playlistUrl)
+	}
+	referer := fmt.Sprintf("https://live.bilibili.com/blanc/%d?liteVersion=true", roomId)
+
+	ctx, cancelCopy := context.WithCancelCause(ctx)
+	defer cancelCopy(nil)
+
+	var out io.Writer
+	var n atomic.Int64
+	stopWatchdog := b.startStallWatchdog(&n, cancelCopy)
+	defer stopWatchdog()
+	startTime := time.Now()
+	var stopProgressReport func()
+	defer func() {
+		if stopProgressReport != nil {
+			stopProgressReport()
+			b.logger.Info("Total downloaded: %v", pretty.Bytes(uint64(n.Load())))
+		}
+	}()
+
+	// the last written segment, segments with smaller or equal sequence numbers are skipped
+	lastSeq := int64(-1)
+	currentMap := ""
+	var initSection []byte
+	var splitter HlsSplitter
+	b.logger.Info("Waiting for HLS segments...")
+	for {
+		var playlist hlsPlaylist
+		playlist, err = b.getHlsPlaylist(ctx, playlistUrl, referer)
+		if err != nil {
+			break
+		}
+		if len(playlist.variants) > 0 {
+			// this is a master playlist, use the first variant.
+			// Only one level is allowed, otherwise the playlists may redirect to each other forever
+			if playlistUrl != stream.URL {
+				err = fmt.Errorf("HLS variant stream %v is a master playlist", playlistUrl)
+				break
+			}
+			playlistUrl = playlist.variants[0]
+			b.logger.Info("Using HLS variant stream: %v", playlistUrl)
+			continue
+		}
+
+		for _, seg := range playlist.segments {
+			if int64(seg.seq) <= lastSeq {
+				continue
+			}
+			if lastSeq >= 0 && int64(seg.seq) != lastSeq+1 {
+				b.logger.Warning("HLS segments %v-%v are missing", lastSeq+1, seg.seq-1)
+			}
+			if out == nil {
+				out, err = fileCreator()
+				if err != nil {
+					b.logger.Error("Cannot open file for writing: %v", err)
+					return errs.Wrap(errs.FileCreation, err)
+				}
+				b.logger.Info("Stream is started. Receiving live stream...")
+				stopProgressReport = b.startProgressReport(&n, startTime)
+				splitter, _ = out.(HlsSplitter)
+			} else if splitter != nil {
+				var newFile bool
+				newFile, err = splitter.NextSegment()
+				if err != nil {
+					break
+				}
+				if newFile && seg.mapUri == currentMap && initSection != nil {
+					_, err = out.Write(initSection)
+					if err != nil {
+						break
+					}
+				}
+			}
+			if seg.mapUri != "" && seg.mapUri != currentMap {
+				// the section is kept, since it is written again to new files
+				var buf bytes.Buffer
+				err = b.copyHlsResource(ctx, seg.mapUri, referer, &buf, bufSize, &n)
+				if err != nil {
+					err = fmt.Errorf("cannot download HLS initialization section: %w", err)
+					break
+				}
+				_, err = out.Write(buf.Bytes())
+				if err != nil {
+					break
+				}
+				currentMap = seg.mapUri
+				initSection = buf.Bytes()
+			}
+			err = b.copyHlsResource(ctx, seg.uri, referer, out, bufSize, &n)
+			if err != nil {
+				err = fmt.Errorf("cannot download HLS segment %v: %w", seg.seq, err)
+				break
+			}
+			lastSeq = int64(seg.seq)
+		}
+		if err != nil {
+			break
+		}
+		if playlist.ended {
+			err = io.EOF
+			break
+		}
+
+		interval := playlist.targetDuration / 2
+		if interval < hlsMinPollInterval {
+			interval = hlsMinPollInterval
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		case <-timer.C:
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	err = stallCause(ctx, err)
+	if errors.Is(err, ErrStreamStalled) {
+		// aborted by the watchdog, the caller should reconnect
+		b.logger.Warning("The stream is stalled, stop copying.")
+		return errs.NewError(errs.StreamCopy, err)
+	} else if errors.Is(err, context.Canceled) {
+		b.logger.Info("Stop copying...")
+	} else if errors.Is(err, io.EOF) {
+		b.logger.Info("The live is ended. (room %v)", roomId)
+	} else {
+		b.logger.Error("Stream copying was interrupted unexpectedly: %v", err)
+	}
+	return err
+}
+
+func (b *Bilibili) getHlsPlaylist(ctx context.Context, playlistUrl string, referer string) (hlsPlaylist, error) {
+	resp, err := b.hlsGet(ctx, playlistUrl, referer)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// 404 when not streaming
+	if resp.StatusCode == http.StatusNotFound {
+		return hlsPlaylist{}, fmt.Errorf("live is not started or the room does not exist")
+	}
+	if err := validateHttpStatus(resp); err != nil {
+		return hlsPlaylist{}, err
+	}
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	return parseHlsPlaylist(resp.Body, base)
+}
+
+// copyHlsResource downloads a segment or an initialization section to out.
+// Failed requests are retried, but a partially written resource is not.
+func (b *Bilibili) copyHlsResource(
+	ctx context.Context,
+	resourceUrl string,
+	referer string,
+	out io.Writer,
+	bufSize int64,
+	n *atomic.Int64,
+) (err error) {
+	var resp *http.Response
+	for i := 0; i < hlsSegmentRetryTimes; i++ {
+		if i > 0 {
+			b.logger.Warning("Cannot download %v: %v. Retrying...", resourceUrl, err)
+			timer := time.NewTimer(hlsSegmentRetryInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		resp, err = b.hlsGet(ctx, resourceUrl, referer)
+		if err != nil {
+			continue
+		}
+		err = validateHttpStatus(resp)
+		if err != nil {
+			_ = resp.Body.Close()
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body := &countingReader{r: resp.Body, n: n}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		_, err = io.CopyN(out, body, bufSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Bilibili) hlsGet(ctx context.Context, url string, referer string) (*http.Response, error) {
+	r, err := b.newGet(url)
+	if err != nil {
+		b.logger.Error("Cannot create HTTP GET instance on %v: %v", url, err)
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Referer", referer)
+	return b.Do(r)
+}
+
+type hlsSegment struct {
+	seq uint64
+	uri string
+	// mapUri: URI of the initialization section, empty if not present
+	mapUri string
+}
+
+type hlsPlaylist struct {
+	targetDuration time.Duration
+	segments       []hlsSegment
+	// variants: URIs of media playlists, only present in master playlists
+	variants []string
+	// ended: the playlist has EXT-X-ENDLIST, no more segments will be added
+	ended bool
+}
+
+// parseHlsPlaylist parses a master or media playlist. Relative URIs are resolved against base.
+func parseHlsPlaylist(r io.Reader, base *url.URL) (p hlsPlaylist, err error) {
+	resolve := func(uri string) (string, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", fmt.Errorf("invalid URI in playlist: %v", uri)
+		}
+		return base.ResolveReference(u).String(), nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	first := true
+	var seq uint64
+	mapUri := ""
+	streamInf := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if first {
+			if line != "#EXTM3U" {
+				return p, fmt.Errorf("invalid playlist: missing #EXTM3U")
+			}
+			first = false
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			uri, err := resolve(line)
+			if err != nil {
+				return p, err
+			}
+			if streamInf {
+				p.variants = append(p.variants, uri)
+				streamInf = false
+			} else {
+				p.segments = append(p.segments, hlsSegment{seq: seq, uri: uri, mapUri: mapUri})
+				seq++
+			}
+			continue
+		}
+		tag, value, _ := strings.Cut(line, ":")
+		switch tag {
+		case "#EXT-X-TARGETDURATION":
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid target duration: %v", value)
+			}
+			p.targetDuration = time.Duration(d * float64(time.Second))
+		case "#EXT-X-MEDIA-SEQUENCE":
+			seq, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid media sequence: %v", value)
+			}
+		case "#EXT-X-MAP":
+			uri := hlsAttribute(value, "URI")
+			if uri == "" {
+				return p, fmt.Errorf("invalid EXT-X-MAP: %v", value)
+			}
+			mapUri, err = resolve(uri)
+			if err != nil {
+				return p, err
+			}
+		case "#EXT-X-STREAM-INF":
+			streamInf = true
+		case "#EXT-X-ENDLIST":
+			p.ended = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return p, err
+	}
+	if first {
+		return p, fmt.Errorf("invalid playlist: empty")
+	}
+	return p, nil
+}
+
+// hlsAttribute returns the value of an attribute in an attribute list, e.g. `URI="init.mp4",BYTERANGE="..."`.
+func hlsAttribute(list string, name string) string {
+	for len(list) > 0 {
+		var key, value string
+		key, list, _ = strings.Cut(list, "=")
+		if strings.HasPrefix(list, `"`) {
+			var ok bool
+			value, list, ok = strings.Cut(list[1:], `"`)
+			if !ok {
+				return ""
+			}
+			list = strings.TrimPrefix(list, ",")
+		} else {
+			value, list, _ = strings.Cut(list, ",")
+		}
+		if strings.TrimSpace(key) == name {
+			return value
+		}
+	}
+	return ""
+}
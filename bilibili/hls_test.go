@@ -0,0 +1,281 @@
+package bilibili
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseHlsPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://example.com/live/1234/index.m3u8?token=abc")
+	p, err := parseHlsPlaylist(strings.NewReader(`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-MAP:URI="h100.m4s"
+#EXTINF:1.000,
+100.m4s
+#EXTINF:1.000,
+/other/101.m4s
+#EXT-X-MAP:URI="h102.m4s",BYTERANGE="1000@0"
+#EXTINF:1.000,
+https://cdn.example.com/102.m4s
+#EXT-X-ENDLIST
+`), base)
+	if err != nil {
+		t.Fatalf("parseHlsPlaylist: %v", err)
+	}
+	if p.targetDuration != 2*time.Second || !p.ended || len(p.variants) != 0 {
+		t.Fatalf("unexpected playlist: %+v", p)
+	}
+	expected := []hlsSegment{
+		{100, "https://example.com/live/1234/100.m4s", "https://example.com/live/1234/h100.m4s"},
+		{101, "https://example.com/other/101.m4s", "https://example.com/live/1234/h100.m4s"},
+		{102, "https://cdn.example.com/102.m4s", "https://example.com/live/1234/h102.m4s"},
+	}
+	if len(p.segments) != len(expected) {
+		t.Fatalf("unexpected segments: %+v", p.segments)
+	}
+	for i := range expected {
+		if p.segments[i] != expected[i] {
+			t.Fatalf("segment %v: expected %+v, got %+v", i, expected[i], p.segments[i])
+		}
+	}
+
+	p, err = parseHlsPlaylist(strings.NewReader(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="avc1.64001f"
+variant/index.m3u8
+`), base)
+	if err != nil {
+		t.Fatalf("parseHlsPlaylist: %v", err)
+	}
+	if len(p.variants) != 1 || p.variants[0] != "https://example.com/live/1234/variant/index.m3u8" {
+		t.Fatalf("unexpected variants: %v", p.variants)
+	}
+
+	_, err = parseHlsPlaylist(strings.NewReader("not a playlist\n"), base)
+	if err == nil {
+		t.Fatalf("invalid playlist is accepted")
+	}
+}
+
+// hlsTestServer serves a live playlist which moves forward on every request.
+type hlsTestServer struct {
+	lock sync.Mutex
+	// playlists are served in order, the last one is served repeatedly
+	playlists []string
+	requests  int
+	failures  map[string]int
+}
+
+func (s *hlsTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if r.Header.Get("Referer") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/index.m3u8" {
+		i := s.requests
+		if i >= len(s.playlists) {
+			i = len(s.playlists) - 1
+		}
+		s.requests++
+		_, _ = io.WriteString(w, s.playlists[i])
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if s.failures[name] > 0 {
+		s.failures[name]--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = io.WriteString(w, "<"+name+">")
+}
+
+func livePlaylist(first int, count int, ended bool) string {
+	sb := strings.Builder{}
+	sb.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:1\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", first))
+	sb.WriteString("#EXT-X-MAP:URI=\"init.m4s\"\n")
+	for i := first; i < first+count; i++ {
+		sb.WriteString(fmt.Sprintf("#EXTINF:1.0,\n%d.m4s\n", i))
+	}
+	if ended {
+		sb.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return sb.String()
+}
+
+func newTestBilibili() *Bilibili {
+	return NewBilibili(logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test-logger"))
+}
+
+func TestBilibili_CopyHlsStream(t *testing.T) {
+	hs := &hlsTestServer{
+		playlists: []string{
+			livePlaylist(10, 2, false),
+			livePlaylist(11, 2, false),
+			livePlaylist(12, 2, true),
+		},
+		failures: map[string]int{"12.m4s": 1},
+	}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	var out bytes.Buffer
+	created := 0
+	bi := newTestBilibili()
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8", Protocol: types.ProtocolHls}
+	err := bi.CopyHlsStream(context.Background(), 1234, stream, func() (io.Writer, error) {
+		created++
+		return &out, nil
+	}, 2)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if created != 1 {
+		t.Fatalf("file is created %v times", created)
+	}
+	expected := "<init.m4s><10.m4s><11.m4s><12.m4s><13.m4s>"
+	if out.String() != expected {
+		t.Fatalf("unexpected output: %v, expected: %v", out.String(), expected)
+	}
+}
+
+// partWriter starts a new part every two segments
+type partWriter struct {
+	parts    []*bytes.Buffer
+	segments int
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	return w.parts[len(w.parts)-1].Write(p)
+}
+
+func (w *partWriter) NextSegment() (bool, error) {
+	w.segments++
+	if w.segments%2 != 0 {
+		return false, nil
+	}
+	w.parts = append(w.parts, &bytes.Buffer{})
+	return true, nil
+}
+
+func TestBilibili_CopyHlsStream_Split(t *testing.T) {
+	hs := &hlsTestServer{playlists: []string{livePlaylist(10, 5, true)}}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	w := &partWriter{parts: []*bytes.Buffer{{}}}
+	bi := newTestBilibili()
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8", Protocol: types.ProtocolHls}
+	err := bi.CopyHlsStream(context.Background(), 1234, stream, func() (io.Writer, error) {
+		return w, nil
+	}, 2)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	// every part starts with the initialization section
+	expected := []string{
+		"<init.m4s><10.m4s><11.m4s>",
+		"<init.m4s><12.m4s><13.m4s>",
+		"<init.m4s><14.m4s>",
+	}
+	if len(w.parts) != len(expected) {
+		t.Fatalf("expected %v parts, got %v", len(expected), len(w.parts))
+	}
+	for i, part := range w.parts {
+		if part.String() != expected[i] {
+			t.Fatalf("part %v: unexpected output: %v, expected: %v", i+1, part.String(), expected[i])
+		}
+	}
+}
+
+func TestBilibili_CopyHlsStream_Cancel(t *testing.T) {
+	hs := &hlsTestServer{playlists: []string{livePlaylist(0, 3, false)}}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out bytes.Buffer
+	bi := newTestBilibili()
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8", Protocol: types.ProtocolHls}
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- bi.CopyHlsStream(ctx, 1234, stream, func() (io.Writer, error) {
+			return &out, nil
+		}, 1024)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-chErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CopyHlsStream is not stopped after cancelled")
+	}
+	if out.String() != "<init.m4s><0.m4s><1.m4s><2.m4s>" {
+		t.Fatalf("unexpected output: %v", out.String())
+	}
+}
+
+func TestBilibili_CopyHlsStream_NestedMasterPlaylist(t *testing.T) {
+	// the variant is the master playlist itself
+	hs := &hlsTestServer{playlists: []string{"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000\nindex.m3u8\n"}}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	bi := newTestBilibili()
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8?token=1", Protocol: types.ProtocolHls}
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- bi.CopyHlsStream(context.Background(), 1234, stream, func() (io.Writer, error) {
+			return io.Discard, nil
+		}, 1024)
+	}()
+	select {
+	case err := <-chErr:
+		if err == nil || errors.Is(err, io.EOF) {
+			t.Fatalf("expected an error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CopyHlsStream follows master playlists forever")
+	}
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if hs.requests != 2 {
+		t.Fatalf("playlists are requested %v times", hs.requests)
+	}
+}
+
+func TestBilibili_CopyHlsStream_FileCreationError(t *testing.T) {
+	hs := &hlsTestServer{playlists: []string{livePlaylist(0, 1, true)}}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	testErr := fmt.Errorf("test error")
+	bi := newTestBilibili()
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8", Protocol: types.ProtocolHls}
+	err := bi.CopyHlsStream(context.Background(), 1234, stream, func() (io.Writer, error) {
+		return nil, testErr
+	}, 1024)
+	if !errors.Is(err, testErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
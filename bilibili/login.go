@@ -0,0 +1,97 @@
+/*
+In this file we implement the web QR code login and the login status query.
+The QR code is scanned with the bilibili app. When the login is confirmed,
+the passport server sets cookies of the session in the response of the polling request.
+*/
+package bilibili
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/types"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// qrLoginPollInterval is how often the QR code status is checked, it is replaced in tests
+var qrLoginPollInterval = 2 * time.Second
+
+// ErrQrCodeExpired is returned by LoginWithQrCode if the QR code is not confirmed in time.
+var ErrQrCodeExpired = errors.New("the QR code is expired")
+
+// GenerateLoginQrCode creates a QR code for the web login.
+func (b *Bilibili) GenerateLoginQrCode() (resp types.QrCodeGenerateResponse, err error) {
+	u := b.passportUrl + "/x/passport-login/web/qrcode/generate"
+	return callGet[types.QrCodeGenerateResponse](b, u)
+}
+
+// PollLoginQrCode checks the status of the QR code.
+// If the login is confirmed, cookies of the session are returned.
+func (b *Bilibili) PollLoginQrCode(qrCodeKey string) (resp types.QrCodePollResponse, cookies []*http.Cookie, err error) {
+	u := b.passportUrl + "/x/passport-login/web/qrcode/poll?qrcode_key=" + url.QueryEscape(qrCodeKey)
+	r, data, err := callGetRaw(b, u)
+	if err != nil {
+		return
+	}
+	resp, err = decodeResponse[types.QrCodePollResponse](b, u, data)
+	if err != nil {
+		return
+	}
+	if resp.Code == 0 && resp.Data.Code == types.QrCodeConfirmed {
+		cookies = r.Cookies()
+	}
+	return
+}
+
+// LoginWithQrCode runs the web QR code login until the QR code is confirmed or expired.
+// show is called with the content of the QR code, which should be displayed to the user.
+func (b *Bilibili) LoginWithQrCode(show func(qrCodeUrl string)) ([]*http.Cookie, error) {
+	qr, err := b.GenerateLoginQrCode()
+	if err != nil {
+		return nil, err
+	}
+	if qr.Code != 0 {
+		return nil, fmt.Errorf("bilibili API error: %v", qr.Message)
+	}
+	show(qr.Data.Url)
+
+	lastStatus := types.QrCodeWaiting
+	for {
+		select {
+		case <-b.ctx.Done():
+			return nil, b.ctx.Err()
+		case <-time.After(qrLoginPollInterval):
+		}
+		resp, cookies, err := b.PollLoginQrCode(qr.Data.QrCodeKey)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Code != 0 {
+			return nil, fmt.Errorf("bilibili API error: %v", resp.Message)
+		}
+		status := resp.Data.Code
+		switch status {
+		case types.QrCodeConfirmed:
+			if FindCookie(cookies, CookieSessData) == "" {
+				return nil, fmt.Errorf("the login is confirmed, but no session cookie is received")
+			}
+			return cookies, nil
+		case types.QrCodeExpired:
+			return nil, ErrQrCodeExpired
+		case types.QrCodeScanned:
+			if lastStatus != status {
+				b.logger.Info("The QR code is scanned, please confirm the login in the app.")
+			}
+		case types.QrCodeWaiting:
+		default:
+			return nil, fmt.Errorf("unexpected QR code status %v: %v", status, resp.Data.Message)
+		}
+		lastStatus = status
+	}
+}
+
+// GetLoginInfo returns the login status of the session.
+func (b *Bilibili) GetLoginInfo() (resp types.NavResponse, err error) {
+	return callGet[types.NavResponse](b, b.mainApiUrl+"/x/web-interface/nav")
+}
@@ -0,0 +1,111 @@
+package bilibili
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPassportTestServer mocks the QR code login. Polling returns statuses in turn, the last one is repeated.
+func newPassportTestServer(statuses ...int) *httptest.Server {
+	var polls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/x/passport-login/web/qrcode/generate", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"code":0,"message":"0","ttl":1,`+
+			`"data":{"url":"https://account.bilibili.com/h5/account-h5/auth/scan-web?qrcode_key=key","qrcode_key":"key"}}`)
+	})
+	mux.HandleFunc("/x/passport-login/web/qrcode/poll", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("qrcode_key") != "key" {
+			_, _ = fmt.Fprint(w, `{"code":-400,"message":"invalid key","ttl":1}`)
+			return
+		}
+		i := int(polls.Add(1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		if statuses[i] == 0 {
+			http.SetCookie(w, &http.Cookie{Name: CookieSessData, Value: "sess", Domain: ".bilibili.com", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: CookieBiliJct, Value: "jct", Domain: ".bilibili.com", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: CookieUid, Value: "12345", Domain: ".bilibili.com", Path: "/"})
+		}
+		_, _ = fmt.Fprintf(w, `{"code":0,"message":"0","ttl":1,"data":{"code":%v,"message":""}}`, statuses[i])
+	})
+	mux.HandleFunc("/x/web-interface/nav", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(CookieSessData); err != nil || c.Value != "sess" {
+			_, _ = fmt.Fprint(w, `{"code":-101,"message":"账号未登录","ttl":1,"data":{"isLogin":false}}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"code":0,"message":"0","ttl":1,"data":{"isLogin":true,"mid":12345,"uname":"test"}}`)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newPassportTestBilibili(server *httptest.Server) *Bilibili {
+	bi := newTestBilibili()
+	bi.passportUrl = server.URL
+	bi.mainApiUrl = server.URL
+	return bi
+}
+
+func TestBilibili_LoginWithQrCode(t *testing.T) {
+	qrLoginPollInterval = 10 * time.Millisecond
+	server := newPassportTestServer(86101, 86090, 86090, 0)
+	defer server.Close()
+	bi := newPassportTestBilibili(server)
+
+	var shown string
+	cookies, err := bi.LoginWithQrCode(func(qrCodeUrl string) {
+		shown = qrCodeUrl
+	})
+	if err != nil {
+		t.Fatalf("LoginWithQrCode: %v", err)
+	}
+	if shown != "https://account.bilibili.com/h5/account-h5/auth/scan-web?qrcode_key=key" {
+		t.Fatalf("unexpected QR code: %v", shown)
+	}
+	if FindCookie(cookies, CookieSessData) != "sess" || FindCookie(cookies, CookieBiliJct) != "jct" {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+}
+
+func TestBilibili_GetLoginInfo(t *testing.T) {
+	server := newPassportTestServer(0)
+	defer server.Close()
+	bi := newPassportTestBilibili(server)
+
+	nav, err := bi.GetLoginInfo()
+	if err != nil {
+		t.Fatalf("GetLoginInfo: %v", err)
+	}
+	if nav.Code != -101 || nav.Data.IsLogin {
+		t.Fatalf("session is logged in without cookies: %+v", nav)
+	}
+
+	// the mock server is not bilibili.com, so cookies are added for its URL
+	u, _ := url.Parse(server.URL)
+	bi.http.Jar.SetCookies(u, []*http.Cookie{{Name: CookieSessData, Value: "sess"}})
+	nav, err = bi.GetLoginInfo()
+	if err != nil {
+		t.Fatalf("GetLoginInfo: %v", err)
+	}
+	if !nav.Data.IsLogin || nav.Data.Mid != 12345 || nav.Data.Uname != "test" {
+		t.Fatalf("unexpected login info: %+v", nav)
+	}
+}
+
+func TestBilibili_LoginWithQrCode_Expired(t *testing.T) {
+	qrLoginPollInterval = 10 * time.Millisecond
+	server := newPassportTestServer(86101, 86038)
+	defer server.Close()
+	bi := newPassportTestBilibili(server)
+
+	_, err := bi.LoginWithQrCode(func(string) {})
+	if !errors.Is(err, ErrQrCodeExpired) {
+		t.Fatalf("expected ErrQrCodeExpired, got %v", err)
+	}
+}
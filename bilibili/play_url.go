@@ -1,12 +1,213 @@
 package bilibili
 
+/*
+Get live stream URLs.
+Streams are listed by the v2 getRoomPlayInfo API,
+then the best match of user preferences is selected.
+*/
+
 import (
 	"fmt"
 	"github.com/keuin/slbr/types"
+	"github.com/samber/lo"
+	"sort"
+	"strconv"
 )
 
-func (b *Bilibili) GetStreamingInfo(roomId types.RoomId) (resp types.RoomUrlInfoResponse, err error) {
-	url := fmt.Sprintf("https://api.live.bilibili.com/room/v1/Room/playUrl?"+
-		"cid=%d&otype=json&qn=10000&platform=web", roomId)
-	return callGet[types.RoomUrlInfoResponse](b, url)
+// StreamPreference describes which streams are wanted.
+// Each list contains allowed values in the order of preference, empty lists mean default values.
+// When ranking streams, the quality is the most important, then the codec, and the protocol at last.
+type StreamPreference struct {
+	// QualityNumbers: e.g. 10000 (original), 400 (blu-ray), 250 (super clear).
+	// If none of them is available, the highest quality is used. If empty, the highest quality is used.
+	QualityNumbers []int
+	// Codecs: default is avc, then hevc
+	Codecs []types.StreamCodec
+	// Protocols: default is flv, then hls
+	Protocols []types.StreamProtocol
+}
+
+var (
+	defaultCodecs    = []types.StreamCodec{types.CodecAvc, types.CodecHevc}
+	defaultProtocols = []types.StreamProtocol{types.ProtocolFlv, types.ProtocolHls}
+)
+
+// protocolNames maps API protocol names to StreamProtocol
+var protocolNames = map[string]types.StreamProtocol{
+	"http_stream": types.ProtocolFlv,
+	"http_hls":    types.ProtocolHls,
+}
+
+// formatRank: for the same protocol, lower value is preferred
+var formatRank = map[string]int{
+	"flv":  0,
+	"fmp4": 0,
+	"ts":   1,
+}
+
+// GetStreamingInfo returns available stream URLs of a live room,
+// sorted by the preference. The first one is the best match.
+func (b *Bilibili) GetStreamingInfo(
+	roomId types.RoomId,
+	pref StreamPreference,
+) (resp types.RoomUrlInfoResponse, err error) {
+	info, err := b.getRoomPlayInfo(roomId, 0)
+	if err != nil || info.Code != 0 || info.Data.PlayurlInfo == nil {
+		return convertPlayInfo(info, pref), err
+	}
+	// the API returns URLs of only one quality, so we may need to ask again for the wanted one
+	qn := SelectQuality(*info.Data.PlayurlInfo, pref)
+	if qn > 0 && !hasQuality(*info.Data.PlayurlInfo, pref, qn) {
+		info2, err2 := b.getRoomPlayInfo(roomId, qn)
+		if err2 == nil && info2.Code == 0 && info2.Data.PlayurlInfo != nil {
+			info = info2
+		} else {
+			b.logger.Warning("Cannot get streams of quality %v, use default quality", qn)
+		}
+	}
+	return convertPlayInfo(info, pref), nil
+}
+
+// SelectQuality returns the best available quality number of allowed streams.
+// It returns 0 if there is no allowed stream.
+func SelectQuality(info types.PlayurlInfo, pref StreamPreference) int {
+	accepted := make(map[int]bool)
+	highest := 0
+	forEachAllowedCodec(info, pref, func(_ types.StreamProtocol, _ string, codec types.PlayCodec) {
+		for _, qn := range codec.AcceptQn {
+			accepted[qn] = true
+			if qn > highest {
+				highest = qn
+			}
+		}
+	})
+	for _, qn := range pref.QualityNumbers {
+		if accepted[qn] {
+			return qn
+		}
+	}
+	return highest
+}
+
+func hasQuality(info types.PlayurlInfo, pref StreamPreference, qn int) (ok bool) {
+	forEachAllowedCodec(info, pref, func(_ types.StreamProtocol, _ string, codec types.PlayCodec) {
+		if codec.CurrentQn == qn {
+			ok = true
+		}
+	})
+	return
+}
+
+func forEachAllowedCodec(
+	info types.PlayurlInfo,
+	pref StreamPreference,
+	f func(protocol types.StreamProtocol, format string, codec types.PlayCodec),
+) {
+	for _, s := range info.Playurl.Stream {
+		protocol, ok := protocolNames[s.ProtocolName]
+		if !ok || lo.IndexOf(protocols(pref), protocol) < 0 {
+			continue
+		}
+		for _, fm := range s.Format {
+			if _, ok := formatRank[fm.FormatName]; !ok {
+				continue
+			}
+			for _, c := range fm.Codec {
+				if lo.IndexOf(codecs(pref), types.StreamCodec(c.CodecName)) < 0 {
+					continue
+				}
+				f(protocol, fm.FormatName, c)
+			}
+		}
+	}
+}
+
+// SelectStreams returns URLs of allowed streams, sorted by the preference.
+func SelectStreams(info types.PlayurlInfo, pref StreamPreference) []types.StreamingUrlInfo {
+	type candidate struct {
+		types.StreamingUrlInfo
+		rank [4]int
+	}
+	target := SelectQuality(info, pref)
+	var candidates []candidate
+	forEachAllowedCodec(info, pref, func(protocol types.StreamProtocol, format string, codec types.PlayCodec) {
+		qnRank := lo.IndexOf(pref.QualityNumbers, codec.CurrentQn)
+		if codec.CurrentQn == target {
+			qnRank = -1
+		} else if qnRank < 0 {
+			// not wanted, but better than nothing, prefer higher ones
+			qnRank = len(pref.QualityNumbers) + 100000 - codec.CurrentQn
+		}
+		for _, u := range codec.UrlInfo {
+			candidates = append(candidates, candidate{
+				StreamingUrlInfo: types.StreamingUrlInfo{
+					URL:           u.Host + codec.BaseUrl + u.Extra,
+					Protocol:      protocol,
+					Format:        format,
+					Codec:         types.StreamCodec(codec.CodecName),
+					QualityNumber: codec.CurrentQn,
+				},
+				rank: [4]int{
+					qnRank,
+					lo.IndexOf(codecs(pref), types.StreamCodec(codec.CodecName)),
+					lo.IndexOf(protocols(pref), protocol),
+					formatRank[format],
+				},
+			})
+		}
+	})
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].rank, candidates[j].rank
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	streams := make([]types.StreamingUrlInfo, len(candidates))
+	for i := range candidates {
+		streams[i] = candidates[i].StreamingUrlInfo
+		streams[i].Order = i + 1
+	}
+	return streams
+}
+
+// convertPlayInfo converts the v2 API response to the legacy playUrl response.
+func convertPlayInfo(info types.RoomPlayInfoResponse, pref StreamPreference) (resp types.RoomUrlInfoResponse) {
+	resp.Code = info.Code
+	resp.Message = info.Message
+	resp.TTL = info.TTL
+	if info.Data.PlayurlInfo == nil {
+		return
+	}
+	pi := *info.Data.PlayurlInfo
+	resp.Data.URLs = SelectStreams(pi, pref)
+	if len(resp.Data.URLs) > 0 {
+		resp.Data.CurrentQualityNumber = resp.Data.URLs[0].QualityNumber
+		resp.Data.CurrentQuality = resp.Data.CurrentQualityNumber
+	}
+	resp.Data.QualityDescription = pi.Playurl.QualityDesc
+	for _, d := range pi.Playurl.QualityDesc {
+		resp.Data.AcceptQuality = append(resp.Data.AcceptQuality, strconv.Itoa(d.QualityNumber))
+	}
+	return
+}
+
+func codecs(pref StreamPreference) []types.StreamCodec {
+	if len(pref.Codecs) == 0 {
+		return defaultCodecs
+	}
+	return pref.Codecs
+}
+
+func protocols(pref StreamPreference) []types.StreamProtocol {
+	if len(pref.Protocols) == 0 {
+		return defaultProtocols
+	}
+	return pref.Protocols
+}
+
+func (p StreamPreference) String() string {
+	return fmt.Sprintf("qn: %v, codecs: %v, protocols: %v", p.QualityNumbers, codecs(p), protocols(p))
 }
@@ -1,9 +1,12 @@
 package bilibili
 
 import (
+	"encoding/json"
 	testing2 "github.com/keuin/slbr/common/testing"
 	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
 	"log"
+	"os"
 	"testing"
 )
 
@@ -25,7 +28,7 @@ func TestBilibili_GetStreamingInfo(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetBUVID: %v", err)
 	}
-	info, err := bi.GetStreamingInfo(roomId)
+	info, err := bi.GetStreamingInfo(roomId, StreamPreference{})
 	if err != nil {
 		t.Fatalf("GetStreamingInfo: %v", err)
 	}
@@ -37,3 +40,108 @@ func TestBilibili_GetStreamingInfo(t *testing.T) {
 		t.Fatalf("Invalid GetStreamingInfo response: %v", info)
 	}
 }
+
+func loadPlayInfo(t *testing.T) types.RoomPlayInfoResponse {
+	b, err := os.ReadFile("testdata/getRoomPlayInfo.json")
+	if err != nil {
+		t.Fatalf("cannot read test data: %v", err)
+	}
+	var resp types.RoomPlayInfoResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatalf("cannot decode test data: %v", err)
+	}
+	if resp.Data.PlayurlInfo == nil {
+		t.Fatalf("playurl_info is missing")
+	}
+	return resp
+}
+
+func TestSelectStreams(t *testing.T) {
+	info := *loadPlayInfo(t).Data.PlayurlInfo
+	type stream struct {
+		url   string
+		codec types.StreamCodec
+		qn    int
+	}
+	cases := []struct {
+		name     string
+		pref     StreamPreference
+		quality  int
+		expected []stream
+	}{
+		{
+			name:    "default",
+			pref:    StreamPreference{},
+			quality: 30000,
+			expected: []stream{
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678_hevc/index.m3u8?expires=1&sign=e", types.CodecHevc, 30000},
+				{"https://cn-flv-1.bilivideo.com/live-bvc/1234/live_5678.flv?expires=1&sign=a", types.CodecAvc, 10000},
+				{"https://cn-flv-2.bilivideo.com/live-bvc/1234/live_5678.flv?expires=1&sign=b", types.CodecAvc, 10000},
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678_avc/index.m3u8?expires=1&sign=d", types.CodecAvc, 10000},
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678/index.m3u8?expires=1&sign=c", types.CodecAvc, 10000},
+			},
+		},
+		{
+			name: "flv only",
+			pref: StreamPreference{
+				QualityNumbers: []int{20000, 10000},
+				Protocols:      []types.StreamProtocol{types.ProtocolFlv},
+			},
+			quality: 10000,
+			expected: []stream{
+				{"https://cn-flv-1.bilivideo.com/live-bvc/1234/live_5678.flv?expires=1&sign=a", types.CodecAvc, 10000},
+				{"https://cn-flv-2.bilivideo.com/live-bvc/1234/live_5678.flv?expires=1&sign=b", types.CodecAvc, 10000},
+			},
+		},
+		{
+			name: "prefer hevc",
+			pref: StreamPreference{
+				QualityNumbers: []int{10000},
+				Codecs:         []types.StreamCodec{types.CodecHevc, types.CodecAvc},
+				Protocols:      []types.StreamProtocol{types.ProtocolHls},
+			},
+			quality: 10000,
+			expected: []stream{
+				// hevc is not at quality 10000 yet, the caller should ask again
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678_avc/index.m3u8?expires=1&sign=d", types.CodecAvc, 10000},
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678/index.m3u8?expires=1&sign=c", types.CodecAvc, 10000},
+				{"https://cn-hls-1.bilivideo.com/live-bvc/1234/live_5678_hevc/index.m3u8?expires=1&sign=e", types.CodecHevc, 30000},
+			},
+		},
+		{
+			name: "no match",
+			pref: StreamPreference{
+				Codecs:    []types.StreamCodec{types.CodecHevc},
+				Protocols: []types.StreamProtocol{types.ProtocolFlv},
+			},
+			quality:  0,
+			expected: nil,
+		},
+	}
+	for _, c := range cases {
+		if q := SelectQuality(info, c.pref); q != c.quality {
+			t.Fatalf("%v: expected quality %v, got %v", c.name, c.quality, q)
+		}
+		streams := SelectStreams(info, c.pref)
+		if len(streams) != len(c.expected) {
+			t.Fatalf("%v: expected %v streams, got %v: %v", c.name, len(c.expected), len(streams), streams)
+		}
+		for i, e := range c.expected {
+			s := streams[i]
+			if s.URL != e.url || s.Codec != e.codec || s.QualityNumber != e.qn {
+				t.Fatalf("%v: stream %v mismatch, expected %v, got %v", c.name, i, e, s)
+			}
+		}
+	}
+}
+
+func TestConvertPlayInfo(t *testing.T) {
+	resp := convertPlayInfo(loadPlayInfo(t), StreamPreference{Protocols: []types.StreamProtocol{types.ProtocolFlv}})
+	if resp.Code != 0 || len(resp.Data.URLs) != 2 || resp.Data.CurrentQualityNumber != 10000 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if len(resp.Data.AcceptQuality) != 5 || resp.Data.AcceptQuality[1] != "10000" ||
+		resp.Data.QualityDescription[1].Description != "原画" {
+		t.Fatalf("unexpected qualities: %v, %v", resp.Data.AcceptQuality, resp.Data.QualityDescription)
+	}
+}
@@ -9,11 +9,38 @@ import (
 	"strings"
 )
 
+// Response codes of rejected WBI signatures
+const (
+	codeWbiRejected    = -352
+	codeWbiRejectedOld = -403
+)
+
+type requestOptions struct {
+	// wbi: sign the query string, see wbi.go
+	wbi bool
+}
+
+// requestOption enables optional features of requests. API wrappers opt in to them.
+type requestOption func(o *requestOptions)
+
+// withWbi signs the request with WBI, which is required by some APIs.
+func withWbi(o *requestOptions) {
+	o.wbi = true
+}
+
+func newRequestOptions(opts []requestOption) (o requestOptions) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return
+}
+
 // newRequest create an HTTP request with per-instance User-Agent set.
 func (b *Bilibili) newRequest(
 	method string,
 	url string,
 	body io.Reader,
+	opts ...requestOption,
 ) (req *http.Request, err error) {
 	req, err = http.NewRequestWithContext(b.ctx, method, url, body)
 	if err != nil {
@@ -21,17 +48,24 @@ func (b *Bilibili) newRequest(
 		return
 	}
 	req.Header.Set("User-Agent", b.userAgent)
+	if newRequestOptions(opts).wbi {
+		err = b.wbiSign(req.URL)
+		if err != nil {
+			b.logger.Error("Cannot sign HTTP request: %v. Method: %v, URL: %v", err, method, url)
+			return nil, err
+		}
+	}
 	return
 }
 
 // newRequest create an HTTP GET request with an empty body and per-instance User-Agent set.
-func (b *Bilibili) newGet(url string) (req *http.Request, err error) {
-	return b.newRequest("GET", url, strings.NewReader(""))
+func (b *Bilibili) newGet(url string, opts ...requestOption) (req *http.Request, err error) {
+	return b.newRequest("GET", url, strings.NewReader(""), opts...)
 }
 
 // callGetRaw make a GET request and returns the raw response body.
-func callGetRaw(b *Bilibili, url string) (resp *http.Response, respBody []byte, err error) {
-	req, err := b.newGet(url)
+func callGetRaw(b *Bilibili, url string, opts ...requestOption) (resp *http.Response, respBody []byte, err error) {
+	req, err := b.newGet(url, opts...)
 	if err != nil {
 		b.logger.Error("Cannot create HTTP request instance on API %v: %v", url, err)
 		return
@@ -60,29 +94,69 @@ func callGetRaw(b *Bilibili, url string) (resp *http.Response, respBody []byte,
 }
 
 // callGet make a GET request and parse response as a JSON document with given model.
-func callGet[T types.BaseResponse[V], V any](b *Bilibili, url string) (resp T, err error) {
-	r, data, err := callGetRaw(b, url)
+func callGet[T types.BaseResponse[V], V any](b *Bilibili, url string, opts ...requestOption) (resp T, err error) {
+	r, data, err := callGetRaw(b, url, opts...)
 	if err != nil {
 		return
 	}
 
-	err = json.Unmarshal(data, &resp)
+	resp, err = decodeResponse[T](b, url, data)
 	if err != nil {
-		b.logger.Error("Invalid JSON body of HTTP response on API %v: %v. Text: \"%v\"",
-			url, err, string(data))
 		return
 	}
 
+	code := types.BaseResponse[V](resp).Code
+	if newRequestOptions(opts).wbi && (code == codeWbiRejected || code == codeWbiRejectedOld) {
+		// the keys may be changed, get them again in the next request
+		b.logger.Warning("WBI signature is rejected by API %v (code %v).", url, code)
+		b.wbi.invalidate()
+	}
+
 	b.logger.Debug("HTTP %v, len: %v bytes, url: %v", r.StatusCode, len(data), url)
 	return
 }
 
+// callGetData is like callGet, but data is decoded only if the code is 0,
+// for APIs which return data of another type on errors, e.g. an empty array.
+func callGetData[T types.BaseResponse[V], V any](b *Bilibili, url string, opts ...requestOption) (resp T, err error) {
+	raw, err := callGet[types.BaseResponse[json.RawMessage]](b, url, opts...)
+	if err != nil {
+		return
+	}
+	r := types.BaseResponse[V]{
+		Code:    raw.Code,
+		Message: raw.Message,
+		TTL:     raw.TTL,
+	}
+	if raw.Code == 0 {
+		err = json.Unmarshal(raw.Data, &r.Data)
+		if err != nil {
+			b.logger.Error("Invalid data of HTTP response on API %v: %v. Text: \"%v\"", url, err, string(raw.Data))
+		}
+	}
+	return T(r), err
+}
+
+// decodeResponse parses the response body as a JSON document with given model.
+func decodeResponse[T types.BaseResponse[V], V any](b *Bilibili, url string, data []byte) (resp T, err error) {
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		b.logger.Error("Invalid JSON body of HTTP response on API %v: %v. Text: \"%v\"",
+			url, err, string(data))
+	}
+	return
+}
+
 func (b *Bilibili) Do(req *http.Request) (resp *http.Response, err error) {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.DialTLSContext = nil
+	if b.socketTimeout > 0 {
+		transport.TLSHandshakeTimeout = b.socketTimeout
+		transport.ResponseHeaderTimeout = b.socketTimeout
+	}
 
 	np := newNetProbe(b.netTypes)
-	var dialer net.Dialer
+	dialer := net.Dialer{Timeout: b.socketTimeout}
 	for netCtx, typeName := np.NextNetworkType(dialer); netCtx != nil; netCtx, typeName = np.NextNetworkType(dialer) {
 		transport.DialContext = netCtx
 		b.http.Transport = transport
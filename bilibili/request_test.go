@@ -1,9 +1,13 @@
 package bilibili
 
 import (
+	"fmt"
 	"github.com/keuin/slbr/logging"
 	"github.com/keuin/slbr/types"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
@@ -15,3 +19,62 @@ func Test_callGet(t *testing.T) {
 		t.Fatalf("the artificial request should fail, but it haven't")
 	}
 }
+
+func TestBilibili_LiveApiUrl(t *testing.T) {
+	// all live API calls should be sent to liveApiUrl
+	var lock sync.Mutex
+	var paths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/x/web-interface/nav", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"code":-101,"message":"账号未登录","ttl":1,"data":{"isLogin":false,`+
+			`"wbi_img":{"img_url":"https://i0.hdslb.com/bfs/wbi/%v.png","sub_url":"https://i0.hdslb.com/bfs/wbi/%v.png"}}}`,
+			testImgKey, testSubKey)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		paths = append(paths, r.URL.Path)
+		lock.Unlock()
+		_, _ = fmt.Fprint(w, `{"code":0,"message":"0","msg":"","ttl":1}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	bi := newTestBilibili()
+	bi.mainApiUrl = server.URL
+	bi.liveApiUrl = server.URL
+
+	calls := map[string]func() error{
+		"/xlive/web-room/v1/index/getDanmuInfo": func() error {
+			_, err := bi.GetDanmakuServerInfo(1234)
+			return err
+		},
+		"/activity/v1/Common/webBanner": func() error {
+			_, err := bi.GetLiveBUVID(1234)
+			return err
+		},
+		"/room/v1/Room/get_info": func() error {
+			_, err := bi.GetRoomProfile(1234)
+			return err
+		},
+		"/xlive/web-room/v2/index/getRoomPlayInfo": func() error {
+			_, err := bi.GetRoomPlayInfo(1234)
+			return err
+		},
+		"/live_user/v1/Master/info": func() error {
+			_, err := bi.GetStreamerInfo(1234)
+			return err
+		},
+	}
+	for path, call := range calls {
+		lock.Lock()
+		paths = nil
+		lock.Unlock()
+		if err := call(); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		lock.Lock()
+		if len(paths) != 1 || paths[0] != path {
+			t.Fatalf("%v: unexpected requests: %v", path, paths)
+		}
+		lock.Unlock()
+	}
+}
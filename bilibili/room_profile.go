@@ -6,6 +6,6 @@ import (
 )
 
 func (b *Bilibili) GetRoomProfile(roomId types.RoomId) (resp types.RoomProfileResponse, err error) {
-	url := fmt.Sprintf("https://api.live.bilibili.com/room/v1/Room/get_info?room_id=%d", roomId)
+	url := fmt.Sprintf("%s/room/v1/Room/get_info?room_id=%d", b.liveApiUrl, roomId)
 	return callGet[types.RoomProfileResponse](b, url)
 }
@@ -0,0 +1,174 @@
+/*
+In this file we resolve rooms given by users to canonical room ids.
+Users may give short room ids, live room URLs, b23.tv short links or user space URLs.
+Short ids must not be used in other APIs: for example, the danmaku server accepts them
+in the auth exchange but sends nothing.
+*/
+package bilibili
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/types"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// codeRoomNotFound is returned by the room init API if the room does not exist
+const codeRoomNotFound = 60004
+
+// ErrRoomNotFound is returned by ResolveRoom if the room or the user does not exist, or the user has no live room.
+var ErrRoomNotFound = errors.New("live room not found")
+
+// ErrInvalidRoomInput is returned by ResolveRoom if the input is not a room id or a supported URL.
+var ErrInvalidRoomInput = errors.New("invalid room input")
+
+// GetRoomInit returns the canonical room id and the short id of a room. roomId can be a short id.
+func (b *Bilibili) GetRoomInit(roomId types.RoomId) (resp types.RoomInitResponse, err error) {
+	u := fmt.Sprintf("%s/room/v1/Room/room_init?id=%d", b.liveApiUrl, roomId)
+	return callGetData[types.RoomInitResponse](b, u)
+}
+
+// GetUserRoomInfo returns the live room of a user.
+func (b *Bilibili) GetUserRoomInfo(uid uint64) (resp types.UserRoomInfoResponse, err error) {
+	u := fmt.Sprintf("%s/room/v1/Room/getRoomInfoOld?mid=%d", b.liveApiUrl, uid)
+	return callGetData[types.UserRoomInfoResponse](b, u)
+}
+
+// GetUserRoomId returns the room id of the live room of a user.
+// ErrRoomNotFound is returned if the user does not exist or has no live room.
+func (b *Bilibili) GetUserRoomId(uid uint64) (types.RoomId, error) {
+	resp, err := b.GetUserRoomInfo(uid)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Code != 0 {
+		return 0, fmt.Errorf("cannot get live room of user %v: bilibili API error: %v", uid, resp.Message)
+	}
+	if resp.Data.RoomStatus == 0 || resp.Data.RoomID == 0 {
+		return 0, fmt.Errorf("%w: user %v has no live room", ErrRoomNotFound, uid)
+	}
+	return resp.Data.RoomID, nil
+}
+
+// roomRef is a parsed room input. Exactly one field is set.
+type roomRef struct {
+	// roomId may be a short id
+	roomId types.RoomId
+	uid    uint64
+	// shortLink should be requested to get the real URL
+	shortLink string
+}
+
+// parseRoomInput parses a room id, a live room URL, a b23.tv short link or a user space URL.
+// URLs without the scheme are accepted, e.g. "live.bilibili.com/6".
+func parseRoomInput(input string) (ref roomRef, err error) {
+	input = strings.TrimSpace(input)
+	if id, err := strconv.ParseUint(input, 10, 64); err == nil && id > 0 {
+		return roomRef{roomId: types.RoomId(id)}, nil
+	}
+	s := input
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ref, fmt.Errorf("%w %q: %v", ErrInvalidRoomInput, input, err)
+	}
+	var segments []string
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	switch strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.") {
+	case "live.bilibili.com":
+		// e.g. /6, /h5/6, /blanc/6
+		for i := len(segments) - 1; i >= 0; i-- {
+			if id, err := strconv.ParseUint(segments[i], 10, 64); err == nil && id > 0 {
+				return roomRef{roomId: types.RoomId(id)}, nil
+			}
+		}
+	case "space.bilibili.com":
+		// e.g. /123456, /123456/dynamic
+		if len(segments) > 0 {
+			if uid, err := strconv.ParseUint(segments[0], 10, 64); err == nil && uid > 0 {
+				return roomRef{uid: uid}, nil
+			}
+		}
+	case "m.bilibili.com":
+		// e.g. /space/123456
+		if len(segments) > 1 && segments[0] == "space" {
+			if uid, err := strconv.ParseUint(segments[1], 10, 64); err == nil && uid > 0 {
+				return roomRef{uid: uid}, nil
+			}
+		}
+	case "b23.tv":
+		if len(segments) > 0 {
+			return roomRef{shortLink: u.String()}, nil
+		}
+	}
+	return ref, fmt.Errorf("%w %q, expected a room id, a live room URL, "+
+		"a b23.tv link or a user space URL", ErrInvalidRoomInput, input)
+}
+
+// resolveShortLink returns the URL which a short link redirects to.
+func (b *Bilibili) resolveShortLink(link string) (string, error) {
+	req, err := b.newGet(link)
+	if err != nil {
+		return "", err
+	}
+	// only the first redirection is needed, the target is not requested
+	client := *b.http
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	_ = r.Body.Close()
+	loc, err := r.Location()
+	if err != nil {
+		return "", fmt.Errorf("not redirected (HTTP %v)", r.Status)
+	}
+	return loc.String(), nil
+}
+
+// ResolveRoom resolves a room id, a short room id, a live room URL, a b23.tv short link
+// or a user space URL to the canonical room id.
+func (b *Bilibili) ResolveRoom(input string) (types.RoomId, error) {
+	ref, err := parseRoomInput(input)
+	if err != nil {
+		return 0, err
+	}
+	if shortLink := ref.shortLink; shortLink != "" {
+		link, err := b.resolveShortLink(shortLink)
+		if err != nil {
+			return 0, fmt.Errorf("cannot resolve short link %v: %w", shortLink, err)
+		}
+		ref, err = parseRoomInput(link)
+		if err != nil || ref.shortLink != "" {
+			return 0, fmt.Errorf("short link %v redirects to %v, which is not a live room or a user", shortLink, link)
+		}
+	}
+	if ref.uid != 0 {
+		ref.roomId, err = b.GetUserRoomId(ref.uid)
+		if err != nil {
+			return 0, err
+		}
+	}
+	resp, err := b.GetRoomInit(ref.roomId)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Code == codeRoomNotFound {
+		return 0, fmt.Errorf("%w: room %v", ErrRoomNotFound, ref.roomId)
+	}
+	if resp.Code != 0 || resp.Data.RoomID == 0 {
+		return 0, fmt.Errorf("cannot get info of room %v: bilibili API error: %v", ref.roomId, resp.Message)
+	}
+	return resp.Data.RoomID, nil
+}
@@ -0,0 +1,113 @@
+package bilibili
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRoomInput(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected roomRef
+	}{
+		{"6", roomRef{roomId: 6}},
+		{" 7734200 ", roomRef{roomId: 7734200}},
+		{"https://live.bilibili.com/6", roomRef{roomId: 6}},
+		{"https://live.bilibili.com/6?broadcast_type=0&spm_id_from=333.999", roomRef{roomId: 6}},
+		{"live.bilibili.com/h5/6", roomRef{roomId: 6}},
+		{"https://live.bilibili.com/blanc/22625025/", roomRef{roomId: 22625025}},
+		{"https://space.bilibili.com/9617619", roomRef{uid: 9617619}},
+		{"https://space.bilibili.com/9617619/dynamic?spm_id_from=1", roomRef{uid: 9617619}},
+		{"https://m.bilibili.com/space/9617619", roomRef{uid: 9617619}},
+		{"https://b23.tv/AbCdEf", roomRef{shortLink: "https://b23.tv/AbCdEf"}},
+		{"b23.tv/AbCdEf", roomRef{shortLink: "https://b23.tv/AbCdEf"}},
+	}
+	for _, c := range cases {
+		ref, err := parseRoomInput(c.input)
+		if err != nil {
+			t.Fatalf("parseRoomInput(%q): %v", c.input, err)
+		}
+		if ref != c.expected {
+			t.Fatalf("parseRoomInput(%q): got %+v, expected %+v", c.input, ref, c.expected)
+		}
+	}
+
+	for _, input := range []string{
+		"", "0", "-1", "abc", "https://live.bilibili.com/", "https://space.bilibili.com/abc",
+		"https://b23.tv/", "https://example.com/6",
+	} {
+		if ref, err := parseRoomInput(input); err == nil {
+			t.Fatalf("parseRoomInput(%q) should fail, got %+v", input, ref)
+		}
+	}
+}
+
+func newRoomTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/room/v1/Room/room_init", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("id") {
+		case "6", "7734200":
+			_, _ = fmt.Fprint(w, `{"code":0,"msg":"ok","message":"ok","data":{"room_id":7734200,"short_id":6,"uid":0}}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"code":60004,"msg":"直播间不存在","message":"直播间不存在","data":[]}`)
+		}
+	})
+	mux.HandleFunc("/room/v1/Room/getRoomInfoOld", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("mid") {
+		case "9617619":
+			_, _ = fmt.Fprint(w, `{"code":0,"msg":"","message":"","data":{"roomStatus":1,"roomid":7734200}}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"code":0,"msg":"","message":"","data":{"roomStatus":0,"roomid":0}}`)
+		}
+	})
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://live.bilibili.com/6?share_source=copy_link", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestBilibili_ResolveRoom(t *testing.T) {
+	server := newRoomTestServer()
+	defer server.Close()
+	bi := newTestBilibili()
+	bi.liveApiUrl = server.URL
+
+	for _, input := range []string{"6", "7734200", "https://live.bilibili.com/6", "space.bilibili.com/9617619"} {
+		roomId, err := bi.ResolveRoom(input)
+		if err != nil {
+			t.Fatalf("ResolveRoom(%q): %v", input, err)
+		}
+		if roomId != 7734200 {
+			t.Fatalf("ResolveRoom(%q): got %v", input, roomId)
+		}
+	}
+
+	for _, input := range []string{"1234", "https://space.bilibili.com/1234"} {
+		_, err := bi.ResolveRoom(input)
+		if !errors.Is(err, ErrRoomNotFound) {
+			t.Fatalf("ResolveRoom(%q): expected ErrRoomNotFound, got %v", input, err)
+		}
+	}
+}
+
+func TestBilibili_resolveShortLink(t *testing.T) {
+	server := newRoomTestServer()
+	defer server.Close()
+	bi := newTestBilibili()
+
+	link, err := bi.resolveShortLink(server.URL + "/short")
+	if err != nil {
+		t.Fatalf("resolveShortLink: %v", err)
+	}
+	ref, err := parseRoomInput(link)
+	if err != nil || ref.roomId != 6 {
+		t.Fatalf("unexpected target %v: %+v, %v", link, ref, err)
+	}
+
+	if _, err := bi.resolveShortLink(server.URL + "/room/v1/Room/room_init"); err == nil {
+		t.Fatalf("a page without redirection is resolved")
+	}
+}
@@ -10,7 +10,13 @@ import (
 )
 
 func (b *Bilibili) GetRoomPlayInfo(roomId types.RoomId) (resp types.RoomPlayInfoResponse, err error) {
-	url := fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v2/index/getRoomPlayInfo"+
-		"?room_id=%d&protocol=0,1&format=0,1,2&codec=0,1&qn=0&platform=web&ptype=8&dolby=5&panorama=1", roomId)
-	return callGet[types.RoomPlayInfoResponse](b, url)
+	return b.getRoomPlayInfo(roomId, 0)
+}
+
+// getRoomPlayInfo gets live status and stream URLs of the given quality number. qn=0 means the default quality.
+func (b *Bilibili) getRoomPlayInfo(roomId types.RoomId, qn int) (resp types.RoomPlayInfoResponse, err error) {
+	url := fmt.Sprintf("%s/xlive/web-room/v2/index/getRoomPlayInfo"+
+		"?room_id=%d&protocol=0,1&format=0,1,2&codec=0,1&qn=%d&platform=web&ptype=8&dolby=5&panorama=1",
+		b.liveApiUrl, roomId, qn)
+	return callGet[types.RoomPlayInfoResponse](b, url, withWbi)
 }
@@ -0,0 +1,11 @@
+package bilibili
+
+import (
+	"fmt"
+	"github.com/keuin/slbr/types"
+)
+
+func (b *Bilibili) GetStreamerInfo(uid int) (resp types.StreamerInfoResponse, err error) {
+	url := fmt.Sprintf("%s/live_user/v1/Master/info?uid=%d", b.liveApiUrl, uid)
+	return callGet[types.StreamerInfoResponse](b, url)
+}
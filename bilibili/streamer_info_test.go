@@ -0,0 +1,38 @@
+package bilibili
+
+import (
+	testing2 "github.com/keuin/slbr/common/testing"
+	"github.com/keuin/slbr/logging"
+	"log"
+	"testing"
+)
+
+func TestBilibili_GetStreamerInfo(t *testing.T) {
+	// get an online live room for testing
+	liveList, err := testing2.GetLiveListForGuestUser()
+	if err != nil {
+		t.Fatalf("cannot get live list for testing: %v", err)
+	}
+	lives := liveList.Data.Data
+	if len(lives) <= 0 {
+		t.Fatalf("no live for guest available")
+	}
+	roomId := lives[0].Roomid
+
+	logger := log.Default()
+	bi := NewBilibili(logging.NewWrappedLogger(logger, "test-logger"))
+	profile, err := bi.GetRoomProfile(roomId)
+	if err != nil {
+		t.Fatalf("GetRoomProfile: %v", err)
+	}
+	resp, err := bi.GetStreamerInfo(profile.Data.UID)
+	if err != nil {
+		t.Fatalf("GetStreamerInfo: %v", err)
+	}
+	if resp.Code != 0 ||
+		resp.Data.Info.UID != profile.Data.UID ||
+		resp.Data.Info.Uname == "" ||
+		resp.Data.RoomID != roomId {
+		t.Fatalf("Invalid GetStreamerInfo response: %v", resp)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/keuin/slbr/types"
 	"io"
 	"net/http"
-	"os"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -23,7 +22,7 @@ func (b *Bilibili) CopyLiveStream(
 	ctx context.Context,
 	roomId types.RoomId,
 	stream types.StreamingUrlInfo,
-	fileCreator func() (*os.File, error),
+	fileCreator func() (io.Writer, error),
 	bufSize int64,
 ) (err error) {
 	url := stream.URL
@@ -37,6 +36,10 @@ func (b *Bilibili) CopyLiveStream(
 		b.logger.Error("Cannot create HTTP GET instance on %v: %v", url, err)
 		return err
 	}
+	// cancelling the context aborts blocking reads immediately
+	ctxCopy, cancelCopy := context.WithCancelCause(ctx)
+	defer cancelCopy(nil)
+	r = r.WithContext(ctxCopy)
 
 	r.Header.Set("Referer",
 		fmt.Sprintf("https://live.bilibili.com/blanc/%d?liteVersion=true", roomId))
@@ -59,24 +62,35 @@ func (b *Bilibili) CopyLiveStream(
 
 	defer func() { _ = resp.Body.Close() }()
 
+	var n atomic.Int64
+	body := &countingReader{r: resp.Body, n: &n}
+	stopWatchdog := b.startStallWatchdog(&n, cancelCopy)
+	defer stopWatchdog()
+	defer func() {
+		// aborted by the watchdog, the caller should reconnect
+		if cause := stallCause(ctxCopy, err); errors.Is(cause, ErrStreamStalled) {
+			err = errs.NewError(errs.StreamCopy, cause)
+		}
+	}()
+
 	b.logger.Info("Waiting for stream initial bytes...")
 	// read some first bytes to ensure that the live is really started,
 	// so we don't create blank files if the live room is open
 	// but the live hasn't started yet
 	initBytes := make([]byte, InitReadBytes)
 	startTime := time.Now()
-	_, err = io.ReadFull(resp.Body, initBytes)
+	_, err = io.ReadFull(body, initBytes)
 	if err != nil {
-		b.logger.Error("Failed to read stream initial bytes: %v", err)
+		b.logger.Error("Failed to read stream initial bytes: %v", stallCause(ctxCopy, err))
 		return
 	}
 	b.logger.Info("Stream is started. Receiving live stream...")
 	// write initial bytes
-	var out *os.File
+	var out io.Writer
 	out, err = fileCreator()
 	if err != nil {
 		b.logger.Error("Cannot open file for writing: %v", err)
-		err = errs.NewError(errs.FileCreation, err)
+		err = errs.Wrap(errs.FileCreation, err)
 		return
 	}
 	_, err = out.Write(initBytes)
@@ -86,23 +100,7 @@ func (b *Bilibili) CopyLiveStream(
 	}
 	initBytes = nil // discard that buffer
 
-	var n atomic.Int64
-
-	// print download progress at a steady interval
-	printTicker := time.NewTicker(progressReportInterval)
-	stopPrintLoop := make(chan struct{})
-	go func() {
-		defer printTicker.Stop()
-		for {
-			select {
-			case <-printTicker.C:
-				b.logger.Info("Downloaded: %v, duration: %v",
-					pretty.Bytes(uint64(n.Load())), pretty.Duration(time.Now().Sub(startTime)))
-			case <-stopPrintLoop:
-				return
-			}
-		}
-	}()
+	stopProgressReport := b.startProgressReport(&n, startTime)
 
 	// blocking copy
 copyLoop:
@@ -113,15 +111,16 @@ copyLoop:
 			err = ctx.Err()
 			break copyLoop
 		default:
-			var sz int64
-			sz, err = io.CopyN(out, resp.Body, bufSize)
-			n.Add(sz)
+			_, err = io.CopyN(out, body, bufSize)
 		}
 	}
 
-	close(stopPrintLoop)
+	stopProgressReport()
+	err = stallCause(ctxCopy, err)
 
-	if errors.Is(err, context.Canceled) {
+	if errors.Is(err, ErrStreamStalled) {
+		b.logger.Warning("The stream is stalled, stop copying.")
+	} else if errors.Is(err, context.Canceled) {
 		b.logger.Info("Stop copying...")
 	} else if errors.Is(err, io.EOF) {
 		b.logger.Info("The live is ended. (room %v)", roomId)
@@ -132,3 +131,22 @@ copyLoop:
 	b.logger.Info("Total downloaded: %v", pretty.Bytes(uint64(n.Load())))
 	return err
 }
+
+// startProgressReport prints download progress at a steady interval until the returned function is called.
+func (b *Bilibili) startProgressReport(n *atomic.Int64, startTime time.Time) (stop func()) {
+	printTicker := time.NewTicker(progressReportInterval)
+	stopPrintLoop := make(chan struct{})
+	go func() {
+		defer printTicker.Stop()
+		for {
+			select {
+			case <-printTicker.C:
+				b.logger.Info("Downloaded: %v, duration: %v",
+					pretty.Bytes(uint64(n.Load())), pretty.Duration(time.Now().Sub(startTime)))
+			case <-stopPrintLoop:
+				return
+			}
+		}
+	}()
+	return func() { close(stopPrintLoop) }
+}
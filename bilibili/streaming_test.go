@@ -6,8 +6,8 @@ import (
 	"fmt"
 	testing2 "github.com/keuin/slbr/common/testing"
 	"github.com/keuin/slbr/logging"
+	"io"
 	"log"
-	"os"
 	"testing"
 )
 
@@ -26,14 +26,14 @@ func TestBilibili_CopyLiveStream(t *testing.T) {
 	logger := log.Default()
 	bi := NewBilibili(logging.NewWrappedLogger(logger, "test-logger"))
 
-	si, err := bi.GetStreamingInfo(roomId)
+	si, err := bi.GetStreamingInfo(roomId, StreamPreference{})
 	if err != nil {
 		t.Fatalf("GetStreamingInfo: %v", err)
 	}
 
 	// test file open failure
 	testErr := fmt.Errorf("test error")
-	err = bi.CopyLiveStream(context.Background(), roomId, si.Data.URLs[0], func() (*os.File, error) {
+	err = bi.CopyLiveStream(context.Background(), roomId, si.Data.URLs[0], func() (io.Writer, error) {
 		return nil, testErr
 	}, 1048576)
 	if !errors.Is(err, testErr) {
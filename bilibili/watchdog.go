@@ -0,0 +1,112 @@
+package bilibili
+
+/*
+Stall watchdog of stream copying.
+Sometimes the CDN keeps the connection open but stops sending data, or sends data slower than the live,
+so the copy blocks forever while the live is still going on. The watchdog aborts the copy in such cases,
+then the caller can reconnect.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/common/pretty"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamStalled is the cause of aborted copies. It is wrapped with details.
+var ErrStreamStalled = errors.New("stream is stalled")
+
+// StallDetection configures when a stream is considered stalled.
+type StallDetection struct {
+	// Timeout: the copy is aborted if no byte is received in this duration. Zero disables the watchdog.
+	Timeout time.Duration
+	// MinSpeed: the copy is aborted if the average speed (bytes per second) in the last Timeout
+	// is less than this. Zero disables the speed check.
+	MinSpeed int64
+}
+
+// watchdogCheckTimes: how many times the progress is checked in a Timeout
+const watchdogCheckTimes = 10
+
+// SetStallDetection enables or disables the stall watchdog of CopyLiveStream and CopyHlsStream.
+func (b *Bilibili) SetStallDetection(d StallDetection) {
+	b.stall = d
+}
+
+// countingReader adds the number of bytes read to n, so the watchdog sees the progress as soon as data arrives,
+// instead of after a whole buffer is copied.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n.Add(int64(n))
+	return
+}
+
+// startStallWatchdog watches the progress counter n, and cancels the copy with a wrapped ErrStreamStalled
+// if it is stalled. The watchdog runs until the returned function is called.
+func (b *Bilibili) startStallWatchdog(n *atomic.Int64, cancel context.CancelCauseFunc) (stop func()) {
+	d := b.stall
+	if d.Timeout <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(d.Timeout / watchdogCheckTimes)
+	chStop := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		type sample struct {
+			t time.Time
+			n int64
+		}
+		// samples in the last Timeout, the first one is the oldest
+		samples := []sample{{time.Now(), n.Load()}}
+		lastProgress := samples[0]
+		for {
+			select {
+			case <-chStop:
+				return
+			case now := <-ticker.C:
+				cur := sample{now, n.Load()}
+				if cur.n != lastProgress.n {
+					lastProgress = cur
+				} else if now.Sub(lastProgress.t) >= d.Timeout {
+					err := fmt.Errorf("%w: no data received in %v", ErrStreamStalled, d.Timeout)
+					b.logger.Error("%v", err)
+					cancel(err)
+					return
+				}
+				samples = append(samples, cur)
+				if now.Sub(samples[0].t) < d.Timeout {
+					continue
+				}
+				for len(samples) > 1 && now.Sub(samples[1].t) >= d.Timeout {
+					samples = samples[1:]
+				}
+				speed := float64(cur.n-samples[0].n) / now.Sub(samples[0].t).Seconds()
+				if d.MinSpeed > 0 && speed < float64(d.MinSpeed) {
+					err := fmt.Errorf("%w: average speed %v/s in %v is less than %v/s", ErrStreamStalled,
+						pretty.Bytes(uint64(speed)), d.Timeout, pretty.Bytes(uint64(d.MinSpeed)))
+					b.logger.Error("%v", err)
+					cancel(err)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(chStop) }
+}
+
+// stallCause returns the stall error if ctx is cancelled by the watchdog, or err otherwise.
+func stallCause(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrStreamStalled) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package bilibili
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingHandler sends some data, then keeps sending at the given interval until the request is cancelled.
+// No data is sent after the first chunk if interval is zero.
+func stallingHandler(chunk []byte, interval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for {
+			_, _ = w.Write(chunk)
+			w.(http.Flusher).Flush()
+			if interval == 0 {
+				<-r.Context().Done()
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+}
+
+func copyStalledStream(t *testing.T, handler http.Handler, d StallDetection) error {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	bi := newTestBilibili()
+	bi.SetStallDetection(d)
+	var out bytes.Buffer
+	stream := types.StreamingUrlInfo{URL: server.URL + "/live.flv"}
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- bi.CopyLiveStream(context.Background(), 1234, stream, func() (io.Writer, error) {
+			return &out, nil
+		}, 1024)
+	}()
+	select {
+	case err := <-chErr:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the stalled copy is not aborted")
+	}
+	return nil
+}
+
+func TestBilibili_CopyLiveStream_Stalled(t *testing.T) {
+	chunk := make([]byte, InitReadBytes*2)
+	err := copyStalledStream(t, stallingHandler(chunk, 0), StallDetection{Timeout: 300 * time.Millisecond})
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Fatalf("expected ErrStreamStalled, got %v", err)
+	}
+	taskErr, ok := err.(errs.TaskError)
+	if !ok || taskErr.Type() != errs.StreamCopy || !taskErr.IsRecoverable() {
+		t.Fatalf("expected a recoverable StreamCopy error, got %v", err)
+	}
+}
+
+func TestBilibili_CopyLiveStream_StalledBeforeStart(t *testing.T) {
+	// less than InitReadBytes, the file is never created
+	chunk := make([]byte, 16)
+	err := copyStalledStream(t, stallingHandler(chunk, 0), StallDetection{Timeout: 300 * time.Millisecond})
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Fatalf("expected ErrStreamStalled, got %v", err)
+	}
+}
+
+func TestBilibili_CopyLiveStream_TooSlow(t *testing.T) {
+	// about 10KiB/s
+	chunk := make([]byte, InitReadBytes)
+	err := copyStalledStream(t, stallingHandler(chunk, 400*time.Millisecond), StallDetection{
+		Timeout:  time.Second,
+		MinSpeed: 1024 * 1024,
+	})
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Fatalf("expected ErrStreamStalled, got %v", err)
+	}
+}
+
+func TestBilibili_CopyLiveStream_NotStalled(t *testing.T) {
+	server := httptest.NewServer(stallingHandler(make([]byte, InitReadBytes), 50*time.Millisecond))
+	defer server.Close()
+
+	// the buffer may be much larger than the data received in the timeout
+	for _, bufSize := range []int64{1024, 4 * 1024 * 1024} {
+		bi := newTestBilibili()
+		bi.SetStallDetection(StallDetection{Timeout: 300 * time.Millisecond, MinSpeed: 1024})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		stream := types.StreamingUrlInfo{URL: server.URL + "/live.flv"}
+		err := bi.CopyLiveStream(ctx, 1234, stream, func() (io.Writer, error) {
+			return io.Discard, nil
+		}, bufSize)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("buffer size %v: expected the copy to run until the deadline, got %v", bufSize, err)
+		}
+	}
+}
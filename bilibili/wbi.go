@@ -0,0 +1,131 @@
+/*
+In this file we implement WBI signing, which is required by some web APIs, or they return code -352.
+The query string is signed with a mixin key, which is mixed from the img key and the sub key.
+The keys are got from the nav API and change every day, so they are cached for a while.
+*/
+package bilibili
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mixinKeyEncTab is the permutation which mixes the img key and the sub key
+var mixinKeyEncTab = [...]int{
+	46, 47, 18, 2, 53, 8, 23, 32, 15, 50, 10, 31, 58, 3, 45, 35, 27, 43, 5, 49,
+	33, 9, 42, 19, 29, 28, 14, 39, 12, 38, 41, 13, 37, 48, 7, 16, 24, 55, 40,
+	61, 26, 17, 0, 1, 60, 51, 30, 4, 22, 25, 54, 21, 56, 59, 6, 63, 57, 62, 11,
+	36, 20, 34, 44, 52,
+}
+
+// wbiKeyTtl is how long the mixin key is cached
+const wbiKeyTtl = time.Hour
+
+// wbiIllegalChars are removed from query values before signing
+const wbiIllegalChars = "!'()*"
+
+// wbiSigner caches the mixin key. It is safe to be used from multiple goroutines.
+type wbiSigner struct {
+	lock     sync.Mutex
+	mixinKey string
+	updated  time.Time
+}
+
+// getMixinKey returns the cached mixin key, or calls fetch to get new keys if the cache is expired.
+func (s *wbiSigner) getMixinKey(fetch func() (imgKey, subKey string, err error)) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.mixinKey != "" && time.Since(s.updated) < wbiKeyTtl {
+		return s.mixinKey, nil
+	}
+	imgKey, subKey, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	s.mixinKey = mixinKey(imgKey, subKey)
+	s.updated = time.Now()
+	return s.mixinKey, nil
+}
+
+// invalidate drops the cached mixin key, e.g. when the signature is rejected.
+func (s *wbiSigner) invalidate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.mixinKey = ""
+}
+
+// mixinKey permutes the concatenation of the keys, and returns the first 32 characters.
+func mixinKey(imgKey, subKey string) string {
+	orig := imgKey + subKey
+	var sb strings.Builder
+	for _, i := range mixinKeyEncTab {
+		if i < len(orig) {
+			sb.WriteByte(orig[i])
+		}
+	}
+	key := sb.String()
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	return key
+}
+
+// signWbi adds `wts` and `w_rid` to the query, and returns the encoded query string.
+// Illegal characters in values are removed, since the server removes them before checking the signature.
+func signWbi(query url.Values, mixinKey string, now time.Time) string {
+	signed := make(url.Values, len(query)+2)
+	for k, values := range query {
+		for _, v := range values {
+			signed.Add(k, strings.Map(func(r rune) rune {
+				if strings.ContainsRune(wbiIllegalChars, r) {
+					return -1
+				}
+				return r
+			}, v))
+		}
+	}
+	signed.Set("wts", strconv.FormatInt(now.Unix(), 10))
+	// Encode sorts by keys. The signature is computed on the output of encodeURIComponent,
+	// which encodes spaces as "%20" instead of "+"
+	q := strings.ReplaceAll(signed.Encode(), "+", "%20")
+	sum := md5.Sum([]byte(q + mixinKey))
+	return q + "&w_rid=" + hex.EncodeToString(sum[:])
+}
+
+// wbiKeyFromUrl returns the key in URLs of the nav API, which is the file name without the extension name,
+// e.g. "https://i0.hdslb.com/bfs/wbi/7cd084941338484aae1ad9425b84077c.png".
+func wbiKeyFromUrl(u string) string {
+	name := path.Base(u)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// fetchWbiKeys gets the img key and the sub key from the nav API, which works without logging in.
+func (b *Bilibili) fetchWbiKeys() (imgKey, subKey string, err error) {
+	resp, err := b.GetLoginInfo()
+	if err != nil {
+		return
+	}
+	imgKey = wbiKeyFromUrl(resp.Data.WbiImg.ImgUrl)
+	subKey = wbiKeyFromUrl(resp.Data.WbiImg.SubUrl)
+	if imgKey == "" || subKey == "" {
+		err = fmt.Errorf("no WBI keys in nav response (code %v): %v", resp.Code, resp.Message)
+	}
+	return
+}
+
+// wbiSign signs the query string of u.
+func (b *Bilibili) wbiSign(u *url.URL) error {
+	key, err := b.wbi.getMixinKey(b.fetchWbiKeys)
+	if err != nil {
+		return fmt.Errorf("cannot get WBI keys: %w", err)
+	}
+	u.RawQuery = signWbi(u.Query(), key, time.Now())
+	return nil
+}
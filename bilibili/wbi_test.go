@@ -0,0 +1,134 @@
+package bilibili
+
+import (
+	"fmt"
+	"github.com/keuin/slbr/types"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testImgKey = "7cd084941338484aae1ad9425b84077c"
+	testSubKey = "4932caff0ff746eab6f01bf08b70ac45"
+)
+
+func TestMixinKey(t *testing.T) {
+	key := mixinKey(testImgKey, testSubKey)
+	if key != "ea1db124af3c7062474693fa704f4ff8" {
+		t.Fatalf("unexpected mixin key: %v", key)
+	}
+}
+
+func TestSignWbi(t *testing.T) {
+	query := url.Values{
+		"foo": {"114"},
+		"bar": {"514"},
+		"zab": {"1919810"},
+	}
+	q := signWbi(query, mixinKey(testImgKey, testSubKey), time.Unix(1702204169, 0))
+	expected := "bar=514&foo=114&wts=1702204169&zab=1919810&w_rid=8f6f2b5b3d485fe1886cec6a0be8c5d4"
+	if q != expected {
+		t.Fatalf("unexpected query: %v, expected: %v", q, expected)
+	}
+
+	// spaces are encoded as "%20"
+	q = signWbi(url.Values{
+		"foo": {"one two"},
+		"bar": {"514"},
+		"zab": {"1919810"},
+	}, mixinKey(testImgKey, testSubKey), time.Unix(1702204169, 0))
+	expected = "bar=514&foo=one%20two&wts=1702204169&zab=1919810&w_rid=9b2aa6167e14127a8d047764b67c6fd9"
+	if q != expected {
+		t.Fatalf("unexpected query: %v, expected: %v", q, expected)
+	}
+
+	// illegal characters are removed before signing
+	q1 := signWbi(url.Values{"a": {"(x)!*'"}}, "key", time.Unix(0, 0))
+	q2 := signWbi(url.Values{"a": {"x"}}, "key", time.Unix(0, 0))
+	if q1 != q2 {
+		t.Fatalf("illegal characters are not removed: %v, %v", q1, q2)
+	}
+}
+
+func TestWbiKeyFromUrl(t *testing.T) {
+	key := wbiKeyFromUrl("https://i0.hdslb.com/bfs/wbi/" + testImgKey + ".png")
+	if key != testImgKey {
+		t.Fatalf("unexpected key: %v", key)
+	}
+	if key := wbiKeyFromUrl(""); key != "" {
+		t.Fatalf("unexpected key of empty URL: %v", key)
+	}
+}
+
+func TestBilibili_Wbi(t *testing.T) {
+	var navCalls, rejects atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/x/web-interface/nav", func(w http.ResponseWriter, r *http.Request) {
+		navCalls.Add(1)
+		_, _ = fmt.Fprintf(w, `{"code":-101,"message":"账号未登录","ttl":1,"data":{"isLogin":false,`+
+			`"wbi_img":{"img_url":"https://i0.hdslb.com/bfs/wbi/%v.png","sub_url":"https://i0.hdslb.com/bfs/wbi/%v.png"}}}`,
+			testImgKey, testSubKey)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		wts, err := strconv.ParseInt(q.Get("wts"), 10, 64)
+		if err != nil {
+			_, _ = fmt.Fprint(w, `{"code":-352,"message":"-352","ttl":1}`)
+			return
+		}
+		if rejects.Load() > 0 {
+			rejects.Add(-1)
+			_, _ = fmt.Fprint(w, `{"code":-352,"message":"-352","ttl":1}`)
+			return
+		}
+		wRid := q.Get("w_rid")
+		q.Del("w_rid")
+		expected := signWbi(q, mixinKey(testImgKey, testSubKey), time.Unix(wts, 0))
+		if expected != r.URL.RawQuery || wRid == "" {
+			_, _ = fmt.Fprint(w, `{"code":-352,"message":"-352","ttl":1}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"code":0,"message":"0","ttl":1}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	bi := newTestBilibili()
+	bi.mainApiUrl = server.URL
+
+	resp, err := callGet[types.BaseResponse[struct{}]](bi, server.URL+"/api?room_id=1234")
+	if err != nil {
+		t.Fatalf("callGet: %v", err)
+	}
+	if resp.Code != -352 || navCalls.Load() != 0 {
+		t.Fatalf("request without withWbi is signed: code %v, nav calls %v", resp.Code, navCalls.Load())
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err = callGet[types.BaseResponse[struct{}]](bi, server.URL+"/api?room_id=1234", withWbi)
+		if err != nil {
+			t.Fatalf("callGet: %v", err)
+		}
+		if resp.Code != 0 {
+			t.Fatalf("signed request is rejected: %v", resp.Code)
+		}
+	}
+	if navCalls.Load() != 1 {
+		t.Fatalf("WBI keys are not cached, nav is called %v times", navCalls.Load())
+	}
+
+	// keys are fetched again after the signature is rejected
+	rejects.Store(1)
+	_, _ = callGet[types.BaseResponse[struct{}]](bi, server.URL+"/api?room_id=1234", withWbi)
+	resp, err = callGet[types.BaseResponse[struct{}]](bi, server.URL+"/api?room_id=1234", withWbi)
+	if err != nil || resp.Code != 0 {
+		t.Fatalf("callGet: code %v, err %v", resp.Code, err)
+	}
+	if navCalls.Load() != 2 {
+		t.Fatalf("WBI keys are not fetched again, nav is called %v times", navCalls.Load())
+	}
+}
@@ -0,0 +1,11 @@
+package files
+
+/*
+In this file we declare the platform-independent part of free space queries.
+Implementations are in diskspace_*.go, selected by build tags.
+*/
+
+import "errors"
+
+// ErrFreeSpaceUnsupported is returned by FreeSpace on platforms where free space cannot be queried.
+var ErrFreeSpaceUnsupported = errors.New("querying free disk space is not supported on this platform")
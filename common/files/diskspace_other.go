@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || dragonfly || windows)
+
+package files
+
+// FreeSpace always returns ErrFreeSpaceUnsupported on this platform.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
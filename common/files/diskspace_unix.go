@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package files
+
+import "golang.org/x/sys/unix"
+
+// FreeSpace returns the number of bytes available to the current user on the filesystem containing dir.
+func FreeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	// field types differ between platforms
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,189 @@
+package files
+
+/*
+In this file we convert arbitrary strings (e.g. live titles) to file names
+which are valid on common filesystems, including NTFS, exFAT and SMB shares.
+Illegal characters are replaced according to the policy, reserved device names of Windows are escaped,
+and long names are truncated in bytes without breaking multibyte characters.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReplacePolicy decides how illegal characters in file names are handled.
+type ReplacePolicy string
+
+const (
+	// ReplaceWithString replaces each illegal character with the replacement string.
+	ReplaceWithString ReplacePolicy = "replace"
+	// ReplaceWithFullWidth replaces illegal ASCII characters with their full-width forms,
+	// which look alike and are allowed everywhere. Control characters are removed.
+	ReplaceWithFullWidth ReplacePolicy = "fullwidth"
+	// RemoveIllegal removes illegal characters.
+	RemoveIllegal ReplacePolicy = "remove"
+)
+
+const (
+	// DefaultReplacement is used by ReplaceWithString if no replacement is specified
+	DefaultReplacement = "_"
+	// DefaultMaxBytes is the maximum length of a file name on most filesystems
+	DefaultMaxBytes = 255
+)
+
+// illegalChars are not allowed in file names on Windows, exFAT and SMB shares.
+// Control characters are not allowed either.
+const illegalChars = `/\:*?"<>|`
+
+var fullWidthChars = map[rune]rune{
+	'/':  '／',
+	'\\': '＼',
+	':':  '：',
+	'*':  '＊',
+	'?':  '？',
+	'"':  '＂',
+	'<':  '＜',
+	'>':  '＞',
+	'|':  '｜',
+}
+
+// reservedNames are device names on Windows, which cannot be used as file names, with or without extension names.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Sanitizer converts strings to safe file names. Create it with NewSanitizer.
+type Sanitizer struct {
+	policy      ReplacePolicy
+	replacement string
+	maxBytes    int
+}
+
+// NewSanitizer creates a Sanitizer. Empty policy means ReplaceWithString, empty replacement means
+// DefaultReplacement, and maxBytes <= 0 means DefaultMaxBytes.
+func NewSanitizer(policy ReplacePolicy, replacement string, maxBytes int) (*Sanitizer, error) {
+	switch policy {
+	case "":
+		policy = ReplaceWithString
+	case ReplaceWithString, ReplaceWithFullWidth, RemoveIllegal:
+	default:
+		return nil, fmt.Errorf("unknown file name replace policy: %v", policy)
+	}
+	if replacement == "" {
+		replacement = DefaultReplacement
+	}
+	if !isLegal(replacement) {
+		return nil, fmt.Errorf("file name replacement contains illegal characters: %q", replacement)
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &Sanitizer{
+		policy:      policy,
+		replacement: replacement,
+		maxBytes:    maxBytes,
+	}, nil
+}
+
+// DefaultSanitizer replaces illegal characters with DefaultReplacement.
+var DefaultSanitizer, _ = NewSanitizer(ReplaceWithString, DefaultReplacement, DefaultMaxBytes)
+
+// MaxBytes returns the maximum length of file names in bytes.
+func (s *Sanitizer) MaxBytes() int {
+	return s.maxBytes
+}
+
+func isIllegal(r rune) bool {
+	return r < 0x20 || r == 0x7f || strings.ContainsRune(illegalChars, r)
+}
+
+func isLegal(s string) bool {
+	return utf8.ValidString(s) && strings.IndexFunc(s, isIllegal) < 0
+}
+
+// ReplaceIllegal replaces illegal characters and invalid UTF-8 sequences in s.
+// It does not truncate or trim s, so it is suitable for parts of a file name.
+func (s *Sanitizer) ReplaceIllegal(name string) string {
+	name = strings.ToValidUTF8(name, "�")
+	if isLegal(name) {
+		return name
+	}
+	var sb strings.Builder
+	for _, r := range name {
+		if !isIllegal(r) {
+			sb.WriteRune(r)
+			continue
+		}
+		switch s.policy {
+		case ReplaceWithString:
+			sb.WriteString(s.replacement)
+		case ReplaceWithFullWidth:
+			if fw, ok := fullWidthChars[r]; ok {
+				sb.WriteRune(fw)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Sanitize converts name to a valid file name, which is not longer than maxBytes bytes.
+// If maxBytes <= 0, the maximum length of the Sanitizer is used.
+// Leading spaces and trailing dots and spaces are removed, since Windows does not keep them.
+func (s *Sanitizer) Sanitize(name string, maxBytes int) string {
+	if maxBytes <= 0 || maxBytes > s.maxBytes {
+		maxBytes = s.maxBytes
+	}
+	name = trim(s.ReplaceIllegal(name))
+	base, ext, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = base + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+	name = trim(TruncateBytes(name, maxBytes))
+	if name == "" {
+		return TruncateBytes(s.replacement, maxBytes)
+	}
+	return name
+}
+
+func trim(name string) string {
+	return strings.TrimRight(strings.TrimLeft(name, " "), ". ")
+}
+
+// TruncateBytes returns the longest prefix of s which is not longer than n bytes.
+// Multibyte characters are kept whole.
+func TruncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// UniqueName returns name if it is not used, otherwise a suffix "_2", "_3", ... is appended.
+// used reports whether a name is used by existing files.
+func UniqueName(name string, used func(name string) bool) string {
+	if !used(name) {
+		return name
+	}
+	for i := 2; ; i++ {
+		n := name + "_" + strconv.Itoa(i)
+		if !used(n) {
+			return n
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package files
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizer_Sanitize(t *testing.T) {
+	fullWidth, err := NewSanitizer(ReplaceWithFullWidth, "", 0)
+	if err != nil {
+		t.Fatalf("NewSanitizer: %v", err)
+	}
+	remove, err := NewSanitizer(RemoveIllegal, "", 0)
+	if err != nil {
+		t.Fatalf("NewSanitizer: %v", err)
+	}
+	dash, err := NewSanitizer(ReplaceWithString, "-", 0)
+	if err != nil {
+		t.Fatalf("NewSanitizer: %v", err)
+	}
+	tests := []struct {
+		s        *Sanitizer
+		name     string
+		expected string
+	}{
+		{DefaultSanitizer, `a/b\c:d*e?f"g<h>i|j`, "a_b_c_d_e_f_g_h_i_j"},
+		{DefaultSanitizer, "tab\there\x00\x7f", "tab_here__"},
+		{DefaultSanitizer, "  title. . ", "title"},
+		{DefaultSanitizer, "...", "_"},
+		{DefaultSanitizer, "", "_"},
+		{DefaultSanitizer, "invalid\xff", "invalid�"},
+		{DefaultSanitizer, "CON", "CON_"},
+		{DefaultSanitizer, "lpt1.flv", "lpt1_.flv"},
+		{DefaultSanitizer, "nul .txt", "nul _.txt"},
+		{DefaultSanitizer, "CONSOLE", "CONSOLE"},
+		{fullWidth, "a/b:c?\n", "a／b：c？"},
+		{remove, "a/b:c?\n", "abc"},
+		{dash, "a/b", "a-b"},
+	}
+	for i, tc := range tests {
+		if actual := tc.s.Sanitize(tc.name, 0); actual != tc.expected {
+			t.Fatalf("Test %v failed: expected %q, got %q", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestSanitizer_Truncate(t *testing.T) {
+	// 3 bytes each
+	name := strings.Repeat("字", 100)
+	actual := DefaultSanitizer.Sanitize(name, 0)
+	if actual != strings.Repeat("字", 85) {
+		t.Fatalf("unexpected result: %v (%v bytes)", actual, len(actual))
+	}
+	actual = DefaultSanitizer.Sanitize(name, 10)
+	if actual != strings.Repeat("字", 3) {
+		t.Fatalf("unexpected result: %v", actual)
+	}
+	// 4 bytes
+	if actual := TruncateBytes("a😀b", 4); actual != "a" {
+		t.Fatalf("unexpected result: %v", actual)
+	}
+	if actual := TruncateBytes("a😀b", 5); actual != "a😀" {
+		t.Fatalf("unexpected result: %v", actual)
+	}
+}
+
+func TestNewSanitizer(t *testing.T) {
+	if _, err := NewSanitizer("unknown", "", 0); err == nil {
+		t.Fatalf("unknown policy is accepted")
+	}
+	if _, err := NewSanitizer(ReplaceWithString, "/", 0); err == nil {
+		t.Fatalf("illegal replacement is accepted")
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	used := map[string]bool{"a": true, "a_2": true}
+	if actual := UniqueName("a", func(name string) bool { return used[name] }); actual != "a_3" {
+		t.Fatalf("unexpected result: %v", actual)
+	}
+	if actual := UniqueName("b", func(name string) bool { return used[name] }); actual != "b" {
+		t.Fatalf("unexpected result: %v", actual)
+	}
+}
@@ -4,4 +4,12 @@ import "github.com/keuin/slbr/recording"
 
 type GlobalConfig struct {
 	Tasks []recording.TaskConfig `mapstructure:"tasks"`
+	Api   ApiConfig              `mapstructure:"api"`
+}
+
+type ApiConfig struct {
+	// Listen is the address of the HTTP API, e.g. "127.0.0.1:8080". The API is disabled if empty.
+	Listen string `mapstructure:"listen"`
+	// Token: if not empty, API requests must have header `Authorization: Bearer <token>`
+	Token string `mapstructure:"token"`
 }
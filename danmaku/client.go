@@ -69,8 +69,8 @@ func (d *DanmakuClient) Disconnect() error {
 	return ws.Close(websocket.StatusInternalError, "disconnected")
 }
 
-func (d *DanmakuClient) Authenticate(roomId types.RoomId, authKey, buvid3 string) error {
-	pkg := dmpkg.NewAuth(dmpkg.ProtoBrotli, roomId, authKey, buvid3)
+func (d *DanmakuClient) Authenticate(roomId types.RoomId, uid uint64, authKey, buvid3 string) error {
+	pkg := dmpkg.NewAuth(dmpkg.ProtoBrotli, roomId, uid, authKey, buvid3)
 	data, err := pkg.Marshal()
 	if err != nil {
 		return fmt.Errorf("exchange marshal failed: %w", err)
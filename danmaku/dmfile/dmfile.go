@@ -0,0 +1,87 @@
+/*
+Package dmfile implements the sidecar file format of captured danmaku.
+Each line of the file is a JSON object containing a danmaku message
+and its offset from the start of the recorded video.
+*/
+package dmfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"io"
+	"time"
+)
+
+// ExtName is the extension name of danmaku sidecar files.
+const ExtName = "danmaku.jsonl"
+
+// Entry is a captured danmaku message.
+type Entry struct {
+	// OffsetMillis is the offset from the start of the video, in milliseconds
+	OffsetMillis int64              `json:"offset_ms"`
+	Message      dmmsg.DanMuMessage `json:"message"`
+}
+
+// Offset returns the offset from the start of the video.
+func (e Entry) Offset() time.Duration {
+	return time.Duration(e.OffsetMillis) * time.Millisecond
+}
+
+// Writer writes danmaku messages to an underlying writer as JSON lines.
+// It is not thread-safe.
+type Writer struct {
+	enc   *json.Encoder
+	start time.Time
+}
+
+// NewWriter creates a Writer. start is the time when the video starts.
+func NewWriter(w io.Writer, start time.Time) *Writer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &Writer{
+		enc:   enc,
+		start: start,
+	}
+}
+
+// Write saves a danmaku message which is received at the given time.
+func (w *Writer) Write(dm dmmsg.DanMuMessage, receivedAt time.Time) error {
+	return w.enc.Encode(Entry{
+		OffsetMillis: receivedAt.Sub(w.start).Milliseconds(),
+		Message:      dm,
+	})
+}
+
+// Delay moves the start of the video later by d.
+// It is used when a gap of d is removed from the video, so later messages are aligned with the video.
+func (w *Writer) Delay(d time.Duration) {
+	w.start = w.start.Add(d)
+}
+
+// ReadAll reads all entries from a sidecar file.
+// A truncated last line, which may be left by a crash, is ignored.
+func ReadAll(r io.Reader) (entries []Entry, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var pending error
+	line := 0
+	for sc.Scan() {
+		line++
+		if pending != nil {
+			// only the last line is allowed to be broken
+			return nil, pending
+		}
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			pending = fmt.Errorf("invalid danmaku entry at line %v: %w", line, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
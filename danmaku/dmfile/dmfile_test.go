@@ -0,0 +1,51 @@
+package dmfile
+
+import (
+	"bytes"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"testing"
+	"time"
+)
+
+func TestWriteAndReadAll(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1700000000, 0)
+	w := NewWriter(&buf, start)
+	var dm dmmsg.DanMuMessage
+	dm.Content = "<hello> & 你好"
+	dm.SourceUser.Nickname = "foo"
+	dm.SourceUser.UID = 123
+	if err := w.Write(dm, start.Add(1500*time.Millisecond)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	dm.Content = "bar"
+	if err := w.Write(dm, start.Add(time.Minute)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// simulate a crash while writing the last line
+	buf.WriteString(`{"offset_ms":6`)
+
+	entries, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entry count: %v", len(entries))
+	}
+	if e := entries[0]; e.Offset() != 1500*time.Millisecond ||
+		e.Message.Content != "<hello> & 你好" ||
+		e.Message.SourceUser.Nickname != "foo" ||
+		e.Message.SourceUser.UID != 123 {
+		t.Fatalf("unexpected entry: %v", e)
+	}
+	if e := entries[1]; e.Offset() != time.Minute || e.Message.Content != "bar" {
+		t.Fatalf("unexpected entry: %v", e)
+	}
+}
+
+func TestReadAllBrokenLine(t *testing.T) {
+	buf := bytes.NewBufferString("{\"offset_ms\":1\n{\"offset_ms\":2}\n")
+	if _, err := ReadAll(buf); err == nil {
+		t.Fatalf("a broken line which is not the last line should be reported")
+	}
+}
@@ -0,0 +1,247 @@
+package dmmsg
+
+/*
+Exporter of ASS subtitles.
+Scrolling danmaku move from right to left in lanes,
+top and bottom danmaku are shown at fixed positions.
+A lane is reused only when the new danmaku cannot collide with the previous one in that lane.
+Danmaku which cannot be placed in any lane are dropped.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type AssOptions struct {
+	// Width and Height are the resolution of the subtitle canvas (PlayResX, PlayResY)
+	Width  int
+	Height int
+	// FontName is the font used to render danmaku
+	FontName string
+	// FontSize is the font size of a danmaku with standard size (25)
+	FontSize int
+	// ScrollDuration is how long a scrolling danmaku takes to move across the screen
+	ScrollDuration time.Duration
+	// FixedDuration is how long a top or bottom danmaku stays on the screen
+	FixedDuration time.Duration
+	// Opacity ranges from 0 (transparent) to 1 (opaque)
+	Opacity float64
+	// ScrollArea is the ratio of screen height which scrolling danmaku can use
+	ScrollArea float64
+}
+
+func DefaultAssOptions() AssOptions {
+	return AssOptions{
+		Width:          1920,
+		Height:         1080,
+		FontName:       "Microsoft YaHei",
+		FontSize:       48,
+		ScrollDuration: 10 * time.Second,
+		FixedDuration:  5 * time.Second,
+		Opacity:        0.8,
+		ScrollArea:     0.8,
+	}
+}
+
+const assStyleName = "Danmaku"
+
+// scrollLaneItem is the last scrolling danmaku placed in a lane.
+type scrollLaneItem struct {
+	start time.Duration
+	width float64
+}
+
+// AssWriter writes danmaku messages as an ASS subtitle.
+// Danmaku must be written in the order of their offsets.
+// Close must be called to flush buffered data.
+type AssWriter struct {
+	w          *bufio.Writer
+	opts       AssOptions
+	laneHeight int
+	scroll     []*scrollLaneItem
+	// top and bottom lanes save the time when they become free
+	top     []time.Duration
+	bottom  []time.Duration
+	dropped int
+}
+
+func NewAssWriter(w io.Writer, opts AssOptions) (*AssWriter, error) {
+	bw := bufio.NewWriter(w)
+	alpha := assAlpha(opts.Opacity)
+	_, err := fmt.Fprintf(bw, `[Script Info]
+; Generated by slbr
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+WrapStyle: 2
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: %s,%s,%d,&H%02XFFFFFF,&H%02XFFFFFF,&H%02X000000,&H%02X000000,0,0,0,0,100,100,0,0,1,1,0,7,0,0,0,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`,
+		opts.Width, opts.Height,
+		assStyleName, opts.FontName, opts.FontSize, alpha, alpha, alpha, alpha,
+	)
+	if err != nil {
+		return nil, err
+	}
+	laneHeight := opts.FontSize + opts.FontSize/8
+	if laneHeight <= 0 {
+		return nil, fmt.Errorf("invalid font size: %v", opts.FontSize)
+	}
+	scrollLanes := int(float64(opts.Height) * opts.ScrollArea / float64(laneHeight))
+	fixedLanes := opts.Height / 2 / laneHeight
+	if scrollLanes < 1 {
+		scrollLanes = 1
+	}
+	if fixedLanes < 1 {
+		fixedLanes = 1
+	}
+	return &AssWriter{
+		w:          bw,
+		opts:       opts,
+		laneHeight: laneHeight,
+		scroll:     make([]*scrollLaneItem, scrollLanes),
+		top:        make([]time.Duration, fixedLanes),
+		bottom:     make([]time.Duration, fixedLanes),
+	}, nil
+}
+
+// Write places a danmaku which is shown at the given offset from the start of the video.
+func (a *AssWriter) Write(offset time.Duration, dm DanMuMessage) error {
+	text := assEscape(dm.Content)
+	if text == "" {
+		return nil
+	}
+	size := a.opts.FontSize * dm.fontSize() / DefaultFontSize
+	style := ""
+	if size != a.opts.FontSize {
+		style += fmt.Sprintf(`\fs%d`, size)
+	}
+	if c := dm.Color & 0xffffff; c != DefaultColor {
+		// ASS colors are in BGR order
+		style += fmt.Sprintf(`\c&H%02X%02X%02X&`, c&0xff, (c>>8)&0xff, c>>16)
+	}
+
+	var start, end time.Duration
+	switch dm.mode() {
+	case ModeTop, ModeBottom:
+		lanes := a.top
+		if dm.mode() == ModeBottom {
+			lanes = a.bottom
+		}
+		lane := -1
+		for i, free := range lanes {
+			if free <= offset {
+				lane = i
+				break
+			}
+		}
+		if lane < 0 {
+			a.dropped++
+			return nil
+		}
+		start, end = offset, offset+a.opts.FixedDuration
+		lanes[lane] = end
+		y := lane * a.laneHeight
+		if dm.mode() == ModeBottom {
+			y = a.opts.Height - (lane+1)*a.laneHeight
+		}
+		style = fmt.Sprintf(`\an8\pos(%d,%d)`, a.opts.Width/2, y) + style
+	default:
+		width := textWidth(dm.Content, size)
+		lane := -1
+		for i, last := range a.scroll {
+			if last == nil || a.canFollow(last, offset, width) {
+				lane = i
+				break
+			}
+		}
+		if lane < 0 {
+			a.dropped++
+			return nil
+		}
+		a.scroll[lane] = &scrollLaneItem{start: offset, width: width}
+		start, end = offset, offset+a.opts.ScrollDuration
+		y := lane * a.laneHeight
+		style = fmt.Sprintf(`\move(%d,%d,%d,%d)`,
+			a.opts.Width, y, -int(math.Ceil(width)), y) + style
+	}
+	_, err := fmt.Fprintf(a.w, "Dialogue: 0,%s,%s,%s,,0,0,0,,{%s}%s\n",
+		assTime(start), assTime(end), assStyleName, style, text)
+	return err
+}
+
+// canFollow reports if a scrolling danmaku with given width
+// can be placed after the previous one in the same lane without overlapping.
+func (a *AssWriter) canFollow(prev *scrollLaneItem, start time.Duration, width float64) bool {
+	w := float64(a.opts.Width)
+	d := a.opts.ScrollDuration.Seconds()
+	prevSpeed := (w + prev.width) / d
+	speed := (w + width) / d
+	elapsed := (start - prev.start).Seconds()
+	// the tail of the previous danmaku has entered the screen
+	if elapsed < prev.width/prevSpeed {
+		return false
+	}
+	// the previous danmaku has left the screen before the new one reaches the left edge
+	return d <= elapsed+w/speed
+}
+
+// Dropped returns the number of danmaku which are dropped because all lanes are occupied.
+func (a *AssWriter) Dropped() int {
+	return a.dropped
+}
+
+// Close flushes buffered data. The underlying writer is not closed.
+func (a *AssWriter) Close() error {
+	return a.w.Flush()
+}
+
+func assAlpha(opacity float64) int {
+	opacity = math.Max(0, math.Min(1, opacity))
+	return int(math.Round(255 * (1 - opacity)))
+}
+
+func assTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// assEscape removes characters which have special meanings in ASS dialogues.
+var assEscape = strings.NewReplacer(
+	`\`, `＼`,
+	`{`, `｛`,
+	`}`, `｝`,
+	"\r", "",
+	"\n", " ",
+).Replace
+
+// textWidth estimates the rendered width of a text.
+// Wide characters (e.g. CJK) are as wide as the font size, while ASCII characters are about a half.
+func textWidth(s string, size int) float64 {
+	var w float64
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+		if r < utf8.RuneSelf {
+			w += float64(size) / 2
+		} else {
+			w += float64(size)
+		}
+	}
+	return w
+}
@@ -0,0 +1,90 @@
+package dmmsg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assDialogues(s string) (lines []string) {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "Dialogue: ") {
+			lines = append(lines, line)
+		}
+	}
+	return
+}
+
+func TestAssWriter(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultAssOptions()
+	a, err := NewAssWriter(&buf, opts)
+	if err != nil {
+		t.Fatalf("NewAssWriter: %v", err)
+	}
+	scroll := DanMuMessage{Content: "{\\b1}hello", Mode: ModeScroll, FontSize: 25, Color: DefaultColor}
+	// two danmaku at the same time cannot share a lane
+	for i := 0; i < 2; i++ {
+		if err := a.Write(time.Second, scroll); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// the first lane is free again after the previous danmaku left the screen
+	if err := a.Write(time.Second+opts.ScrollDuration, scroll); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	top := DanMuMessage{Content: "top", Mode: ModeTop, FontSize: 25, Color: 0x112233}
+	if err := a.Write(2*time.Second, top); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "PlayResX: 1920") || !strings.Contains(out, "PlayResY: 1080") {
+		t.Fatalf("invalid header:\n%v", out)
+	}
+	lines := assDialogues(out)
+	expected := []string{
+		`Dialogue: 0,0:00:01.00,0:00:11.00,Danmaku,,0,0,0,,{\move(1920,0,-240,0)}｛＼b1｝hello`,
+		`Dialogue: 0,0:00:01.00,0:00:11.00,Danmaku,,0,0,0,,{\move(1920,54,-240,54)}｛＼b1｝hello`,
+		`Dialogue: 0,0:00:11.00,0:00:21.00,Danmaku,,0,0,0,,{\move(1920,0,-240,0)}｛＼b1｝hello`,
+		`Dialogue: 0,0:00:02.00,0:00:07.00,Danmaku,,0,0,0,,{\an8\pos(960,0)\c&H332211&}top`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("unexpected dialogues:\n%v", strings.Join(lines, "\n"))
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Fatalf("unexpected dialogue %v:\n%v\nexpected:\n%v", i, lines[i], expected[i])
+		}
+	}
+}
+
+func TestAssWriterDropsWhenFull(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultAssOptions()
+	opts.Height = opts.FontSize * 2
+	opts.ScrollArea = 1
+	a, err := NewAssWriter(&buf, opts)
+	if err != nil {
+		t.Fatalf("NewAssWriter: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.Write(0, DanMuMessage{Content: "foo"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	_ = a.Close()
+	if n := len(assDialogues(buf.String())); n != 1 || a.Dropped() != 2 {
+		t.Fatalf("unexpected result: %v dialogues, %v dropped", n, a.Dropped())
+	}
+}
+
+func TestAssTime(t *testing.T) {
+	if s := assTime(time.Hour + 2*time.Minute + 3*time.Second + 456*time.Millisecond); s != "1:02:03.45" {
+		t.Fatalf("unexpected time: %v", s)
+	}
+}
@@ -10,12 +10,37 @@ import (
 
 type RawDanMuMessage = BaseRawMessage[[]interface{}, interface{}]
 
+// DanMuMode is the display mode of a danmaku.
+type DanMuMode int
+
+const (
+	ModeScroll DanMuMode = 1
+	ModeBottom DanMuMode = 4
+	ModeTop    DanMuMode = 5
+)
+
+const (
+	// DefaultFontSize is the font size of a danmaku which does not specify one
+	DefaultFontSize = 25
+	// DefaultColor is the color of a danmaku which does not specify one (white)
+	DefaultColor = 0xffffff
+)
+
 type DanMuMessage struct {
-	Content    string
+	Content string    `json:"content"`
+	Mode    DanMuMode `json:"mode"`
+	// FontSize is the font size set by the sender, 25 is the standard size
+	FontSize int `json:"font_size"`
+	// Color is the text color in 0xRRGGBB
+	Color int `json:"color"`
+	// Timestamp is the time when this danmaku is sent, in unix milliseconds, 0 if unknown
+	Timestamp  int64 `json:"timestamp"`
 	SourceUser struct {
-		Nickname string
-		UID      int64
-	}
+		Nickname string `json:"nickname"`
+		UID      int64  `json:"uid"`
+		// Hash is the CRC32 hash of user's UID in hex, which is still available when the UID is masked
+		Hash string `json:"hash"`
+	} `json:"user"`
 }
 
 func (dm DanMuMessage) String() string {
@@ -25,18 +50,36 @@ func (dm DanMuMessage) String() string {
 
 const InvalidDanmakuJson = "invalid danmaku JSON document"
 
+// ParseDanmakuMessage decodes a DANMU_MSG message. Only the text and the user are required,
+// optional fields in info[0] fall back to the defaults if they are absent or of unexpected types,
+// since Bilibili changes them from time to time.
 func ParseDanmakuMessage(body RawDanMuMessage) (dmm DanMuMessage, err error) {
-	if len(body.Info) < 16 {
-		err = fmt.Errorf("%s: \"info\" length < 16", InvalidDanmakuJson)
+	if len(body.Info) < 3 {
+		err = fmt.Errorf("%s: \"info\" length < 3", InvalidDanmakuJson)
 		return
 	}
 
+	// info[0]: [?, mode, font size, color, timestamp, random, ?, user hash, ...]
+	meta, _ := body.Info[0].([]interface{})
+	dmm.Mode = DanMuMode(metaValue[float64](meta, 1, float64(ModeScroll)))
+	dmm.FontSize = int(metaValue[float64](meta, 2, DefaultFontSize))
+	dmm.Color = int(metaValue[float64](meta, 3, DefaultColor))
+	dmm.Timestamp = int64(metaValue[float64](meta, 4, 0))
+	dmm.SourceUser.Hash = metaValue[string](meta, 7, "")
+
 	dmm.Content, err = castValue[string](body.Info[1])
 	if err != nil {
 		return
 	}
 
 	userInfo, err := castValue[[]interface{}](body.Info[2])
+	if err != nil {
+		return
+	}
+	if len(userInfo) < 2 {
+		err = fmt.Errorf("%s: \"info[2]\" length < 2", InvalidDanmakuJson)
+		return
+	}
 
 	var ok bool
 	uid, ok := userInfo[0].(float64)
@@ -53,3 +96,14 @@ func ParseDanmakuMessage(body RawDanMuMessage) (dmm DanMuMessage, err error) {
 	}
 	return
 }
+
+// metaValue returns meta[i] as T, or fallback if it is absent or of another type.
+func metaValue[T any](meta []interface{}, i int, fallback T) T {
+	if i >= len(meta) {
+		return fallback
+	}
+	if v, ok := meta[i].(T); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,101 @@
+package dmmsg
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const danmuMsgJson = `{"cmd":"DANMU_MSG","info":[[0,5,30,16711680,1700000000123,1700000000,0,"e3a5f1c2",0,0,0,"",0,"{}","{}",{"mode":0,"show_player_type":0,"extra":"{}"},{"activity_identity":"","activity_source":0,"not_show":0}],"hello 你好",[12345,"someone",0,0,0,10000,1,""],[21,"medal","anchor",6,1725515,"",0,6809855,1725515,5414290,0,0,35],[10,0,9868950,">50000",0],["",""],0,0,null,{"ts":1700000000,"ct":"5E1D8CA6"},0,0,null,null,0,105]}`
+
+func TestParseDanmakuMessage(t *testing.T) {
+	var raw RawDanMuMessage
+	if err := json.Unmarshal([]byte(danmuMsgJson), &raw); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	dm, err := ParseDanmakuMessage(raw)
+	if err != nil {
+		t.Fatalf("ParseDanmakuMessage: %v", err)
+	}
+	if dm.Content != "hello 你好" ||
+		dm.Mode != ModeTop ||
+		dm.FontSize != 30 ||
+		dm.Color != 0xff0000 ||
+		dm.Timestamp != 1700000000123 ||
+		dm.SourceUser.UID != 12345 ||
+		dm.SourceUser.Nickname != "someone" ||
+		dm.SourceUser.Hash != "e3a5f1c2" {
+		t.Fatalf("unexpected message: %+v", dm)
+	}
+}
+
+func TestParseDanmakuMessageFixtures(t *testing.T) {
+	// info[0] of these messages carries an emoticon object and the "extra" JSON string
+	for _, tc := range []struct {
+		fixture  string
+		content  string
+		mode     DanMuMode
+		fontSize int
+		color    int
+	}{
+		{CmdDanMu, "晚上好 主播", ModeTop, 30, 0xff0000},
+		{CmdDanMu + "_emoticon", "赞", ModeScroll, DefaultFontSize, DefaultColor},
+	} {
+		raw := parseFixture[RawDanMuMessage](t, tc.fixture)
+		dm, err := ParseDanmakuMessage(raw)
+		if err != nil {
+			t.Fatalf("ParseDanmakuMessage(%v): %v", tc.fixture, err)
+		}
+		if raw.Cmd != CmdDanMu ||
+			dm.Content != tc.content ||
+			dm.Mode != tc.mode ||
+			dm.FontSize != tc.fontSize ||
+			dm.Color != tc.color ||
+			dm.Timestamp != 1700000000123 ||
+			dm.SourceUser.UID != 8346723 ||
+			dm.SourceUser.Nickname != "观众甲" ||
+			dm.SourceUser.Hash != "e3a5f1c2" {
+			t.Fatalf("unexpected message from %v: %+v", tc.fixture, dm)
+		}
+	}
+}
+
+func TestParseDanmakuMessageShortMeta(t *testing.T) {
+	var raw RawDanMuMessage
+	data := `{"cmd":"DANMU_MSG","info":[[0,4,"large"],"hello",[12345,"someone"]]}`
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	dm, err := ParseDanmakuMessage(raw)
+	if err != nil {
+		t.Fatalf("ParseDanmakuMessage: %v", err)
+	}
+	// mode is kept, the font size of an unexpected type and absent fields fall back to the defaults
+	if dm.Content != "hello" ||
+		dm.Mode != ModeBottom ||
+		dm.FontSize != DefaultFontSize ||
+		dm.Color != DefaultColor ||
+		dm.Timestamp != 0 ||
+		dm.SourceUser.Hash != "" ||
+		dm.SourceUser.UID != 12345 ||
+		dm.SourceUser.Nickname != "someone" {
+		t.Fatalf("unexpected message: %+v", dm)
+	}
+
+	// the meta array is absent
+	raw.Info[0] = nil
+	dm, err = ParseDanmakuMessage(raw)
+	if err != nil {
+		t.Fatalf("ParseDanmakuMessage: %v", err)
+	}
+	if dm.Mode != ModeScroll || dm.FontSize != DefaultFontSize || dm.Color != DefaultColor {
+		t.Fatalf("unexpected message: %+v", dm)
+	}
+}
+
+func TestParseDanmakuMessageInvalid(t *testing.T) {
+	var raw RawDanMuMessage
+	raw.Info = make([]interface{}, 16)
+	if _, err := ParseDanmakuMessage(raw); err == nil {
+		t.Fatalf("invalid message should not be parsed")
+	}
+}
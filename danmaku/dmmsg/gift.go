@@ -0,0 +1,74 @@
+package dmmsg
+
+/*
+Decoders of gift messages.
+*/
+
+import "fmt"
+
+const (
+	// CoinTypeGold means the gift is paid with gold coins, 1000 gold coins = 1 CNY
+	CoinTypeGold = "gold"
+	// CoinTypeSilver means the gift is free
+	CoinTypeSilver = "silver"
+)
+
+type SendGiftMessage struct {
+	Action       string    `json:"action"`
+	BatchComboID string    `json:"batch_combo_id"`
+	CoinType     string    `json:"coin_type"`
+	DanMuScore   int       `json:"dmscore"`
+	Face         string    `json:"face"`
+	GiftID       int       `json:"giftId"`
+	GiftName     string    `json:"giftName"`
+	GiftType     int       `json:"giftType"`
+	GuardLevel   int       `json:"guard_level"`
+	MedalInfo    MedalInfo `json:"medal_info"`
+	Num          int       `json:"num"`
+	// Price is the price of one gift, in gold or silver coins
+	Price int `json:"price"`
+	// Rnd is an ID which can be used to deduplicate messages
+	Rnd       string `json:"rnd"`
+	Timestamp int64  `json:"timestamp"`
+	TotalCoin int    `json:"total_coin"`
+	UID       int64  `json:"uid"`
+	UserName  string `json:"uname"`
+}
+
+type RawSendGiftMessage = BaseRawMessage[interface{}, SendGiftMessage]
+
+func (g SendGiftMessage) String() string {
+	return fmt.Sprintf("(user: %v, uid: %v) %v %v x%v (%v %v)",
+		g.UserName, g.UID, g.Action, g.GiftName, g.Num, g.TotalCoin, g.CoinType)
+}
+
+// IsPaid reports if this gift is paid with real money.
+func (g SendGiftMessage) IsPaid() bool {
+	return g.CoinType == CoinTypeGold
+}
+
+// ComboSendMessage is sent when a user finishes sending a gift combo.
+type ComboSendMessage struct {
+	Action         string    `json:"action"`
+	BatchComboID   string    `json:"batch_combo_id"`
+	BatchComboNum  int       `json:"batch_combo_num"`
+	ComboID        string    `json:"combo_id"`
+	ComboNum       int       `json:"combo_num"`
+	ComboTotalCoin int       `json:"combo_total_coin"`
+	GiftID         int       `json:"gift_id"`
+	GiftName       string    `json:"gift_name"`
+	GiftNum        int       `json:"gift_num"`
+	MedalInfo      MedalInfo `json:"medal_info"`
+	ReceiverUID    int64     `json:"ruid"`
+	ReceiverName   string    `json:"r_uname"`
+	TotalNum       int       `json:"total_num"`
+	UID            int64     `json:"uid"`
+	UserName       string    `json:"uname"`
+}
+
+type RawComboSendMessage = BaseRawMessage[interface{}, ComboSendMessage]
+
+func (c ComboSendMessage) String() string {
+	return fmt.Sprintf("(user: %v, uid: %v) %v %v x%v (combo, %v gold)",
+		c.UserName, c.UID, c.Action, c.GiftName, c.TotalNum, c.ComboTotalCoin)
+}
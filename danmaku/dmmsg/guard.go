@@ -0,0 +1,49 @@
+package dmmsg
+
+import "fmt"
+
+// GuardLevel is the level of a guard (captain, admiral and governor).
+type GuardLevel int
+
+const (
+	GuardNone     GuardLevel = 0
+	GuardGovernor GuardLevel = 1
+	GuardAdmiral  GuardLevel = 2
+	GuardCaptain  GuardLevel = 3
+)
+
+var guardLevelStringMap = map[GuardLevel]string{
+	GuardNone:     "none",
+	GuardGovernor: "governor",
+	GuardAdmiral:  "admiral",
+	GuardCaptain:  "captain",
+}
+
+func (l GuardLevel) String() string {
+	if s, ok := guardLevelStringMap[l]; ok {
+		return s
+	}
+	return fmt.Sprintf("<GuardLevel %d>", int(l))
+}
+
+// GuardBuyMessage is sent when a user buys a guard.
+type GuardBuyMessage struct {
+	UID        int64      `json:"uid"`
+	UserName   string     `json:"username"`
+	GuardLevel GuardLevel `json:"guard_level"`
+	// Num is the number of months
+	Num int `json:"num"`
+	// Price is the price of one month, in gold coins
+	Price     int    `json:"price"`
+	GiftID    int    `json:"gift_id"`
+	GiftName  string `json:"gift_name"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+}
+
+type RawGuardBuyMessage = BaseRawMessage[interface{}, GuardBuyMessage]
+
+func (g GuardBuyMessage) String() string {
+	return fmt.Sprintf("(user: %v, uid: %v) bought %v x%v (%v gold)",
+		g.UserName, g.UID, g.GuardLevel, g.Num, g.Price*g.Num)
+}
@@ -1,7 +1,58 @@
 package dmmsg
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Commands of server messages
+const (
+	CmdDanMu           = "DANMU_MSG"
+	CmdInteractWord    = "INTERACT_WORD"
+	CmdWatchedChange   = "WATCHED_CHANGE"
+	CmdSendGift        = "SEND_GIFT"
+	CmdComboSend       = "COMBO_SEND"
+	CmdSuperChat       = "SUPER_CHAT_MESSAGE"
+	CmdGuardBuy        = "GUARD_BUY"
+	CmdRoomChange      = "ROOM_CHANGE"
+	CmdPreparing       = "PREPARING"
+	CmdLive            = "LIVE"
+	CmdRoomBlock       = "ROOM_BLOCK_MSG"
+	CmdWarning         = "WARNING"
+	CmdCutOff          = "CUT_OFF"
+	CmdOnlineRankCount = "ONLINE_RANK_COUNT"
+)
+
+const InvalidMessageJson = "invalid server message JSON document"
+
 type BaseRawMessage[I any, D any] struct {
 	Cmd  string `json:"cmd"`
 	Info I      `json:"info"`
 	Data D      `json:"data"`
 }
+
+// ParseMessage decodes a server message body into the given message type.
+func ParseMessage[T any](body []byte) (msg T, err error) {
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", InvalidMessageJson, err)
+	}
+	return
+}
+
+// MedalInfo is the fans medal worn by a user.
+type MedalInfo struct {
+	AnchorRoomid   int           `json:"anchor_roomid"`
+	AnchorUserName string        `json:"anchor_uname"`
+	GuardLevel     int           `json:"guard_level"`
+	IconID         int           `json:"icon_id"`
+	IsLighted      int           `json:"is_lighted"`
+	Color          FlexibleColor `json:"medal_color"`
+	ColorBorder    int           `json:"medal_color_border"`
+	ColorEnd       int           `json:"medal_color_end"`
+	ColorStart     int           `json:"medal_color_start"`
+	Level          int           `json:"medal_level"`
+	Name           string        `json:"medal_name"`
+	Special        string        `json:"special"`
+	TargetID       int64         `json:"target_id"`
+}
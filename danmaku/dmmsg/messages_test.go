@@ -0,0 +1,166 @@
+package dmmsg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFixture(t *testing.T, cmd string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", cmd+".json"))
+	if err != nil {
+		t.Fatalf("cannot read fixture: %v", err)
+	}
+	return data
+}
+
+func parseFixture[T any](t *testing.T, cmd string) T {
+	t.Helper()
+	msg, err := ParseMessage[T](readFixture(t, cmd))
+	if err != nil {
+		t.Fatalf("ParseMessage(%v): %v", cmd, err)
+	}
+	return msg
+}
+
+func TestParseSendGift(t *testing.T) {
+	msg := parseFixture[RawSendGiftMessage](t, CmdSendGift)
+	g := msg.Data
+	if msg.Cmd != CmdSendGift ||
+		g.GiftID != 31036 ||
+		g.GiftName != "小花花" ||
+		g.Num != 1 ||
+		g.Price != 100 ||
+		g.TotalCoin != 100 ||
+		!g.IsPaid() ||
+		g.UID != 8346723 ||
+		g.UserName != "观众甲" ||
+		g.Timestamp != 1700000000 ||
+		g.Rnd != "1700000000121200001" ||
+		g.MedalInfo.Name != "小电视" ||
+		g.MedalInfo.Level != 21 ||
+		g.MedalInfo.Color != 1725515 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseComboSend(t *testing.T) {
+	msg := parseFixture[RawComboSendMessage](t, CmdComboSend)
+	c := msg.Data
+	if c.GiftID != 31036 ||
+		c.TotalNum != 10 ||
+		c.ComboTotalCoin != 1000 ||
+		c.ReceiverUID != 2920960 ||
+		c.UID != 8346723 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseSuperChat(t *testing.T) {
+	msg := parseFixture[RawSuperChatMessage](t, CmdSuperChat)
+	s := msg.Data
+	if s.ID != 8361234 ||
+		s.Message != "晚上好！" ||
+		s.Price != 30 ||
+		s.Time != 60 ||
+		s.StartTime != 1700000000 ||
+		s.EndTime != 1700000060 ||
+		s.UID != 8346723 ||
+		s.UserInfo.UserName != "观众甲" ||
+		s.UserInfo.GuardLevel != 3 ||
+		s.Gift.GiftID != 12000 ||
+		s.MedalInfo.Color != 0x1a544b {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseGuardBuy(t *testing.T) {
+	msg := parseFixture[RawGuardBuyMessage](t, CmdGuardBuy)
+	g := msg.Data
+	if g.UID != 8346723 ||
+		g.UserName != "观众甲" ||
+		g.GuardLevel != GuardCaptain ||
+		g.Num != 1 ||
+		g.Price != 198000 ||
+		g.GiftName != "舰长" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseRoomChange(t *testing.T) {
+	msg := parseFixture[RawRoomChangeMessage](t, CmdRoomChange)
+	r := msg.Data
+	if r.Title != "【歌回】今晚唱歌" ||
+		r.AreaID != 190 ||
+		r.ParentAreaID != 9 ||
+		r.AreaName != "虚拟主播" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParsePreparing(t *testing.T) {
+	// the room id is a string in this message
+	msg := parseFixture[PreparingMessage](t, CmdPreparing)
+	if msg.Cmd != CmdPreparing || msg.RoomId != 21452505 || msg.Round != 0 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseLive(t *testing.T) {
+	msg := parseFixture[LiveMessage](t, CmdLive)
+	if msg.Cmd != CmdLive ||
+		msg.RoomId != 21452505 ||
+		msg.LiveTime != 1700000000 ||
+		msg.LivePlatform != "pc_link" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseRoomBlock(t *testing.T) {
+	msg := parseFixture[RawRoomBlockMessage](t, CmdRoomBlock)
+	if msg.Data.UID != 8346724 || msg.Data.UserName != "观众乙" || msg.Data.Operator != 1 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseWarning(t *testing.T) {
+	warning := parseFixture[WarningMessage](t, CmdWarning)
+	if warning.IsCutOff() ||
+		warning.RoomId != 21452505 ||
+		warning.Message != "违反直播分区规范，请立即更换至游戏区" {
+		t.Fatalf("unexpected message: %+v", warning)
+	}
+	cutOff := parseFixture[WarningMessage](t, CmdCutOff)
+	if !cutOff.IsCutOff() ||
+		cutOff.RoomId != 21452505 ||
+		cutOff.Message != "禁止直播违禁内容" {
+		t.Fatalf("unexpected message: %+v", cutOff)
+	}
+}
+
+func TestParseOnlineRankCount(t *testing.T) {
+	msg := parseFixture[RawOnlineRankCountMessage](t, CmdOnlineRankCount)
+	if msg.Data.Count != 1234 || msg.Data.OnlineCount != 5678 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestParseWatchedChange(t *testing.T) {
+	msg := parseFixture[RawWatchedChangeMessage](t, CmdWatchedChange)
+	if msg.Data.Num != 12345 || msg.Data.TextSmall != "1.2万" || msg.Data.TextLarge != "1.2万人看过" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+func TestFlexibleInt(t *testing.T) {
+	for _, s := range []string{`{"roomid":123}`, `{"roomid":"123"}`} {
+		msg, err := ParseMessage[PreparingMessage]([]byte(s))
+		if err != nil || msg.RoomId != 123 {
+			t.Fatalf("cannot parse %v: %v, %v", s, msg, err)
+		}
+	}
+	if _, err := ParseMessage[PreparingMessage]([]byte(`{"roomid":"abc"}`)); err == nil {
+		t.Fatalf("invalid room id should not be parsed")
+	}
+}
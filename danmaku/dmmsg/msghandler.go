@@ -1,4 +1,191 @@
 package dmmsg
 
+/*
+In this file we implement the message dispatcher.
+A server message is decoded only once, then it is passed to all registered handlers.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/keuin/slbr/danmaku/dmpkg"
+	"strings"
+	"sync"
+)
+
+// DanmakuMessageHandler receives decoded server messages.
+// Handlers are called in the goroutine reading messages from the server,
+// so they should return quickly.
+// Embed BaseHandler to implement only the callbacks you are interested in.
 type DanmakuMessageHandler interface {
+	OnDanMu(msg DanMuMessage)
+	OnInteractWord(msg InteractWordMessage)
+	OnWatchedChange(msg WatchedChangeMessage)
+	OnSendGift(msg SendGiftMessage)
+	OnComboSend(msg ComboSendMessage)
+	OnSuperChat(msg SuperChatMessage)
+	OnGuardBuy(msg GuardBuyMessage)
+	OnRoomChange(msg RoomChangeMessage)
+	OnPreparing(msg PreparingMessage)
+	OnLive(msg LiveMessage)
+	OnRoomBlock(msg RoomBlockMessage)
+	// OnWarning is called with WARNING and CUT_OFF messages.
+	OnWarning(msg WarningMessage)
+	OnOnlineRankCount(msg OnlineRankCountMessage)
+	// OnUnknown is called with messages which are not decoded. body is the raw JSON document.
+	OnUnknown(cmd string, body []byte)
+}
+
+// BaseHandler is a DanmakuMessageHandler which ignores all messages.
+type BaseHandler struct{}
+
+func (BaseHandler) OnDanMu(DanMuMessage)                     {}
+func (BaseHandler) OnInteractWord(InteractWordMessage)       {}
+func (BaseHandler) OnWatchedChange(WatchedChangeMessage)     {}
+func (BaseHandler) OnSendGift(SendGiftMessage)               {}
+func (BaseHandler) OnComboSend(ComboSendMessage)             {}
+func (BaseHandler) OnSuperChat(SuperChatMessage)             {}
+func (BaseHandler) OnGuardBuy(GuardBuyMessage)               {}
+func (BaseHandler) OnRoomChange(RoomChangeMessage)           {}
+func (BaseHandler) OnPreparing(PreparingMessage)             {}
+func (BaseHandler) OnLive(LiveMessage)                       {}
+func (BaseHandler) OnRoomBlock(RoomBlockMessage)             {}
+func (BaseHandler) OnWarning(WarningMessage)                 {}
+func (BaseHandler) OnOnlineRankCount(OnlineRankCountMessage) {}
+func (BaseHandler) OnUnknown(string, []byte)                 {}
+
+// DecodeError means a message with known command cannot be decoded.
+// Other messages are not affected, so it is safe to go on reading.
+type DecodeError struct {
+	Cmd  string
+	Body []byte
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cannot decode message %v: %v", e.Cmd, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Dispatcher decodes server messages and passes them to registered handlers.
+// It is safe to register handlers while dispatching.
+type Dispatcher struct {
+	lock     sync.RWMutex
+	handlers []DanmakuMessageHandler
+}
+
+func NewDispatcher(handlers ...DanmakuMessageHandler) *Dispatcher {
+	return &Dispatcher{
+		handlers: append([]DanmakuMessageHandler(nil), handlers...),
+	}
+}
+
+// Register adds a handler. Handlers are called in the order they are registered.
+func (d *Dispatcher) Register(h DanmakuMessageHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.handlers = append(d.handlers, h)
+}
+
+// NormalizeCommand removes the suffix of a command, e.g. "DANMU_MSG:4:0:2:2:2:0" -> "DANMU_MSG".
+func NormalizeCommand(cmd string) string {
+	if i := strings.IndexByte(cmd, ':'); i >= 0 {
+		return cmd[:i]
+	}
+	return cmd
+}
+
+// Dispatch decodes an inflated exchange and passes the message to all handlers.
+// Exchanges which are not OpLayer7Data are ignored.
+// If the command is known but the message cannot be decoded, a *DecodeError is returned.
+func (d *Dispatcher) Dispatch(ex dmpkg.DanmakuExchange) error {
+	if ex.Operation != dmpkg.OpLayer7Data {
+		return nil
+	}
+	var header struct {
+		Cmd string `json:"cmd"`
+	}
+	if err := json.Unmarshal(ex.Body, &header); err != nil {
+		return fmt.Errorf("%s: %w", InvalidMessageJson, err)
+	}
+	cmd := NormalizeCommand(header.Cmd)
+	notify, err := decodeMessage(cmd, ex.Body)
+	if err != nil {
+		return &DecodeError{Cmd: cmd, Body: ex.Body, Err: err}
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, h := range d.handlers {
+		notify(h)
+	}
+	return nil
+}
+
+// decodeMessage decodes the message body,
+// returns a function which passes the decoded message to a handler.
+func decodeMessage(cmd string, body []byte) (func(h DanmakuMessageHandler), error) {
+	switch cmd {
+	case CmdDanMu:
+		raw, err := ParseMessage[RawDanMuMessage](body)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := ParseDanmakuMessage(raw)
+		if err != nil {
+			return nil, err
+		}
+		return func(h DanmakuMessageHandler) { h.OnDanMu(msg) }, nil
+	case CmdInteractWord:
+		return decodeData[InteractWordMessage](body, DanmakuMessageHandler.OnInteractWord)
+	case CmdWatchedChange:
+		return decodeData[WatchedChangeMessage](body, DanmakuMessageHandler.OnWatchedChange)
+	case CmdSendGift:
+		return decodeData[SendGiftMessage](body, DanmakuMessageHandler.OnSendGift)
+	case CmdComboSend:
+		return decodeData[ComboSendMessage](body, DanmakuMessageHandler.OnComboSend)
+	case CmdSuperChat:
+		return decodeData[SuperChatMessage](body, DanmakuMessageHandler.OnSuperChat)
+	case CmdGuardBuy:
+		return decodeData[GuardBuyMessage](body, DanmakuMessageHandler.OnGuardBuy)
+	case CmdRoomChange:
+		return decodeData[RoomChangeMessage](body, DanmakuMessageHandler.OnRoomChange)
+	case CmdRoomBlock:
+		return decodeData[RoomBlockMessage](body, DanmakuMessageHandler.OnRoomBlock)
+	case CmdOnlineRankCount:
+		return decodeData[OnlineRankCountMessage](body, DanmakuMessageHandler.OnOnlineRankCount)
+	case CmdPreparing:
+		return decodeTopLevel[PreparingMessage](body, DanmakuMessageHandler.OnPreparing)
+	case CmdLive:
+		return decodeTopLevel[LiveMessage](body, DanmakuMessageHandler.OnLive)
+	case CmdWarning, CmdCutOff:
+		return decodeTopLevel[WarningMessage](body, DanmakuMessageHandler.OnWarning)
+	}
+	return func(h DanmakuMessageHandler) { h.OnUnknown(cmd, body) }, nil
+}
+
+// decodeData decodes a message whose payload is in the `data` field.
+func decodeData[D any](
+	body []byte,
+	callback func(DanmakuMessageHandler, D),
+) (func(h DanmakuMessageHandler), error) {
+	raw, err := ParseMessage[BaseRawMessage[interface{}, D]](body)
+	if err != nil {
+		return nil, err
+	}
+	return func(h DanmakuMessageHandler) { callback(h, raw.Data) }, nil
+}
+
+// decodeTopLevel decodes a message whose payload is at the top level.
+func decodeTopLevel[T any](
+	body []byte,
+	callback func(DanmakuMessageHandler, T),
+) (func(h DanmakuMessageHandler), error) {
+	msg, err := ParseMessage[T](body)
+	if err != nil {
+		return nil, err
+	}
+	return func(h DanmakuMessageHandler) { callback(h, msg) }, nil
 }
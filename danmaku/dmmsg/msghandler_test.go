@@ -0,0 +1,85 @@
+package dmmsg
+
+import (
+	"errors"
+	"github.com/keuin/slbr/danmaku/dmpkg"
+	"strings"
+	"testing"
+)
+
+type testHandler struct {
+	BaseHandler
+	danmu    []DanMuMessage
+	gifts    []SendGiftMessage
+	live     []LiveMessage
+	warnings []WarningMessage
+	unknown  []string
+}
+
+func (h *testHandler) OnDanMu(msg DanMuMessage)       { h.danmu = append(h.danmu, msg) }
+func (h *testHandler) OnSendGift(msg SendGiftMessage) { h.gifts = append(h.gifts, msg) }
+func (h *testHandler) OnLive(msg LiveMessage)         { h.live = append(h.live, msg) }
+func (h *testHandler) OnWarning(msg WarningMessage)   { h.warnings = append(h.warnings, msg) }
+func (h *testHandler) OnUnknown(cmd string, _ []byte) { h.unknown = append(h.unknown, cmd) }
+
+func newTestExchange(t *testing.T, op dmpkg.Operation, body string) dmpkg.DanmakuExchange {
+	t.Helper()
+	ex, err := dmpkg.NewPlainExchange(op, body)
+	if err != nil {
+		t.Fatalf("NewPlainExchange: %v", err)
+	}
+	return ex
+}
+
+func TestDispatcher(t *testing.T) {
+	h1 := &testHandler{}
+	h2 := &testHandler{}
+	d := NewDispatcher(h1)
+	d.Register(h2)
+
+	bodies := []string{
+		strings.Replace(danmuMsgJson, `"cmd":"DANMU_MSG"`, `"cmd":"DANMU_MSG:4:0:2:2:2:0"`, 1),
+		string(readFixture(t, CmdSendGift)),
+		string(readFixture(t, CmdLive)),
+		string(readFixture(t, CmdCutOff)),
+		`{"cmd":"ENTRY_EFFECT","data":{}}`,
+	}
+	for _, body := range bodies {
+		if err := d.Dispatch(newTestExchange(t, dmpkg.OpLayer7Data, body)); err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+	}
+	// exchanges other than OpLayer7Data are ignored
+	if err := d.Dispatch(newTestExchange(t, dmpkg.OpHeartbeatAck, "\x00\x00\x00\x01")); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	for _, h := range []*testHandler{h1, h2} {
+		if len(h.danmu) != 1 || h.danmu[0].Content != "hello 你好" ||
+			len(h.gifts) != 1 || h.gifts[0].GiftID != 31036 ||
+			len(h.live) != 1 || h.live[0].RoomId != 21452505 ||
+			len(h.warnings) != 1 || !h.warnings[0].IsCutOff() ||
+			len(h.unknown) != 1 || h.unknown[0] != "ENTRY_EFFECT" {
+			t.Fatalf("unexpected handler state: %+v", h)
+		}
+	}
+}
+
+func TestDispatcherErrors(t *testing.T) {
+	h := &testHandler{}
+	d := NewDispatcher(h)
+
+	err := d.Dispatch(newTestExchange(t, dmpkg.OpLayer7Data, `not a json`))
+	var decodeErr *DecodeError
+	if err == nil || errors.As(err, &decodeErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = d.Dispatch(newTestExchange(t, dmpkg.OpLayer7Data, `{"cmd":"SEND_GIFT","data":{"num":"x"}}`))
+	if !errors.As(err, &decodeErr) || decodeErr.Cmd != CmdSendGift {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(h.gifts) != 0 {
+		t.Fatalf("handler should not be called with broken messages")
+	}
+}
@@ -0,0 +1,81 @@
+package dmmsg
+
+/*
+Decoders of live room status and moderation messages.
+Some of these messages put their payload at the top level instead of in `data`.
+*/
+
+// RoomChangeMessage is sent when the title or the area of the room is changed.
+type RoomChangeMessage struct {
+	Title          string `json:"title"`
+	AreaID         int    `json:"area_id"`
+	ParentAreaID   int    `json:"parent_area_id"`
+	AreaName       string `json:"area_name"`
+	ParentAreaName string `json:"parent_area_name"`
+	LiveKey        string `json:"live_key"`
+	SubSessionKey  string `json:"sub_session_key"`
+}
+
+type RawRoomChangeMessage = BaseRawMessage[interface{}, RoomChangeMessage]
+
+// PreparingMessage is sent when the live is ended.
+type PreparingMessage struct {
+	Cmd    string      `json:"cmd"`
+	RoomId FlexibleInt `json:"roomid"`
+	// Round is non-zero if the room starts playing rounds (replays) after the live is ended
+	Round int `json:"round"`
+}
+
+// LiveMessage is sent when the live is started. The server may send it more than once.
+type LiveMessage struct {
+	Cmd           string      `json:"cmd"`
+	RoomId        FlexibleInt `json:"roomid"`
+	LiveKey       string      `json:"live_key"`
+	SubSessionKey string      `json:"sub_session_key"`
+	LivePlatform  string      `json:"live_platform"`
+	LiveModel     int         `json:"live_model"`
+	// LiveTime is the unix timestamp when the live is started, it is absent in some messages
+	LiveTime int64 `json:"live_time"`
+}
+
+// RoomBlockMessage is sent when a user is blocked in the room.
+type RoomBlockMessage struct {
+	DanMuScore int   `json:"dmscore"`
+	Operator   int   `json:"operator"`
+	UID        int64 `json:"uid"`
+	// UserName may be masked for guests
+	UserName string `json:"uname"`
+}
+
+type RawRoomBlockMessage = BaseRawMessage[interface{}, RoomBlockMessage]
+
+// WarningMessage is a WARNING or CUT_OFF message sent by the site administrator.
+// CUT_OFF means the live is cut off by the administrator.
+type WarningMessage struct {
+	Cmd     string      `json:"cmd"`
+	Message string      `json:"msg"`
+	RoomId  FlexibleInt `json:"roomid"`
+}
+
+// IsCutOff reports if the live is cut off.
+func (w WarningMessage) IsCutOff() bool {
+	return w.Cmd == CmdCutOff
+}
+
+type OnlineRankCountMessage struct {
+	Count           int    `json:"count"`
+	CountText       string `json:"count_text"`
+	OnlineCount     int    `json:"online_count"`
+	OnlineCountText string `json:"online_count_text"`
+}
+
+type RawOnlineRankCountMessage = BaseRawMessage[interface{}, OnlineRankCountMessage]
+
+// WatchedChangeMessage is sent when the number of users who have watched the live is changed.
+type WatchedChangeMessage struct {
+	Num       int    `json:"num"`
+	TextSmall string `json:"text_small"`
+	TextLarge string `json:"text_large"`
+}
+
+type RawWatchedChangeMessage = BaseRawMessage[interface{}, WatchedChangeMessage]
@@ -0,0 +1,35 @@
+package dmmsg
+
+import "fmt"
+
+// SuperChatMessage is a paid message pinned in the chat for a while.
+type SuperChatMessage struct {
+	ID        int64     `json:"id"`
+	Message   string    `json:"message"`
+	MedalInfo MedalInfo `json:"medal_info"`
+	// Price is in CNY
+	Price     int   `json:"price"`
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// Time is how long the message is pinned, in seconds
+	Time     int   `json:"time"`
+	UID      int64 `json:"uid"`
+	UserInfo struct {
+		Face       string `json:"face"`
+		GuardLevel int    `json:"guard_level"`
+		UserName   string `json:"uname"`
+		UserLevel  int    `json:"user_level"`
+	} `json:"user_info"`
+	Gift struct {
+		GiftID   int    `json:"gift_id"`
+		GiftName string `json:"gift_name"`
+		Num      int    `json:"num"`
+	} `json:"gift"`
+}
+
+type RawSuperChatMessage = BaseRawMessage[interface{}, SuperChatMessage]
+
+func (s SuperChatMessage) String() string {
+	return fmt.Sprintf("(user: %v, uid: %v, price: %v CNY) %v",
+		s.UserInfo.UserName, s.UID, s.Price, s.Message)
+}
@@ -1,8 +1,11 @@
 package dmmsg
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 func castValue[T any](obj interface{}) (thing T, err error) {
@@ -15,3 +18,50 @@ func castValue[T any](obj interface{}) (thing T, err error) {
 	thing = casted
 	return
 }
+
+// FlexibleInt is an integer which may be encoded as a JSON number or a JSON string.
+// The server is not consistent in the types of some fields, such as room id.
+type FlexibleInt int64
+
+func (i *FlexibleInt) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s == "" {
+			*i = 0
+			return nil
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer string: %w", err)
+		}
+		*i = FlexibleInt(v)
+		return nil
+	}
+	var v int64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*i = FlexibleInt(v)
+	return nil
+}
+
+// FlexibleColor is a 0xRRGGBB color which may be encoded as a JSON number or a "#RRGGBB" string.
+type FlexibleColor int
+
+func (c *FlexibleColor) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+		if err != nil {
+			return fmt.Errorf("invalid color string: %w", err)
+		}
+		*c = FlexibleColor(v)
+		return nil
+	}
+	var v int
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = FlexibleColor(v)
+	return nil
+}
@@ -0,0 +1,92 @@
+package dmmsg
+
+/*
+Exporter of the Bilibili danmaku XML format.
+Each danmaku is written as `<d p="offset,mode,size,color,timestamp,pool,user hash,id">content</d>`,
+which is understood by most danmaku players and converters.
+*/
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<i>
+<chatserver>chat.bilibili.com</chatserver>
+<chatid>0</chatid>
+<mission>0</mission>
+<maxlimit>0</maxlimit>
+<state>0</state>
+<real_name>0</real_name>
+<source>k-v</source>
+`
+
+const xmlFooter = "</i>\n"
+
+// XmlWriter writes danmaku messages as a Bilibili danmaku XML document.
+// Close must be called to finish the document.
+type XmlWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func NewXmlWriter(w io.Writer) (*XmlWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(xmlHeader); err != nil {
+		return nil, err
+	}
+	return &XmlWriter{w: bw}, nil
+}
+
+// Write appends a danmaku which is shown at the given offset from the start of the video.
+func (x *XmlWriter) Write(offset time.Duration, dm DanMuMessage) error {
+	x.count++
+	_, err := fmt.Fprintf(x.w, `<d p="%.3f,%d,%d,%d,%d,0,`,
+		offset.Seconds(),
+		dm.mode(),
+		dm.fontSize(),
+		dm.Color,
+		dm.Timestamp/1000,
+	)
+	if err != nil {
+		return err
+	}
+	if err := xml.EscapeText(x.w, []byte(dm.SourceUser.Hash)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(x.w, `,%d">`, x.count); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(x.w, []byte(dm.Content)); err != nil {
+		return err
+	}
+	_, err = x.w.WriteString("</d>\n")
+	return err
+}
+
+// Close finishes the document and flushes buffered data. The underlying writer is not closed.
+func (x *XmlWriter) Close() error {
+	if _, err := x.w.WriteString(xmlFooter); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
+
+func (dm DanMuMessage) mode() DanMuMode {
+	switch dm.Mode {
+	case ModeBottom, ModeTop:
+		return dm.Mode
+	}
+	return ModeScroll
+}
+
+func (dm DanMuMessage) fontSize() int {
+	if dm.FontSize <= 0 {
+		return DefaultFontSize
+	}
+	return dm.FontSize
+}
@@ -0,0 +1,56 @@
+package dmmsg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXmlWriter(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := NewXmlWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewXmlWriter: %v", err)
+	}
+	var dm DanMuMessage
+	dm.Content = `<b>"a" & 'b'</b>`
+	dm.Mode = ModeBottom
+	dm.FontSize = 18
+	dm.Color = 0x00ff00
+	dm.Timestamp = 1700000000123
+	dm.SourceUser.Hash = "e3a5f1c2"
+	if err := x.Write(1500*time.Millisecond, dm); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// fields not set by old sidecar files should fall back to defaults
+	if err := x.Write(time.Hour, DanMuMessage{Content: "plain"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var doc struct {
+		D []struct {
+			P    string `xml:"p,attr"`
+			Text string `xml:",chardata"`
+		} `xml:"d"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("output is not a valid XML document: %v\n%v", err, buf.String())
+	}
+	if len(doc.D) != 2 {
+		t.Fatalf("unexpected danmaku count: %v", len(doc.D))
+	}
+	if d := doc.D[0]; d.P != "1.500,4,18,65280,1700000000,0,e3a5f1c2,1" || d.Text != dm.Content {
+		t.Fatalf("unexpected danmaku: %+v", d)
+	}
+	if d := doc.D[1]; d.P != "3600.000,1,25,0,0,0,,2" || d.Text != "plain" {
+		t.Fatalf("unexpected danmaku: %+v", d)
+	}
+	if !strings.HasSuffix(buf.String(), "</i>\n") {
+		t.Fatalf("document is not closed")
+	}
+}
@@ -22,9 +22,10 @@ type authInfo struct {
 }
 
 // NewAuth creates a new authentication exchange.
-func NewAuth(protocol ProtocolVer, roomId types.RoomId, authKey, buvid3 string) (exc DanmakuExchange) {
+// uid is the logged-in user of the session which authKey is got from, or UidGuest.
+func NewAuth(protocol ProtocolVer, roomId types.RoomId, uid uint64, authKey, buvid3 string) (exc DanmakuExchange) {
 	exc, _ = NewPlainExchange(OpConnect, authInfo{
-		UID:      UidGuest,
+		UID:      uid,
 		RoomId:   roomId,
 		ProtoVer: int(protocol),
 		BUVID3:   buvid3,
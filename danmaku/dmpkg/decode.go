@@ -28,6 +28,11 @@ func DecodeExchange(data []byte) (exc DanmakuExchange, err error) {
 		return
 	}
 
+	if ln := len(data); uint32(ln) < exchangeHeader.Length || exchangeHeader.Length < HeaderLength {
+		err = fmt.Errorf("incomplete datagram: length = %v, expected %v", ln, exchangeHeader.Length)
+		return
+	}
+
 	// special process
 	// TODO decouple this
 	// The server OpHeartbeatAck contains an extra 4-bytes header entry in the body, maybe a heat value
@@ -74,7 +74,48 @@ func (e *DanmakuExchange) Marshal() (data []byte, err error) {
 	return
 }
 
-// Inflate decompresses the body if it is compressed
+// InflateAll decompresses the body if it is compressed.
+// A compressed body may contain multiple exchanges, all of them are returned.
+func (e *DanmakuExchange) InflateAll() (ret []DanmakuExchange, err error) {
+	var data []byte
+	switch e.ProtocolVer {
+	case ProtoBrotli:
+		data, err = io.ReadAll(brotli.NewReader(bytes.NewReader(e.Body)))
+	case ProtoZlib:
+		var rd io.ReadCloser
+		rd, err = zlib.NewReader(bytes.NewReader(e.Body))
+		if err != nil {
+			err = fmt.Errorf("cannot create zlib reader: %w", err)
+			return
+		}
+		data, err = io.ReadAll(rd)
+	default:
+		return []DanmakuExchange{*e}, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot decompress exchange body: %w", err)
+		return
+	}
+	for len(data) > 0 {
+		var nestedExchange DanmakuExchange
+		nestedExchange, err = DecodeExchange(data)
+		if err != nil {
+			err = fmt.Errorf("cannot decode nested exchange: %w", err)
+			return
+		}
+		data = data[nestedExchange.Length:]
+		var inflated []DanmakuExchange
+		inflated, err = nestedExchange.InflateAll()
+		if err != nil {
+			return
+		}
+		ret = append(ret, inflated...)
+	}
+	return
+}
+
+// Inflate decompresses the body if it is compressed.
+// If the body contains multiple exchanges, only the first one is returned.
 func (e *DanmakuExchange) Inflate() (ret DanmakuExchange, err error) {
 	switch e.ProtocolVer {
 	case ProtoMinimal:
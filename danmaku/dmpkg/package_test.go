@@ -0,0 +1,94 @@
+package dmpkg
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"github.com/andybalholm/brotli"
+	"io"
+	"testing"
+)
+
+func compressedExchange(t *testing.T, proto ProtocolVer, bodies ...string) DanmakuExchange {
+	t.Helper()
+	var raw []byte
+	for _, body := range bodies {
+		ex, err := NewPlainExchange(OpLayer7Data, body)
+		if err != nil {
+			t.Fatalf("NewPlainExchange: %v", err)
+		}
+		data, err := ex.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		raw = append(raw, data...)
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch proto {
+	case ProtoZlib:
+		w = zlib.NewWriter(&buf)
+	case ProtoBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unsupported protocol: %v", proto)
+	}
+	_, _ = w.Write(raw)
+	_ = w.Close()
+	ex, err := NewPlainExchange(OpLayer7Data, buf.String())
+	if err != nil {
+		t.Fatalf("NewPlainExchange: %v", err)
+	}
+	ex.ProtocolVer = proto
+	return ex
+}
+
+func TestDanmakuExchange_InflateAll(t *testing.T) {
+	bodies := []string{`{"cmd":"A"}`, `{"cmd":"B"}`, `{"cmd":"C"}`}
+	for _, proto := range []ProtocolVer{ProtoZlib, ProtoBrotli} {
+		ex := compressedExchange(t, proto, bodies...)
+		// pass through the wire format
+		data, err := ex.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		ex, err = DecodeExchange(data)
+		if err != nil {
+			t.Fatalf("DecodeExchange: %v", err)
+		}
+		exchanges, err := ex.InflateAll()
+		if err != nil {
+			t.Fatalf("InflateAll: %v", err)
+		}
+		if len(exchanges) != len(bodies) {
+			t.Fatalf("unexpected exchange count: %v", len(exchanges))
+		}
+		for i := range bodies {
+			if string(exchanges[i].Body) != bodies[i] || exchanges[i].Operation != OpLayer7Data {
+				t.Fatalf("unexpected exchange: %v", exchanges[i].PrettyString())
+			}
+		}
+	}
+}
+
+func TestDecodeExchangeIncomplete(t *testing.T) {
+	ex, _ := NewPlainExchange(OpLayer7Data, `{"cmd":"A"}`)
+	data, _ := ex.Marshal()
+	if _, err := DecodeExchange(data[:len(data)-1]); err == nil {
+		t.Fatalf("incomplete exchange should not be decoded")
+	}
+}
+
+func TestNewAuth(t *testing.T) {
+	ex := NewAuth(ProtoBrotli, 1234, 5678, "key", "buvid")
+	if ex.Operation != OpConnect {
+		t.Fatalf("unexpected operation: %v", ex.Operation)
+	}
+	var info authInfo
+	if err := json.Unmarshal(ex.Body, &info); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if info.UID != 5678 || info.RoomId != 1234 || info.Key != "key" || info.BUVID3 != "buvid" {
+		t.Fatalf("unexpected auth info: %+v", info)
+	}
+}
@@ -0,0 +1,257 @@
+package flv
+
+/*
+In this file we implement a minimal AMF0 codec, which is used to read and write script data (onMetaData).
+See Action Message Format -- AMF 0.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfEcmaArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// AmfProperty is a property of AMF objects and ECMA arrays.
+type AmfProperty struct {
+	Name  string
+	Value interface{}
+}
+
+// AmfObject is an anonymous object. Properties are kept in order.
+type AmfObject []AmfProperty
+
+// AmfEcmaArray is an associative array, which is usually used as the value of onMetaData.
+type AmfEcmaArray []AmfProperty
+
+// AmfLongString is a string which is always encoded as long string, so its header size is fixed.
+type AmfLongString string
+
+// AmfDate is a date value, in milliseconds since epoch.
+type AmfDate struct {
+	Millis   float64
+	TimeZone int16
+}
+
+// Supported Go types of values: float64, bool, string, AmfLongString, AmfObject, AmfEcmaArray,
+// []interface{} (strict array), AmfDate, and nil (null).
+
+func encodeAmf(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case float64:
+		buf.WriteByte(amfNumber)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case bool:
+		buf.WriteByte(amfBoolean)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		if len(v) > math.MaxUint16 {
+			return encodeAmf(buf, AmfLongString(v))
+		}
+		buf.WriteByte(amfString)
+		encodeAmfKey(buf, v)
+	case AmfLongString:
+		buf.WriteByte(amfLongString)
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		buf.WriteString(string(v))
+	case AmfObject:
+		buf.WriteByte(amfObject)
+		return encodeAmfProperties(buf, v)
+	case AmfEcmaArray:
+		buf.WriteByte(amfEcmaArray)
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		return encodeAmfProperties(buf, v)
+	case []interface{}:
+		buf.WriteByte(amfStrictArray)
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		for _, e := range v {
+			if err := encodeAmf(buf, e); err != nil {
+				return err
+			}
+		}
+	case AmfDate:
+		buf.WriteByte(amfDate)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v.Millis))
+		_ = binary.Write(buf, binary.BigEndian, v.TimeZone)
+	case nil:
+		buf.WriteByte(amfNull)
+	default:
+		return fmt.Errorf("unsupported AMF value type: %T", v)
+	}
+	return nil
+}
+
+func encodeAmfKey(buf *bytes.Buffer, s string) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+func encodeAmfProperties(buf *bytes.Buffer, props []AmfProperty) error {
+	for _, p := range props {
+		encodeAmfKey(buf, p.Name)
+		if err := encodeAmf(buf, p.Value); err != nil {
+			return err
+		}
+	}
+	buf.Write([]byte{0, 0, amfObjectEnd})
+	return nil
+}
+
+// amfDecoder decodes AMF values from a byte slice.
+type amfDecoder struct {
+	b []byte
+}
+
+var errAmfTruncated = fmt.Errorf("truncated AMF data")
+
+func (d *amfDecoder) next(n int) ([]byte, error) {
+	if len(d.b) < n {
+		return nil, errAmfTruncated
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *amfDecoder) decode() (interface{}, error) {
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch t[0] {
+	case amfNumber:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case amfBoolean:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case amfString:
+		return d.decodeKey()
+	case amfLongString:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(binary.BigEndian.Uint32(b)))
+		return string(s), err
+	case amfObject:
+		props, err := d.decodeProperties()
+		return AmfObject(props), err
+	case amfEcmaArray:
+		// the count is only a hint, properties end with the end marker
+		if _, err := d.next(4); err != nil {
+			return nil, err
+		}
+		props, err := d.decodeProperties()
+		return AmfEcmaArray(props), err
+	case amfStrictArray:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(b)
+		if int(n) > len(d.b) {
+			return nil, errAmfTruncated
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			arr[i], err = d.decode()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case amfDate:
+		b, err := d.next(10)
+		if err != nil {
+			return nil, err
+		}
+		return AmfDate{
+			Millis:   math.Float64frombits(binary.BigEndian.Uint64(b)),
+			TimeZone: int16(binary.BigEndian.Uint16(b[8:])),
+		}, nil
+	case amfNull, amfUndefined:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported AMF type marker: %v", t[0])
+	}
+}
+
+func (d *amfDecoder) decodeKey() (string, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return "", err
+	}
+	s, err := d.next(int(binary.BigEndian.Uint16(b)))
+	return string(s), err
+}
+
+func (d *amfDecoder) decodeProperties() ([]AmfProperty, error) {
+	var props []AmfProperty
+	for {
+		name, err := d.decodeKey()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" && len(d.b) > 0 && d.b[0] == amfObjectEnd {
+			d.b = d.b[1:]
+			return props, nil
+		}
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, AmfProperty{Name: name, Value: value})
+	}
+}
+
+// DecodeScriptData decodes the name and the value of a script tag, e.g. "onMetaData" and an ECMA array.
+func DecodeScriptData(data []byte) (name string, value interface{}, err error) {
+	d := amfDecoder{b: data}
+	v, err := d.decode()
+	if err != nil {
+		return "", nil, err
+	}
+	name, ok := v.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid script data name: %v", v)
+	}
+	value, err = d.decode()
+	return name, value, err
+}
+
+// EncodeScriptData encodes the name and the value of a script tag.
+func EncodeScriptData(name string, value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeAmf(&buf, name); err != nil {
+		return nil, err
+	}
+	if err := encodeAmf(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,162 @@
+/*
+Package flv implements a lossless remuxer of FLV live streams.
+Tags are never decoded or re-encoded, only timestamps and stream headers are rewritten.
+See Adobe Flash Video File Format Specification Version 10.1 and Enhanced RTMP.
+*/
+package flv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// HeaderSize is the size of FLV header, not including the first PreviousTagSize
+	HeaderSize = 9
+	// TagHeaderSize is the size of tag header before the tag data
+	TagHeaderSize = 11
+	// prevTagSizeLen is the size of PreviousTagSize after every tag
+	prevTagSizeLen = 4
+)
+
+type TagType uint8
+
+const (
+	TagAudio  TagType = 8
+	TagVideo  TagType = 9
+	TagScript TagType = 18
+)
+
+const (
+	// FlagAudio and FlagVideo are type flags in FLV header
+	FlagAudio = 0x04
+	FlagVideo = 0x01
+)
+
+const (
+	soundFormatAac      = 10
+	soundFormatExHeader = 9
+	videoCodecAvc       = 7
+	videoCodecHevc      = 12
+	// videoExHeaderBit marks an Enhanced RTMP video tag, the low 4 bits are the packet type
+	videoExHeaderBit = 0x80
+	// packetTypeSequenceStart is the packet type of sequence headers, in both legacy and enhanced tags
+	packetTypeSequenceStart = 0
+	frameTypeKeyframe       = 1
+)
+
+// Tag is an FLV tag.
+type Tag struct {
+	// Type: the first byte of tag header, including the filter bit
+	Type TagType
+	// Timestamp in milliseconds, including the extended byte
+	Timestamp uint32
+	StreamId  uint32
+	Data      []byte
+}
+
+// Kind returns the tag type without the filter bit.
+func (t Tag) Kind() TagType {
+	return t.Type & 0x1f
+}
+
+// IsSequenceHeader reports if this tag is an AAC or AVC/HEVC sequence header,
+// which contains codec parameters and must be present before media data.
+func (t Tag) IsSequenceHeader() bool {
+	if len(t.Data) < 2 {
+		return false
+	}
+	switch t.Kind() {
+	case TagAudio:
+		switch t.Data[0] >> 4 {
+		case soundFormatAac:
+			return t.Data[1] == packetTypeSequenceStart
+		case soundFormatExHeader:
+			return t.Data[0]&0x0f == packetTypeSequenceStart
+		}
+	case TagVideo:
+		if t.Data[0]&videoExHeaderBit != 0 {
+			return t.Data[0]&0x0f == packetTypeSequenceStart
+		}
+		codec := t.Data[0] & 0x0f
+		return (codec == videoCodecAvc || codec == videoCodecHevc) && t.Data[1] == packetTypeSequenceStart
+	}
+	return false
+}
+
+// IsKeyframe reports if this tag is a video keyframe.
+func (t Tag) IsKeyframe() bool {
+	return t.Kind() == TagVideo && len(t.Data) > 0 && (t.Data[0]>>4)&0x07 == frameTypeKeyframe
+}
+
+// Size returns how many bytes this tag takes in a file, including PreviousTagSize.
+func (t Tag) Size() int {
+	return TagHeaderSize + len(t.Data) + prevTagSizeLen
+}
+
+// WriteTo writes the tag and its PreviousTagSize.
+func (t Tag) WriteTo(w io.Writer) (int64, error) {
+	b := make([]byte, 0, t.Size())
+	b = append(b, byte(t.Type))
+	b = appendUint24(b, uint32(len(t.Data)))
+	b = appendUint24(b, t.Timestamp&0xffffff)
+	b = append(b, byte(t.Timestamp>>24))
+	b = appendUint24(b, t.StreamId)
+	b = append(b, t.Data...)
+	b = binary.BigEndian.AppendUint32(b, uint32(TagHeaderSize+len(t.Data)))
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// clone returns a copy of the tag which does not share data with the original one.
+func (t Tag) clone() *Tag {
+	t.Data = append([]byte(nil), t.Data...)
+	return &t
+}
+
+// parseTag parses a tag and the following PreviousTagSize.
+// n is the number of consumed bytes. If b is incomplete, ok is false.
+// The returned tag shares data with b.
+func parseTag(b []byte) (tag Tag, n int, ok bool) {
+	if len(b) < TagHeaderSize {
+		return
+	}
+	size := int(readUint24(b[1:]))
+	n = TagHeaderSize + size + prevTagSizeLen
+	if len(b) < n {
+		return Tag{}, 0, false
+	}
+	tag.Type = TagType(b[0])
+	tag.Timestamp = readUint24(b[4:]) | uint32(b[7])<<24
+	tag.StreamId = readUint24(b[8:])
+	tag.Data = b[TagHeaderSize : TagHeaderSize+size]
+	return tag, n, true
+}
+
+// parseHeader parses FLV header. n is the size of the header and the first PreviousTagSize.
+func parseHeader(b []byte) (flags byte, n int, err error) {
+	if b[0] != 'F' || b[1] != 'L' || b[2] != 'V' {
+		return 0, 0, fmt.Errorf("invalid FLV signature: %q", b[:3])
+	}
+	offset := binary.BigEndian.Uint32(b[5:])
+	if offset < HeaderSize {
+		return 0, 0, fmt.Errorf("invalid FLV header size: %v", offset)
+	}
+	return b[4], int(offset) + prevTagSizeLen, nil
+}
+
+// writeHeader writes FLV header and the first PreviousTagSize.
+func writeHeader(w io.Writer, flags byte) error {
+	b := []byte{'F', 'L', 'V', 1, flags, 0, 0, 0, HeaderSize, 0, 0, 0, 0}
+	_, err := w.Write(b)
+	return err
+}
+
+func readUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
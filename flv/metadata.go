@@ -0,0 +1,125 @@
+package flv
+
+/*
+In this file we build onMetaData with duration, file size and a keyframe index.
+Live streams do not have such information, so players cannot seek in recorded files.
+The metadata is written with reserved space when the file is created, and overwritten in place
+when the file is finished, so the file does not need to be copied.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	metadataName = "onMetaData"
+	// keyframeIndexCapacity: how many keyframes can be saved in the index, 18 bytes are reserved for each.
+	// If there are more keyframes, some of them are skipped, so seeking becomes less precise.
+	keyframeIndexCapacity = 8192
+	// keyframeEntrySize: size of a time and a file position in strict arrays
+	keyframeEntrySize = 18
+)
+
+// keyframe is an entry of the keyframe index.
+type keyframe struct {
+	// timestamp in milliseconds
+	timestamp int64
+	// position of the tag in the file
+	pos int64
+}
+
+// generatedMetadataKeys are replaced if they are present in the stream metadata
+var generatedMetadataKeys = map[string]bool{
+	"duration":              true,
+	"filesize":              true,
+	"lasttimestamp":         true,
+	"lastkeyframetimestamp": true,
+	"lastkeyframelocation":  true,
+	"hasKeyframes":          true,
+	"keyframes":             true,
+	"spacer":                true,
+}
+
+// isMetadata reports if the tag is onMetaData.
+func isMetadata(tag Tag) bool {
+	if tag.Kind() != TagScript {
+		return false
+	}
+	d := amfDecoder{b: tag.Data}
+	name, err := d.decode()
+	return err == nil && name == metadataName
+}
+
+// streamMetadata returns properties of the stream metadata, which are kept in recorded files.
+// Invalid metadata is ignored.
+func streamMetadata(tag *Tag) AmfEcmaArray {
+	if tag == nil {
+		return nil
+	}
+	_, value, err := DecodeScriptData(tag.Data)
+	if err != nil {
+		return nil
+	}
+	var props []AmfProperty
+	switch v := value.(type) {
+	case AmfEcmaArray:
+		props = v
+	case AmfObject:
+		props = v
+	}
+	var kept AmfEcmaArray
+	for _, p := range props {
+		if !generatedMetadataKeys[p.Name] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// buildMetadata encodes onMetaData with the index. The size is the same for any count of keyframes,
+// since the unused space is filled by a spacer. Keyframes are skipped if there are too many.
+func buildMetadata(stream AmfEcmaArray, duration int64, fileSize int64, keyframes []keyframe) ([]byte, error) {
+	keyframes = thinKeyframes(keyframes, keyframeIndexCapacity)
+	times := make([]interface{}, len(keyframes))
+	positions := make([]interface{}, len(keyframes))
+	var lastKeyframe keyframe
+	for i, k := range keyframes {
+		times[i] = float64(k.timestamp) / 1000
+		positions[i] = float64(k.pos)
+		lastKeyframe = k
+	}
+	props := append(AmfEcmaArray{}, stream...)
+	props = append(props,
+		AmfProperty{"duration", float64(duration) / 1000},
+		AmfProperty{"filesize", float64(fileSize)},
+		AmfProperty{"lasttimestamp", float64(duration) / 1000},
+		AmfProperty{"lastkeyframetimestamp", float64(lastKeyframe.timestamp) / 1000},
+		AmfProperty{"lastkeyframelocation", float64(lastKeyframe.pos)},
+		AmfProperty{"hasKeyframes", len(keyframes) > 0},
+		AmfProperty{"keyframes", AmfObject{
+			{"times", times},
+			{"filepositions", positions},
+		}},
+		AmfProperty{"spacer", AmfLongString(strings.Repeat(
+			"\x00", (keyframeIndexCapacity-len(keyframes))*keyframeEntrySize))},
+	)
+	data, err := EncodeScriptData(metadataName, props)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode metadata: %w", err)
+	}
+	return data, nil
+}
+
+// thinKeyframes returns at most n keyframes, which are evenly picked from all keyframes.
+func thinKeyframes(keyframes []keyframe, n int) []keyframe {
+	if len(keyframes) <= n {
+		return keyframes
+	}
+	step := (len(keyframes) + n - 1) / n
+	thinned := make([]keyframe, 0, n)
+	for i := 0; i < len(keyframes); i += step {
+		thinned = append(thinned, keyframes[i])
+	}
+	return thinned
+}
@@ -0,0 +1,132 @@
+package flv
+
+/*
+In this file we repair FLV files which are not finished, e.g. when the recorder is killed.
+The incomplete tag at the end is removed, and the keyframe index is rebuilt
+if the file has the metadata with reserved space written by Writer.
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotFlv is returned by Repair if the file is not an FLV file.
+var ErrNotFlv = errors.New("not an FLV file")
+
+// RepairResult describes what is done by Repair.
+type RepairResult struct {
+	// Truncated is the number of bytes removed from the end of the file
+	Truncated int64
+	// Indexed reports if the keyframe index is written to the metadata
+	Indexed bool
+}
+
+// Repair truncates the incomplete or corrupted tags at the end of an FLV file,
+// and writes duration, file size and keyframe index to the metadata if possible.
+// An error is returned if f is not an FLV file.
+func Repair(f *os.File) (result RepairResult, err error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return
+	}
+	size := stat.Size()
+	r := bufio.NewReaderSize(io.NewSectionReader(f, 0, size), 64*1024)
+
+	header := make([]byte, HeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrNotFlv, err)
+	}
+	_, n, err := parseHeader(header)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", ErrNotFlv, err)
+	}
+	if _, err = r.Discard(n - HeaderSize); err != nil {
+		return result, fmt.Errorf("%w: %v", ErrNotFlv, err)
+	}
+
+	// end is the end of the last complete tag
+	end := int64(n)
+	var (
+		keyframes    []keyframe
+		lastTs       int64
+		metadata     *Tag
+		metadataPos  int64
+		tagHeader    = make([]byte, TagHeaderSize)
+		prevTagSize  = make([]byte, prevTagSizeLen)
+		firstTagRead bool
+	)
+	for {
+		if _, err := io.ReadFull(r, tagHeader); err != nil {
+			break
+		}
+		tag := Tag{
+			Type:      TagType(tagHeader[0]),
+			Timestamp: readUint24(tagHeader[4:]) | uint32(tagHeader[7])<<24,
+		}
+		dataSize := int(readUint24(tagHeader[1:]))
+		if k := tag.Kind(); k != TagAudio && k != TagVideo && k != TagScript {
+			// corrupted data
+			break
+		}
+		// only the first 2 bytes of media data are needed to find keyframes, the metadata is read entirely
+		readSize := dataSize
+		if (firstTagRead || tag.Kind() != TagScript) && readSize > 2 {
+			readSize = 2
+		}
+		data := make([]byte, readSize)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		if _, err := r.Discard(dataSize - readSize); err != nil {
+			break
+		}
+		if _, err := io.ReadFull(r, prevTagSize); err != nil {
+			break
+		}
+		if binary.BigEndian.Uint32(prevTagSize) != uint32(TagHeaderSize+dataSize) {
+			break
+		}
+		tag.Data = data
+		if !firstTagRead && isMetadata(tag) {
+			metadata = tag.clone()
+			metadataPos = end + TagHeaderSize
+		}
+		firstTagRead = true
+		if tag.IsKeyframe() && !tag.IsSequenceHeader() {
+			keyframes = append(keyframes, keyframe{timestamp: int64(tag.Timestamp), pos: end})
+		}
+		if tag.Kind() != TagScript && int64(tag.Timestamp) > lastTs {
+			lastTs = int64(tag.Timestamp)
+		}
+		end += int64(TagHeaderSize + dataSize + prevTagSizeLen)
+	}
+
+	if end < size {
+		if err = f.Truncate(end); err != nil {
+			return result, fmt.Errorf("cannot truncate file: %w", err)
+		}
+		result.Truncated = size - end
+	}
+
+	if metadata == nil {
+		return result, nil
+	}
+	data, err := buildMetadata(streamMetadata(metadata), lastTs, end, keyframes)
+	if err != nil {
+		return
+	}
+	if len(data) != len(metadata.Data) {
+		// not written by Writer, there is no space for the index
+		return result, nil
+	}
+	if _, err = f.WriteAt(data, metadataPos); err != nil {
+		return result, fmt.Errorf("cannot write keyframe index: %w", err)
+	}
+	result.Indexed = true
+	return result, nil
+}
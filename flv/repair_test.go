@@ -0,0 +1,101 @@
+package flv
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	var f *os.File
+	w := NewWriter(func() (io.Writer, error) {
+		var err error
+		f, err = os.Create(filepath.Join(t.TempDir(), "test.flv"))
+		return f, err
+	})
+	tags := []Tag{testMetadata, testVideoHeader, testAudioHeader}
+	for i := uint32(0); i < 100; i++ {
+		tags = append(tags, videoFrame(5000+i*40, i%25 == 0), audioFrame(5000+i*40+5))
+	}
+	if _, err := w.Write(encodeStream(tags...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// the recorder is killed when writing a tag
+	incomplete := encodeStream(videoFrame(9000, true))[HeaderSize+prevTagSizeLen:]
+	if _, err := f.Write(incomplete[:len(incomplete)-2]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	result, err := Repair(f)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	_ = f.Close()
+	if result.Truncated != int64(len(incomplete)-2) || !result.Indexed {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	decoded := decodeFile(t, b)
+	if len(decoded) != len(tags) {
+		t.Fatalf("expected %v tags, got %v", len(tags), len(decoded))
+	}
+	_, value, err := DecodeScriptData(decoded[0].Data)
+	if err != nil {
+		t.Fatalf("DecodeScriptData: %v", err)
+	}
+	props := make(map[string]interface{})
+	for _, p := range value.(AmfEcmaArray) {
+		props[p.Name] = p.Value
+	}
+	if props["width"] != 1920.0 || props["duration"] != 3.965 || props["filesize"] != float64(len(b)) {
+		t.Fatalf("unexpected metadata: %v", props)
+	}
+	index := props["keyframes"].(AmfObject)
+	positions := index[1].Value.([]interface{})
+	if len(positions) != 4 {
+		t.Fatalf("unexpected keyframe index: %v", index)
+	}
+	for i := range positions {
+		tag, _, ok := parseTag(b[int(positions[i].(float64)):])
+		if !ok || !tag.IsKeyframe() || tag.Timestamp != uint32(i*1000) {
+			t.Fatalf("keyframe position %v points to %+v", positions[i], tag)
+		}
+	}
+}
+
+func TestRepair_RawStream(t *testing.T) {
+	// the metadata of raw streams has no space for the index
+	tags := []Tag{testMetadata, testVideoHeader, videoFrame(0, true), audioFrame(5)}
+	b := encodeStream(tags...)
+	name := filepath.Join(t.TempDir(), "test.flv")
+	if err := os.WriteFile(name, append(b, 0x09, 0x00), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	result, err := Repair(f)
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if result.Truncated != 2 || result.Indexed {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	repaired, _ := os.ReadFile(name)
+	checkTags(t, decodeFile(t, repaired), tags)
+
+	if err := os.WriteFile(name, []byte("not flv"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := Repair(f); !errors.Is(err, ErrNotFlv) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
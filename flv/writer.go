@@ -0,0 +1,299 @@
+package flv
+
+/*
+In this file we implement the normalizing writer.
+The input may be several FLV streams connected one after another (when reconnecting),
+the writer concatenates them into continuous files:
+- timestamps are rebased, so they start from 0 and never jump
+- FLV headers, metadata and sequence headers of later streams are dropped if not changed
+- if codec parameters (sequence headers) are changed, a new file is started
+- if the file reaches the split limit, a new file is started at the next keyframe
+- onMetaData is replaced with the one with a keyframe index, see metadata.go
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// maxTimestampJump: if the timestamp changes more than this (in milliseconds) between two tags,
+	// the stream is considered discontinuous and the timestamps are rebased
+	maxTimestampJump = 3000
+	// rebaseGap is the interval between the last tag and the first tag after rebasing, in milliseconds
+	rebaseGap = 40
+)
+
+// Writer parses FLV streams and writes normalized tags to output files.
+// It is not thread-safe.
+type Writer struct {
+	// newFile opens the next output file. It is called before the first tag is written,
+	// when codec parameters are changed, and when the split limit is reached.
+	// The previous file is not used after that.
+	newFile func() (io.Writer, error)
+	out     io.Writer
+	// buf holds incomplete input
+	buf []byte
+	// headerRead: FLV header of the current input stream is consumed
+	headerRead bool
+	flags      byte
+
+	// the latest metadata and sequence headers, they are written at the beginning of every file
+	metadata    *Tag
+	audioHeader *Tag
+	videoHeader *Tag
+	// data of sequence headers written to the current file, nil if not written
+	writtenAudioHeader []byte
+	writtenVideoHeader []byte
+
+	// pos is the size of the current file
+	pos int64
+	// stream metadata written to the current file
+	fileMetadata AmfEcmaArray
+	// position and size of the metadata tag data in the current file, the size is 0 if there is no index
+	metadataPos  int64
+	metadataSize int
+	keyframes    []keyframe
+
+	// offset is added to input timestamps
+	offset int64
+	// rebase: offset is recalculated on the next media tag, the output timestamp will be rebaseTo
+	rebase   bool
+	rebaseTo int64
+	lastIn   int64
+	lastOut  int64
+
+	// a new file is started if the current one reaches any of the limits, zero means no limit
+	maxDuration int64
+	maxSize     int64
+}
+
+// NewWriter creates a Writer. newFile opens the next output file, it is called before the first tag is written,
+// when codec parameters are changed, and when the split limit is reached. The previous file is not used after that.
+func NewWriter(newFile func() (io.Writer, error)) *Writer {
+	return &Writer{
+		newFile: newFile,
+		rebase:  true,
+	}
+}
+
+// SetSplitLimit makes the writer start a new file at the first keyframe after the current file
+// reaches maxDuration or maxSize bytes. Zero means no limit.
+func (w *Writer) SetSplitLimit(maxDuration time.Duration, maxSize int64) {
+	w.maxDuration = maxDuration.Milliseconds()
+	w.maxSize = maxSize
+}
+
+// Reset prepares for the next input stream, which starts with an FLV header.
+// Incomplete data of the previous stream is dropped. The current output file is kept,
+// timestamps of the next stream continue from the last tag.
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+	w.headerRead = false
+	w.rebase = true
+	w.rebaseTo = w.lastOut + rebaseGap
+}
+
+// Write parses p and writes complete tags to the output file.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	consumed := 0
+	defer func() {
+		// keep the incomplete tag
+		w.buf = w.buf[:copy(w.buf, w.buf[consumed:])]
+	}()
+	if !w.headerRead {
+		if len(w.buf) < HeaderSize {
+			return len(p), nil
+		}
+		flags, n, err := parseHeader(w.buf)
+		if err != nil {
+			return 0, err
+		}
+		if len(w.buf) < n {
+			return len(p), nil
+		}
+		w.flags = flags
+		w.headerRead = true
+		consumed = n
+	}
+	for {
+		tag, n, ok := parseTag(w.buf[consumed:])
+		if !ok {
+			return len(p), nil
+		}
+		if err := w.writeTag(tag); err != nil {
+			return 0, err
+		}
+		consumed += n
+	}
+}
+
+func (w *Writer) writeTag(tag Tag) error {
+	switch {
+	case isMetadata(tag):
+		w.metadata = tag.clone()
+		if w.out == nil {
+			return w.openFile()
+		}
+		// the metadata of the current file is generated, it is only written once
+		return nil
+	case tag.IsSequenceHeader():
+		stored, written := &w.videoHeader, &w.writtenVideoHeader
+		if tag.Kind() == TagAudio {
+			stored, written = &w.audioHeader, &w.writtenAudioHeader
+		}
+		*stored = tag.clone()
+		if w.out == nil {
+			return w.openFile()
+		}
+		if *written == nil {
+			*written = (*stored).Data
+			tag.Timestamp = uint32(w.lastOut)
+			return w.write(tag)
+		}
+		if bytes.Equal(*written, tag.Data) {
+			// duplicated
+			return nil
+		}
+		// codec parameters are changed, players cannot handle that in one file
+		return w.openFile()
+	case tag.Kind() == TagAudio || tag.Kind() == TagVideo:
+		if w.out == nil {
+			if err := w.openFile(); err != nil {
+				return err
+			}
+		}
+		in := tag.Timestamp
+		tag.Timestamp = w.timestamp(in)
+		if tag.IsKeyframe() && w.reachesLimit(int64(tag.Timestamp)) {
+			if err := w.openFile(); err != nil {
+				return err
+			}
+			tag.Timestamp = w.timestamp(in)
+		}
+		if tag.IsKeyframe() {
+			w.keyframes = append(w.keyframes, keyframe{timestamp: int64(tag.Timestamp), pos: w.pos})
+		}
+		return w.write(tag)
+	default:
+		// other script data and unknown tags are kept as is
+		if w.out == nil {
+			if err := w.openFile(); err != nil {
+				return err
+			}
+		}
+		tag.Timestamp = uint32(w.lastOut)
+		return w.write(tag)
+	}
+}
+
+// reachesLimit reports if the current file should be split before a keyframe at the timestamp.
+func (w *Writer) reachesLimit(timestamp int64) bool {
+	if len(w.keyframes) == 0 {
+		// the file should not be empty
+		return false
+	}
+	return (w.maxDuration > 0 && timestamp >= w.maxDuration) || (w.maxSize > 0 && w.pos >= w.maxSize)
+}
+
+func (w *Writer) write(tag Tag) error {
+	n, err := tag.WriteTo(w.out)
+	w.pos += n
+	return err
+}
+
+// openFile starts a new output file, and writes headers to it.
+// Timestamps of the new file start from 0.
+func (w *Writer) openFile() error {
+	out, err := w.newFile()
+	if err != nil {
+		return err
+	}
+	w.out = out
+	w.writtenAudioHeader, w.writtenVideoHeader = nil, nil
+	w.rebase = true
+	w.rebaseTo = 0
+	w.lastOut = 0
+	w.keyframes = nil
+
+	flags := w.flags
+	if flags&(FlagAudio|FlagVideo) == 0 {
+		flags = FlagAudio | FlagVideo
+	}
+	if err := writeHeader(out, flags); err != nil {
+		return err
+	}
+	w.pos = HeaderSize + prevTagSizeLen
+
+	// write metadata with reserved space for the index
+	w.fileMetadata = streamMetadata(w.metadata)
+	data, err := buildMetadata(w.fileMetadata, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	w.metadataPos = w.pos + TagHeaderSize
+	w.metadataSize = len(data)
+	if err := w.write(Tag{Type: TagScript, Data: data}); err != nil {
+		return err
+	}
+
+	for _, h := range []struct {
+		tag     *Tag
+		written *[]byte
+	}{
+		{w.videoHeader, &w.writtenVideoHeader},
+		{w.audioHeader, &w.writtenAudioHeader},
+	} {
+		if h.tag == nil {
+			continue
+		}
+		tag := *h.tag
+		tag.Timestamp = 0
+		if err := w.write(tag); err != nil {
+			return err
+		}
+		*h.written = h.tag.Data
+	}
+	return nil
+}
+
+// WriteIndex updates the metadata of the current file with duration, file size and keyframe index.
+// f must be the current file, e.g. the *os.File returned by newFile. The file is not modified otherwise.
+// It should be called when the file is finished, before the next file is opened.
+func (w *Writer) WriteIndex(f io.WriterAt) error {
+	if w.out == nil || w.metadataSize == 0 {
+		return nil
+	}
+	data, err := buildMetadata(w.fileMetadata, w.lastOut, w.pos, w.keyframes)
+	if err != nil {
+		return err
+	}
+	if len(data) != w.metadataSize {
+		return fmt.Errorf("metadata size is changed from %v to %v", w.metadataSize, len(data))
+	}
+	_, err = f.WriteAt(data, w.metadataPos)
+	return err
+}
+
+// timestamp converts the timestamp of an input media tag to the output file.
+func (w *Writer) timestamp(in uint32) uint32 {
+	ts := int64(in)
+	if w.rebase {
+		w.offset = w.rebaseTo - ts
+		w.rebase = false
+	} else if d := ts - w.lastIn; d < -maxTimestampJump || d > maxTimestampJump {
+		w.offset = w.lastOut + rebaseGap - ts
+	}
+	w.lastIn = ts
+	out := ts + w.offset
+	if out < 0 {
+		out = 0
+	}
+	if out > w.lastOut {
+		w.lastOut = out
+	}
+	return uint32(out)
+}
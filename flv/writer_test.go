@@ -0,0 +1,321 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testMetadata    = metadataTag(AmfEcmaArray{{"width", 1920.0}, {"duration", 0.0}, {"encoder", "test"}})
+	testVideoHeader = Tag{Type: TagVideo, Data: []byte{0x17, 0x00, 0, 0, 0, 1, 2, 3}}
+	testAudioHeader = Tag{Type: TagAudio, Data: []byte{0xaf, 0x00, 0x12, 0x10}}
+)
+
+func videoFrame(ts uint32, key bool) Tag {
+	b := byte(0x27)
+	if key {
+		b = 0x17
+	}
+	return Tag{Type: TagVideo, Timestamp: ts, Data: []byte{b, 0x01, 0, 0, 0, byte(ts)}}
+}
+
+func audioFrame(ts uint32) Tag {
+	return Tag{Type: TagAudio, Timestamp: ts, Data: []byte{0xaf, 0x01, byte(ts)}}
+}
+
+func metadataTag(value AmfEcmaArray) Tag {
+	data, err := EncodeScriptData(metadataName, value)
+	if err != nil {
+		panic(err)
+	}
+	return Tag{Type: TagScript, Data: data}
+}
+
+// encodeStream encodes an FLV stream with header
+func encodeStream(tags ...Tag) []byte {
+	var buf bytes.Buffer
+	_ = writeHeader(&buf, FlagAudio|FlagVideo)
+	for _, t := range tags {
+		_, _ = t.WriteTo(&buf)
+	}
+	return buf.Bytes()
+}
+
+// decodeFile decodes an FLV file, and checks PreviousTagSize
+func decodeFile(t *testing.T, b []byte) []Tag {
+	_, n, err := parseHeader(b)
+	if err != nil {
+		t.Fatalf("parseHeader: %v", err)
+	}
+	b = b[n:]
+	var tags []Tag
+	for len(b) > 0 {
+		tag, n, ok := parseTag(b)
+		if !ok {
+			t.Fatalf("incomplete tag: %v", b)
+		}
+		if prev := readUint24(b[n-3:]); int(prev) != n-prevTagSizeLen {
+			t.Fatalf("invalid PreviousTagSize: %v, tag size: %v", prev, n-prevTagSizeLen)
+		}
+		tags = append(tags, tag)
+		b = b[n:]
+	}
+	return tags
+}
+
+type testFiles struct {
+	files []*bytes.Buffer
+}
+
+func (f *testFiles) newFile() (io.Writer, error) {
+	buf := &bytes.Buffer{}
+	f.files = append(f.files, buf)
+	return buf, nil
+}
+
+// checkFile checks tags of a file, the first tag should be the generated metadata
+func checkFile(t *testing.T, file []byte, expected []Tag) {
+	tags := decodeFile(t, file)
+	if len(tags) == 0 || !isMetadata(tags[0]) {
+		t.Fatalf("metadata is missing: %v", tags)
+	}
+	checkTags(t, tags[1:], expected)
+}
+
+func checkTags(t *testing.T, actual []Tag, expected []Tag) {
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v tags, got %v: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		a, e := actual[i], expected[i]
+		if a.Type != e.Type || a.Timestamp != e.Timestamp || !bytes.Equal(a.Data, e.Data) {
+			t.Fatalf("tag %v: expected %+v, got %+v", i, e, a)
+		}
+	}
+}
+
+func withTimestamp(tag Tag, ts uint32) Tag {
+	tag.Timestamp = ts
+	return tag
+}
+
+func TestWriter_Reconnect(t *testing.T) {
+	var files testFiles
+	w := NewWriter(files.newFile)
+
+	// written byte by byte to test incomplete input
+	stream1 := encodeStream(testMetadata, testVideoHeader, testAudioHeader,
+		videoFrame(1000, true), audioFrame(1010), videoFrame(1033, false))
+	for i := range stream1 {
+		if _, err := w.Write(stream1[i : i+1]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// the connection is broken in a tag
+	if _, err := w.Write(encodeStream(videoFrame(1066, false))[:20]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	w.Reset()
+	stream2 := encodeStream(testMetadata, testVideoHeader, testAudioHeader,
+		videoFrame(500000, true), audioFrame(500010),
+		// a jump in the stream
+		videoFrame(900000, false))
+	if _, err := w.Write(stream2); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if len(files.files) != 1 {
+		t.Fatalf("expected 1 file, got %v", len(files.files))
+	}
+	checkFile(t, files.files[0].Bytes(), []Tag{
+		testVideoHeader,
+		testAudioHeader,
+		withTimestamp(videoFrame(1000, true), 0),
+		withTimestamp(audioFrame(1010), 10),
+		withTimestamp(videoFrame(1033, false), 33),
+		// headers of the second stream are dropped
+		withTimestamp(videoFrame(500000, true), 73),
+		withTimestamp(audioFrame(500010), 83),
+		withTimestamp(videoFrame(900000, false), 123),
+	})
+}
+
+func TestWriter_CodecChange(t *testing.T) {
+	var files testFiles
+	w := NewWriter(files.newFile)
+
+	_, err := w.Write(encodeStream(testMetadata, testVideoHeader, testAudioHeader,
+		videoFrame(1000, true), audioFrame(1010)))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	w.Reset()
+	newVideoHeader := Tag{Type: TagVideo, Data: []byte{0x17, 0x00, 0, 0, 0, 4, 5, 6}}
+	_, err = w.Write(encodeStream(testMetadata, newVideoHeader, testAudioHeader,
+		videoFrame(2000, true), audioFrame(2010)))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if len(files.files) != 2 {
+		t.Fatalf("expected 2 files, got %v", len(files.files))
+	}
+	checkFile(t, files.files[0].Bytes(), []Tag{
+		testVideoHeader, testAudioHeader,
+		withTimestamp(videoFrame(1000, true), 0),
+		withTimestamp(audioFrame(1010), 10),
+	})
+	checkFile(t, files.files[1].Bytes(), []Tag{
+		newVideoHeader, testAudioHeader,
+		withTimestamp(videoFrame(2000, true), 0),
+		withTimestamp(audioFrame(2010), 10),
+	})
+}
+
+func TestWriter_Split(t *testing.T) {
+	var files testFiles
+	w := NewWriter(files.newFile)
+	w.SetSplitLimit(2*time.Second, 0)
+	tags := []Tag{testMetadata, testVideoHeader, testAudioHeader}
+	// a keyframe every second
+	for i := uint32(0); i < 5; i++ {
+		tags = append(tags, videoFrame(1000+i*1000, true), videoFrame(1000+i*1000+500, false))
+	}
+	if _, err := w.Write(encodeStream(tags...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(files.files) != 3 {
+		t.Fatalf("expected 3 files, got %v", len(files.files))
+	}
+	checkFile(t, files.files[0].Bytes(), []Tag{
+		testVideoHeader, testAudioHeader,
+		withTimestamp(tags[3], 0), withTimestamp(tags[4], 500),
+		withTimestamp(tags[5], 1000), withTimestamp(tags[6], 1500),
+	})
+	// every part starts with headers and a keyframe
+	checkFile(t, files.files[2].Bytes(), []Tag{
+		testVideoHeader, testAudioHeader,
+		withTimestamp(tags[11], 0), withTimestamp(tags[12], 500),
+	})
+
+	files = testFiles{}
+	w = NewWriter(files.newFile)
+	w.SetSplitLimit(0, 1)
+	if _, err := w.Write(encodeStream(tags...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// split at every keyframe
+	if len(files.files) != 5 {
+		t.Fatalf("expected 5 files, got %v", len(files.files))
+	}
+}
+
+func TestWriter_WriteIndex(t *testing.T) {
+	var f *os.File
+	w := NewWriter(func() (io.Writer, error) {
+		var err error
+		f, err = os.Create(filepath.Join(t.TempDir(), "test.flv"))
+		return f, err
+	})
+	tags := []Tag{testMetadata, testVideoHeader, testAudioHeader}
+	for i := uint32(0); i < 100; i++ {
+		tags = append(tags, videoFrame(5000+i*40, i%25 == 0), audioFrame(5000+i*40+5))
+	}
+	if _, err := w.Write(encodeStream(tags...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.WriteIndex(f); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	_ = f.Close()
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	decoded := decodeFile(t, b)
+	if len(decoded) != len(tags) {
+		t.Fatalf("expected %v tags, got %v", len(tags), len(decoded))
+	}
+	_, value, err := DecodeScriptData(decoded[0].Data)
+	if err != nil {
+		t.Fatalf("DecodeScriptData: %v", err)
+	}
+	props := make(map[string]interface{})
+	for _, p := range value.(AmfEcmaArray) {
+		props[p.Name] = p.Value
+	}
+	if props["width"] != 1920.0 || props["encoder"] != "test" {
+		t.Fatalf("stream metadata is not kept: %v", props)
+	}
+	if props["duration"] != 3.965 || props["filesize"] != float64(len(b)) {
+		t.Fatalf("unexpected duration or file size: %v, %v", props["duration"], props["filesize"])
+	}
+	index := props["keyframes"].(AmfObject)
+	times, positions := index[0].Value.([]interface{}), index[1].Value.([]interface{})
+	if len(times) != 4 || len(positions) != 4 {
+		t.Fatalf("unexpected keyframe index: %v", index)
+	}
+	for i := range times {
+		if times[i] != float64(i) {
+			t.Fatalf("unexpected keyframe time: %v", times)
+		}
+		tag, _, ok := parseTag(b[int(positions[i].(float64)):])
+		if !ok || !tag.IsKeyframe() || tag.Timestamp != uint32(i*1000) {
+			t.Fatalf("keyframe position %v points to %+v", positions[i], tag)
+		}
+	}
+}
+
+func TestThinKeyframes(t *testing.T) {
+	var keyframes []keyframe
+	for i := 0; i < 10; i++ {
+		keyframes = append(keyframes, keyframe{timestamp: int64(i)})
+	}
+	thinned := thinKeyframes(keyframes, 4)
+	if len(thinned) != 4 || thinned[1].timestamp != 3 || thinned[3].timestamp != 9 {
+		t.Fatalf("unexpected keyframes: %v", thinned)
+	}
+	if len(thinKeyframes(keyframes, 10)) != 10 {
+		t.Fatalf("keyframes are thinned unnecessarily")
+	}
+}
+
+func TestWriter_InvalidStream(t *testing.T) {
+	var files testFiles
+	w := NewWriter(files.newFile)
+	if _, err := w.Write([]byte("<html>not found</html>")); err == nil {
+		t.Fatalf("invalid stream is accepted")
+	}
+	if len(files.files) != 0 {
+		t.Fatalf("file is created for an invalid stream")
+	}
+}
+
+func TestTag_IsSequenceHeader(t *testing.T) {
+	cases := []struct {
+		tag      Tag
+		expected bool
+	}{
+		{testVideoHeader, true},
+		{testAudioHeader, true},
+		{testMetadata, false},
+		{videoFrame(0, true), false},
+		{audioFrame(0), false},
+		// HEVC
+		{Tag{Type: TagVideo, Data: []byte{0x1c, 0x00, 0, 0, 0}}, true},
+		// Enhanced RTMP, hvc1 SequenceStart and CodedFrames
+		{Tag{Type: TagVideo, Data: []byte{0x90, 'h', 'v', 'c', '1'}}, true},
+		{Tag{Type: TagVideo, Data: []byte{0x91, 'h', 'v', 'c', '1'}}, false},
+	}
+	for i, c := range cases {
+		if c.tag.IsSequenceHeader() != c.expected {
+			t.Fatalf("case %v: expected %v", i, c.expected)
+		}
+	}
+}
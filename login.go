@@ -0,0 +1,95 @@
+package main
+
+/*
+In this file we implement the `slbr login` subcommand,
+which logs in with a QR code or imports cookies exported from browsers,
+and saves cookies of the session to a file. Tasks use the file with option `cookie_file`.
+*/
+
+import (
+	"fmt"
+	"github.com/akamensky/argparse"
+	"github.com/keuin/slbr/bilibili"
+	"github.com/keuin/slbr/logging"
+	"github.com/skip2/go-qrcode"
+	"log"
+	"net/http"
+	"os"
+)
+
+const defaultCookieFile = "cookies.json"
+
+// runLogin runs the login subcommand. args starts with the subcommand name.
+func runLogin(args []string) {
+	var err error
+	parser := argparse.NewParser(
+		"slbr login",
+		"Log in to bilibili with a QR code, or import cookies exported from browsers",
+	)
+	outputPtr := parser.String(
+		"o", "output",
+		&argparse.Options{
+			Required: false,
+			Help:     "Specify the file where to save cookies, which is used as `cookie_file` of tasks",
+			Default:  defaultCookieFile,
+		},
+	)
+	importPtr := parser.String(
+		"i", "import",
+		&argparse.Options{
+			Required: false,
+			Help: "Import cookies from a Netscape cookies.txt or JSON file instead of scanning a QR code. " +
+				"The file must contain cookie SESSDATA",
+		},
+	)
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Printf("ERROR: %v.\n", err)
+		fmt.Print(parser.Usage(""))
+		os.Exit(1)
+	}
+
+	logger := logging.NewWrappedLogger(log.Default(), "login")
+	bi := bilibili.NewBilibili(logger)
+	var cookies []*http.Cookie
+	if *importPtr != "" {
+		cookies, err = bilibili.ReadCookieFile(*importPtr)
+		if err == nil && bilibili.FindCookie(cookies, bilibili.CookieSessData) == "" {
+			err = fmt.Errorf("cookie %v is not found in \"%v\"", bilibili.CookieSessData, *importPtr)
+		}
+	} else {
+		cookies, err = bi.LoginWithQrCode(func(qrCodeUrl string) {
+			qr, err := qrcode.New(qrCodeUrl, qrcode.Low)
+			if err == nil {
+				fmt.Print(qr.ToSmallString(false))
+			}
+			fmt.Printf("Scan the QR code with the bilibili app, or open this URL in the app: %v\n", qrCodeUrl)
+		})
+	}
+	if err != nil {
+		fmt.Printf("ERROR: cannot log in: %v.\n", err)
+		os.Exit(1)
+	}
+	if bilibili.FindCookie(cookies, bilibili.CookieBiliJct) == "" {
+		logger.Warning("Cookie %v is not found.", bilibili.CookieBiliJct)
+	}
+
+	// check the session before saving it
+	bi.SetCookies(cookies)
+	nav, err := bi.GetLoginInfo()
+	if err != nil {
+		logger.Warning("Cannot check the login status: %v", err)
+	} else if !nav.Data.IsLogin {
+		fmt.Println("ERROR: the session is not logged in, the cookies may be expired.")
+		os.Exit(1)
+	} else {
+		logger.Info("Logged in as %v (uid %v).", nav.Data.Uname, nav.Data.Mid)
+	}
+
+	err = bilibili.WriteCookieFile(*outputPtr, cookies)
+	if err != nil {
+		fmt.Printf("ERROR: cannot save cookies: %v.\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Cookies are saved to \"%v\".\n", *outputPtr)
+}
@@ -7,27 +7,28 @@ Task lifecycle management are implemented in recording package.
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/akamensky/argparse"
+	"github.com/keuin/slbr/api"
 	"github.com/keuin/slbr/logging"
 	"github.com/keuin/slbr/recording"
-	"github.com/keuin/slbr/types"
 	"github.com/mitchellh/mapstructure"
 	"github.com/samber/mo"
 	"github.com/spf13/viper"
 	"log"
 	"os"
 	"os/signal"
-	"reflect"
-	"sync"
+	"strconv"
 	"syscall"
 )
 
 const defaultDiskBufSize = uint64(1024 * 1024) // 1MiB
 
-var globalConfig *GlobalConfig
-
-func getTasks() (tasks []recording.TaskConfig) {
+// getConfig parses command line arguments and the config file.
+// newTaskConfig returns the default config of tasks added with the HTTP API.
+// configFile is empty if tasks are specified with command line arguments.
+func getConfig() (config GlobalConfig, newTaskConfig func() recording.TaskConfig, configFile string) {
 	var err error
 	parser := argparse.NewParser(
 		"slbr",
@@ -47,12 +48,21 @@ func getTasks() (tasks []recording.TaskConfig) {
 			Help:     "Specify which configuration file to use. JSON, TOML and YAML are all supported",
 		},
 	)
-	rooms := parser.IntList(
+	rooms := parser.StringList(
 		"s", "room",
 		&argparse.Options{
 			Required: false,
 			Help: "Specify which room to record. " +
-				"The ID is included in bilibili live webpage url. " +
+				"Room IDs, short IDs, live room URLs, b23.tv links and user space URLs are accepted. " +
+				"Set this to run without config file",
+		},
+	)
+	uids := parser.StringList(
+		"u", "uid",
+		&argparse.Options{
+			Required: false,
+			Help: "Specify the UID of a streamer to record. " +
+				"The task follows the streamer if the live room is changed. " +
 				"Set this to run without config file",
 		},
 	)
@@ -74,13 +84,30 @@ func getTasks() (tasks []recording.TaskConfig) {
 			Default: 4194304,
 		},
 	)
+	cookieFilePtr := parser.String(
+		"", "cookies",
+		&argparse.Options{
+			Required: false,
+			Help: "Specify the cookie file of a logged-in session, which is created with `slbr login`. " +
+				"If not set, tasks run as guests",
+		},
+	)
+	apiListenPtr := parser.String(
+		"", "api",
+		&argparse.Options{
+			Required: false,
+			Help: "Specify the address of the HTTP API, e.g. 127.0.0.1:8080. " +
+				"The API is used to inspect and control tasks at runtime. " +
+				"If not set, the API is disabled",
+		},
+	)
 
 	err = parser.Parse(os.Args)
 	if err != nil {
 		return
 	}
 
-	fromCli := len(*rooms) > 0
+	fromCli := len(*rooms) > 0 || len(*uids) > 0
 	fromFile := *configFilePtr != ""
 
 	if fromCli && fromFile {
@@ -88,107 +115,147 @@ func getTasks() (tasks []recording.TaskConfig) {
 		return
 	}
 
-	if !fromCli && !fromFile {
+	if !fromCli && !fromFile && *apiListenPtr == "" {
 		err = fmt.Errorf("no task specified")
 		return
 	}
 
-	if fromFile {
-		configFile := *configFilePtr
-		fmt.Printf("Config file: %v\n", configFile)
-
-		viper.SetConfigFile(configFile)
-
-		err = viper.ReadInConfig()
-		if err != nil {
-			err = fmt.Errorf("cannot open config file \"%v\": %w", configFile, err)
-			return
-		}
-
-		if err != nil {
-			err = fmt.Errorf("cannot read config file \"%v\": %w", configFile, err)
-			return
-		}
-		var gc GlobalConfig
-		netType := reflect.TypeOf(types.IP64)
-		err = viper.Unmarshal(&gc, func(conf *mapstructure.DecoderConfig) {
-			conf.DecodeHook = func(from reflect.Value, to reflect.Value) (interface{}, error) {
-				if to.Type() == netType &&
-					types.IpNetType(from.String()).GetDialNetString() == "" {
-					return nil, fmt.Errorf("invalid IpNetType: %v", from.String())
-				}
-				return from.Interface(), nil
-			}
-		})
-		if err != nil {
-			err = fmt.Errorf("cannot parse config file \"%v\": %w", configFile, err)
-			return
-		}
-		globalConfig = &gc
-		return globalConfig.Tasks
-	}
-
-	// generate task list from cli
-	taskCount := len(*rooms)
-	tasks = make([]recording.TaskConfig, taskCount)
 	saveTo := mo.EmptyableToOption(*saveToPtr).OrElse(".")
 	diskBufSize := uint64(*diskBufSizePtr)
 	if *diskBufSizePtr <= 0 {
 		diskBufSize = defaultDiskBufSize
 	}
-	for i := 0; i < taskCount; i++ {
-		tasks[i] = recording.TaskConfig{
-			RoomId:    types.RoomId((*rooms)[i]),
-			Transport: recording.DefaultTransportConfig(),
+	newTaskConfig = func() recording.TaskConfig {
+		return recording.TaskConfig{
+			CookieFile: *cookieFilePtr,
+			Transport:  recording.DefaultTransportConfig(),
 			Download: recording.DownloadConfig{
 				DiskWriteBufferBytes: int64(diskBufSize),
 				SaveDirectory:        saveTo,
 			},
 		}
 	}
+	defer func() {
+		if *apiListenPtr != "" {
+			config.Api.Listen = *apiListenPtr
+		}
+	}()
 
+	if fromFile {
+		configFile = *configFilePtr
+		fmt.Printf("Config file: %v\n", configFile)
+		config, err = readConfigFile(configFile)
+		return
+	}
+
+	// generate task list from cli
+	for _, room := range *rooms {
+		task := newTaskConfig()
+		task.Room = room
+		config.Tasks = append(config.Tasks, task)
+	}
+	for _, s := range *uids {
+		uid, parseErr := strconv.ParseUint(s, 10, 64)
+		if parseErr != nil || uid == 0 {
+			err = fmt.Errorf("invalid uid: %v", s)
+			return
+		}
+		task := newTaskConfig()
+		task.Uid = uid
+		config.Tasks = append(config.Tasks, task)
+	}
+
+	return
+}
+
+func readConfigFile(configFile string) (config GlobalConfig, err error) {
+	// use a new instance every time, since the config file may be reloaded
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	err = v.ReadInConfig()
+	if err != nil {
+		err = fmt.Errorf("cannot read config file \"%v\": %w", configFile, err)
+		return
+	}
+	err = v.Unmarshal(&config, func(conf *mapstructure.DecoderConfig) {
+		conf.DecodeHook = recording.ConfigDecodeHook
+	})
+	if err != nil {
+		err = fmt.Errorf("cannot parse config file \"%v\": %w", configFile, err)
+		return
+	}
 	return
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		runLogin(os.Args[1:])
+		return
+	}
 	logger := log.Default()
-	taskConfigs := getTasks()
-	tasks := make([]recording.RunningTask, len(taskConfigs))
+	config, newTaskConfig, configFile := getConfig()
 
-	wg := sync.WaitGroup{}
 	ctxTasks, cancelTasks := context.WithCancel(context.Background())
+	manager := recording.NewTaskManager(ctxTasks, func(t recording.TaskConfig) logging.Logger {
+		if t.Uid != 0 {
+			return logging.NewWrappedLogger(logger, fmt.Sprintf("uid %v", t.Uid))
+		}
+		return logging.NewWrappedLogger(logger, fmt.Sprintf("room %v", t.RoomId))
+	})
 	fmt.Println("Record tasks:")
-	for i, task := range taskConfigs {
-		tasks[i] = recording.NewRunningTask(
-			taskConfigs[i],
-			ctxTasks,
-			func() {},
-			func() { wg.Done() },
-			logging.NewWrappedLogger(logger, fmt.Sprintf("room %v", task.RoomId)),
-		)
+	for i, task := range config.Tasks {
 		fmt.Printf("[%2d] %s\n", i+1, task)
 	}
 	fmt.Println("")
 
 	logger.Printf("Starting tasks...")
 
-	for i := range tasks {
-		wg.Add(1)
-		err := tasks[i].StartTask()
-		if err != nil {
-			logger.Printf("Cannot start task %v (room %v): %v. Skip.", i, tasks[i].RoomId, err)
-			wg.Done()
-		}
+	// short ids and URLs are resolved to room ids, which identify tasks
+	// rooms which cannot be resolved now are retried in background, only invalid rooms are fatal
+	resolver := recording.NewRoomResolver(logging.NewWrappedLogger(logger, "resolver"))
+	applier := newTaskApplier(ctxTasks, manager, resolver, logger)
+	if _, err := applier.apply(config.Tasks, false); errors.Is(err, recording.ErrInvalidRoomConfig) {
+		fmt.Printf("ERROR: %v.\n", err)
+		os.Exit(1)
+	} else if err != nil {
+		logger.Printf("Cannot start some tasks: %v. Skip.", err)
+	}
+
+	chApiStopped := make(chan struct{})
+	if config.Api.Listen != "" {
+		server := api.NewServer(
+			manager,
+			resolver,
+			config.Api.Token,
+			newTaskConfig,
+			logging.NewWrappedLogger(logger, "api"),
+		)
+		go func() {
+			defer close(chApiStopped)
+			err := server.ListenAndServe(ctxTasks, config.Api.Listen)
+			if err != nil {
+				logger.Printf("HTTP API is stopped: %v", err)
+			}
+		}()
+	} else {
+		close(chApiStopped)
+	}
+
+	if configFile != "" {
+		go watchConfig(ctxTasks, configFile, applier, logger)
 	}
 
 	// listen on stop signals
-	chSigStop := make(chan os.Signal)
+	// SIGHUP reloads the config file if present, see watchConfig
+	chSigStop := make(chan os.Signal, 1)
 	signal.Notify(chSigStop,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM)
+	if configFile == "" {
+		signal.Notify(chSigStop, syscall.SIGHUP)
+	}
 
-	chSigQuit := make(chan os.Signal)
+	chSigQuit := make(chan os.Signal, 1)
 	signal.Notify(chSigQuit, syscall.SIGQUIT)
 	go func() {
 		select {
@@ -203,7 +270,13 @@ func main() {
 
 	// block main goroutine on task goroutines
 	defer func() {
-		wg.Wait()
+		if config.Api.Listen != "" || configFile != "" {
+			// tasks may be added with the API or by reloading the config file at any time,
+			// so we run until stopped by signals
+			<-ctxTasks.Done()
+		}
+		<-chApiStopped
+		manager.Wait()
 		logger.Println("YABR is stopped.")
 	}()
 }
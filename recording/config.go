@@ -2,14 +2,34 @@ package recording
 
 import (
 	"fmt"
+	"github.com/keuin/slbr/bilibili"
+	"github.com/keuin/slbr/common/files"
 	"github.com/keuin/slbr/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type TaskConfig struct {
-	RoomId    types.RoomId    `mapstructure:"room_id"`
-	Transport TransportConfig `mapstructure:"transport"`
-	Download  DownloadConfig  `mapstructure:"download"`
-	Watch     WatchConfig     `mapstructure:"watch"`
+	// RoomId: the canonical room id, short ids are resolved before the task is started
+	RoomId types.RoomId `mapstructure:"room_id"`
+	// Room: the live room in other forms, e.g. a live room URL, a b23.tv short link or a user space URL,
+	// see bilibili.ResolveRoom. RoomId is resolved from it before the task is started
+	Room string `mapstructure:"room"`
+	// Uid: the streamer to record, instead of a room. The live room of the streamer is looked up
+	// when the task runs and checked periodically, so the task follows the streamer if the room is changed
+	Uid uint64 `mapstructure:"uid"`
+	// CookieFile: cookies of a logged-in session, see bilibili.ReadCookieFile for supported formats.
+	// Guests are used if empty, which get limited stream quality and masked user names in danmaku
+	CookieFile string          `mapstructure:"cookie_file"`
+	Transport  TransportConfig `mapstructure:"transport"`
+	Download   DownloadConfig  `mapstructure:"download"`
+	Watch      WatchConfig     `mapstructure:"watch"`
+	Stream     StreamConfig    `mapstructure:"stream"`
+	Storage    StorageConfig   `mapstructure:"storage"`
+	// Hooks: commands or webhooks triggered by task events
+	Hooks []HookConfig `mapstructure:"hooks"`
 }
 
 type TransportConfig struct {
@@ -17,16 +37,114 @@ type TransportConfig struct {
 	RetryIntervalSeconds int               `mapstructure:"retry_interval_seconds"`
 	MaxRetryTimes        int               `mapstructure:"max_retry_times"`
 	AllowedNetworkTypes  []types.IpNetType `mapstructure:"allowed_network_types"`
+	// StallTimeoutSeconds: reconnect if no data is received from the stream in this duration,
+	// 0 means the default value, negative values disable stall detection
+	StallTimeoutSeconds int `mapstructure:"stall_timeout_seconds"`
+	// MinSpeedBytesPerSecond: reconnect if the average speed in the stall timeout is less than this,
+	// 0 disables the speed check
+	MinSpeedBytesPerSecond int64 `mapstructure:"min_speed_bytes_per_second"`
+}
+
+// defaultStallTimeout is used when StallTimeoutSeconds is not set
+const defaultStallTimeout = 30 * time.Second
+
+func (t TransportConfig) StallDetection() bilibili.StallDetection {
+	timeout := time.Duration(t.StallTimeoutSeconds) * time.Second
+	if t.StallTimeoutSeconds == 0 {
+		timeout = defaultStallTimeout
+	} else if t.StallTimeoutSeconds < 0 {
+		timeout = 0
+	}
+	return bilibili.StallDetection{
+		Timeout:  timeout,
+		MinSpeed: t.MinSpeedBytesPerSecond,
+	}
 }
 
 type DownloadConfig struct {
 	SaveDirectory                    string `mapstructure:"save_directory"`
 	DiskWriteBufferBytes             int64  `mapstructure:"disk_write_buffer_bytes"`
 	UseSpecialExtNameBeforeFinishing bool   `mapstructure:"use_special_ext_name_when_downloading"`
+	// DanmakuExportFormats: which formats the captured danmaku are converted to
+	// when the recording is finished, available values: "xml", "ass"
+	DanmakuExportFormats []string `mapstructure:"danmaku_export_formats"`
+	// RawFlv: save FLV streams as they are received. By default, timestamps and headers are fixed,
+	// and the file is continued after reconnecting. It cannot be used with split limits,
+	// since parts of a raw stream do not have headers
+	RawFlv bool `mapstructure:"raw_flv"`
+	// SplitDurationSeconds and SplitSizeBytes: start a new file when the current one reaches the limit,
+	// 0 means no limit. FLV files are split at keyframes, and HLS streams are split between segments
+	SplitDurationSeconds int   `mapstructure:"split_duration_seconds"`
+	SplitSizeBytes       int64 `mapstructure:"split_size_bytes"`
+	// FileNameTemplate: path of recorded files relative to the save directory, e.g. "{uid}/{yyyy}-{mm}/{title}",
+	// the default is "{title}_{yyyy}-{mm}-{dd}-{hh}-{mi}-{ss}". See filename.go for all placeholders
+	FileNameTemplate string `mapstructure:"file_name_template"`
+	// FileNameReplacePolicy: how illegal characters in file names (e.g. "/", ":", "?") are handled,
+	// available values: "replace" (default), "fullwidth", "remove"
+	FileNameReplacePolicy files.ReplacePolicy `mapstructure:"file_name_replace_policy"`
+	// FileNameReplacement: the string which replaces illegal characters with policy "replace", default is "_"
+	FileNameReplacement string `mapstructure:"file_name_replacement"`
+	// FileNameMaxBytes: the maximum length of each path component in bytes, default is 255
+	FileNameMaxBytes int `mapstructure:"file_name_max_bytes"`
+}
+
+// minFileNameMaxBytes leaves enough room for the file name after the suffix is reserved
+const minFileNameMaxBytes = 64
+
+// fileNameSanitizer returns the sanitizer which converts generated names to valid file names.
+func (d DownloadConfig) fileNameSanitizer() (*files.Sanitizer, error) {
+	if d.FileNameMaxBytes != 0 && d.FileNameMaxBytes < minFileNameMaxBytes {
+		return nil, fmt.Errorf("file_name_max_bytes must be at least %v", minFileNameMaxBytes)
+	}
+	return files.NewSanitizer(d.FileNameReplacePolicy, d.FileNameReplacement, d.FileNameMaxBytes)
+}
+
+// splitLimit returns the maximum duration and size of files, zero means no limit.
+func (d DownloadConfig) splitLimit() (maxDuration time.Duration, maxSize int64) {
+	if d.SplitDurationSeconds > 0 {
+		maxDuration = time.Duration(d.SplitDurationSeconds) * time.Second
+	}
+	if d.SplitSizeBytes > 0 {
+		maxSize = d.SplitSizeBytes
+	}
+	return
+}
+
+// StreamConfig selects which stream is recorded.
+// Each list contains allowed values in the order of preference, empty lists mean default values.
+type StreamConfig struct {
+	// QualityNumbers: e.g. 10000 (original), 400 (blu-ray), 250 (super clear),
+	// the highest quality is used if none of them is available
+	QualityNumbers []int `mapstructure:"qn"`
+	// Codecs: "avc", "hevc", default is avc then hevc
+	Codecs []types.StreamCodec `mapstructure:"codecs"`
+	// Protocols: "flv", "hls", default is flv then hls
+	Protocols []types.StreamProtocol `mapstructure:"protocols"`
+}
+
+func (s StreamConfig) Preference() bilibili.StreamPreference {
+	return bilibili.StreamPreference{
+		QualityNumbers: s.QualityNumbers,
+		Codecs:         s.Codecs,
+		Protocols:      s.Protocols,
+	}
 }
 
 type WatchConfig struct {
 	LiveInterruptedRestartSleepSeconds int `mapstructure:"live_interrupted_restart_sleep_seconds"`
+	// RoomCheckIntervalSeconds: how often the live room of the streamer is checked in tasks of a uid,
+	// 0 means the default value
+	RoomCheckIntervalSeconds int `mapstructure:"room_check_interval_seconds"`
+}
+
+// defaultRoomCheckInterval is used when RoomCheckIntervalSeconds is not set
+const defaultRoomCheckInterval = 5 * time.Minute
+
+func (w WatchConfig) RoomCheckInterval() time.Duration {
+	if w.RoomCheckIntervalSeconds <= 0 {
+		return defaultRoomCheckInterval
+	}
+	return time.Duration(w.RoomCheckIntervalSeconds) * time.Second
 }
 
 func DefaultTransportConfig() TransportConfig {
@@ -37,8 +155,71 @@ func DefaultTransportConfig() TransportConfig {
 	}
 }
 
+// Validate checks values which cannot be checked when decoding.
+func (t TaskConfig) Validate() error {
+	sanitizer, err := t.Download.fileNameSanitizer()
+	if err != nil {
+		return err
+	}
+	if t.Download.FileNameTemplate != "" {
+		_, err := expandFileName(t.Download.FileNameTemplate, fileNameInfo{Title: "title"}, time.Now(), 1, sanitizer)
+		if err != nil {
+			return err
+		}
+	}
+	if t.Download.RawFlv && (t.Download.SplitDurationSeconds > 0 || t.Download.SplitSizeBytes > 0) {
+		return fmt.Errorf("raw_flv cannot be used with split_duration_seconds or split_size_bytes")
+	}
+	if err := t.Storage.validate(); err != nil {
+		return err
+	}
+	for _, hook := range t.Hooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TaskKey identifies a task. Tasks of a room are keyed by the room id,
+// and tasks of a streamer are keyed by "uid:" and the uid, since the room of the streamer may change.
+type TaskKey string
+
+const uidKeyPrefix = "uid:"
+
+// Key returns the key of the task. The room must be resolved before calling Key.
+func (t TaskConfig) Key() TaskKey {
+	if t.Uid != 0 {
+		return TaskKey(uidKeyPrefix + strconv.FormatUint(t.Uid, 10))
+	}
+	return TaskKey(strconv.FormatUint(uint64(t.RoomId), 10))
+}
+
+// ParseTaskKey parses a room id, or "uid:" followed by a uid.
+func ParseTaskKey(s string) (TaskKey, error) {
+	if uid, ok := strings.CutPrefix(s, uidKeyPrefix); ok {
+		id, err := strconv.ParseUint(uid, 10, 64)
+		if err != nil || id == 0 {
+			return "", fmt.Errorf("invalid uid: %v", uid)
+		}
+		return TaskConfig{Uid: id}.Key(), nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return "", fmt.Errorf("invalid room id: %v", s)
+	}
+	return TaskConfig{RoomId: types.RoomId(id)}.Key(), nil
+}
+
 func (t TaskConfig) String() string {
-	return fmt.Sprintf("Room ID: %v, %v, %v", t.RoomId, t.Transport.String(), t.Download.String())
+	room := fmt.Sprintf("Room ID: %v", t.RoomId)
+	if t.Room != "" {
+		room += fmt.Sprintf(" (%v)", t.Room)
+	}
+	if t.Uid != 0 {
+		room = fmt.Sprintf("UID: %v", t.Uid)
+	}
+	return fmt.Sprintf("%v, %v, %v", room, t.Transport.String(), t.Download.String())
 }
 
 func (t TransportConfig) String() string {
@@ -49,3 +230,40 @@ func (t TransportConfig) String() string {
 func (d DownloadConfig) String() string {
 	return fmt.Sprintf("Save directory: \"%v\"", d.SaveDirectory)
 }
+
+var (
+	netType      = reflect.TypeOf(types.IP64)
+	codecType    = reflect.TypeOf(types.CodecAvc)
+	protocolType = reflect.TypeOf(types.ProtocolFlv)
+	hookType     = reflect.TypeOf(HookFileFinished)
+	roomIdType   = reflect.TypeOf(types.RoomId(0))
+)
+
+// ConfigDecodeHook validates values which cannot be checked by types when decoding configs with mapstructure.
+func ConfigDecodeHook(from reflect.Value, to reflect.Value) (interface{}, error) {
+	switch to.Type() {
+	case netType:
+		if types.IpNetType(from.String()).GetDialNetString() == "" {
+			return nil, fmt.Errorf("invalid IpNetType: %v", from.String())
+		}
+	case codecType:
+		if !types.StreamCodec(from.String()).IsValid() {
+			return nil, fmt.Errorf("invalid codec: %v", from.String())
+		}
+	case protocolType:
+		if !types.StreamProtocol(from.String()).IsValid() {
+			return nil, fmt.Errorf("invalid protocol: %v", from.String())
+		}
+	case roomIdType:
+		if from.Kind() == reflect.String {
+			if _, err := strconv.ParseUint(from.String(), 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid room_id: %v, use `room` for URLs", from.String())
+			}
+		}
+	case hookType:
+		if !HookEvent(from.String()).IsValid() {
+			return nil, fmt.Errorf("invalid hook event: %v", from.String())
+		}
+	}
+	return from.Interface(), nil
+}
@@ -0,0 +1,188 @@
+package recording
+
+/*
+In this file we implement the danmaku capture.
+The watcher keeps the danmaku connection open while recording,
+and every danmaku message is saved to a sidecar file of the video being recorded.
+*/
+
+import (
+	"fmt"
+	"github.com/keuin/slbr/common/files"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"github.com/keuin/slbr/logging"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DanmakuFormatXml = "xml"
+	DanmakuFormatAss = "ass"
+)
+
+// danmakuRecorder saves received danmaku messages to the sidecar file of current video file.
+// Messages received when no video file is being written are dropped.
+// It is safe to use a danmakuRecorder in multiple goroutines.
+type danmakuRecorder struct {
+	dmmsg.BaseHandler
+	lock   sync.Mutex
+	file   *os.File
+	writer *dmfile.Writer
+	// pausedAt: the time since when the video is paused, zero if not paused, see Pause
+	pausedAt time.Time
+	logger   logging.Logger
+}
+
+func newDanmakuRecorder(logger logging.Logger) *danmakuRecorder {
+	return &danmakuRecorder{
+		logger: logger,
+	}
+}
+
+// Open creates the sidecar file. start is the time when the video starts.
+// If another sidecar file is opened, it will be closed.
+func (r *danmakuRecorder) Open(filePath string, start time.Time) error {
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot create danmaku file: %w", err)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closeLocked()
+	r.file = f
+	r.writer = dmfile.NewWriter(f, start)
+	r.pausedAt = time.Time{}
+	r.logger.Info("Saving danmaku to file \"%v\"...", filePath)
+	return nil
+}
+
+// Pause stops the clock of danmaku offsets at the given time, until Resume is called.
+// It is used when the stream is interrupted, and the gap will be removed from the video, see flv.Writer.
+// Messages received while paused are saved at the time when the video is paused.
+func (r *danmakuRecorder) Pause(at time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writer == nil || at.IsZero() || !r.pausedAt.IsZero() {
+		return
+	}
+	r.pausedAt = at
+}
+
+// Resume restarts the clock paused by Pause. It is a no-op if the clock is not paused.
+func (r *danmakuRecorder) Resume(at time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writer == nil || r.pausedAt.IsZero() {
+		return
+	}
+	if at.After(r.pausedAt) {
+		r.writer.Delay(at.Sub(r.pausedAt))
+	}
+	r.pausedAt = time.Time{}
+}
+
+// Close closes current sidecar file. It is a no-op if no file is opened.
+func (r *danmakuRecorder) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closeLocked()
+}
+
+func (r *danmakuRecorder) closeLocked() {
+	if r.file == nil {
+		return
+	}
+	if err := r.file.Close(); err != nil {
+		r.logger.Error("Cannot close danmaku file: %v", err)
+	}
+	r.file = nil
+	r.writer = nil
+}
+
+// OnDanMu writes a danmaku message to current sidecar file.
+func (r *danmakuRecorder) OnDanMu(dm dmmsg.DanMuMessage) {
+	now := time.Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writer == nil {
+		return
+	}
+	if !r.pausedAt.IsZero() {
+		now = r.pausedAt
+	}
+	if err := r.writer.Write(dm, now); err != nil {
+		r.logger.Error("Cannot save danmaku: %v", err)
+	}
+}
+
+// exportDanmaku converts a danmaku sidecar file to the given formats.
+// The exported files are named after baseName with the format name as the extension name.
+func exportDanmaku(sidecarPath string, baseName string, formats []string, logger logging.Logger) {
+	if len(formats) == 0 {
+		return
+	}
+	f, err := os.Open(sidecarPath)
+	if err != nil {
+		logger.Error("Cannot open danmaku file: %v", err)
+		return
+	}
+	entries, err := dmfile.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		logger.Error("Cannot read danmaku file \"%v\": %v", sidecarPath, err)
+		return
+	}
+	for _, format := range formats {
+		filePath := files.CombineFileName(baseName, format)
+		err := exportDanmakuFile(filePath, format, entries)
+		if err != nil {
+			logger.Error("Cannot export danmaku to \"%v\": %v", filePath, err)
+			continue
+		}
+		logger.Info("Exported %v danmaku to \"%v\".", len(entries), filePath)
+	}
+}
+
+func exportDanmakuFile(filePath string, format string, entries []dmfile.Entry) (err error) {
+	var newExporter func(w io.Writer) (danmakuExporter, error)
+	switch format {
+	case DanmakuFormatXml:
+		newExporter = func(w io.Writer) (danmakuExporter, error) {
+			return dmmsg.NewXmlWriter(w)
+		}
+	case DanmakuFormatAss:
+		newExporter = func(w io.Writer) (danmakuExporter, error) {
+			return dmmsg.NewAssWriter(w, dmmsg.DefaultAssOptions())
+		}
+	default:
+		return fmt.Errorf("unsupported danmaku format: %v", format)
+	}
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+	}()
+	exporter, err := newExporter(f)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = exporter.Write(e.Offset(), e.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return exporter.Close()
+}
+
+type danmakuExporter interface {
+	Write(offset time.Duration, dm dmmsg.DanMuMessage) error
+	Close() error
+}
@@ -0,0 +1,141 @@
+package recording
+
+/*
+In this file we generate file names from templates.
+A template is a relative path with placeholders, e.g. "{uid}/{yyyy}-{mm}/{title}.flv".
+Subdirectories are created automatically. The extension name is decided by the stream,
+so the extension in the template is replaced.
+Each path component is sanitized, so any title results in a valid file name.
+*/
+
+import (
+	"fmt"
+	"github.com/keuin/slbr/common/files"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFileNameTemplate generates the same name as GenerateFileName
+const defaultFileNameTemplate = "{title}_{yyyy}-{mm}-{dd}-{hh}-{mi}-{ss}"
+
+// fileNameInfo contains values of placeholders about the live room.
+type fileNameInfo struct {
+	RoomId     uint64
+	ShortId    int
+	UID        int
+	Name       string
+	Title      string
+	Area       string
+	ParentArea string
+}
+
+// streamExtNames are replaced if the template ends with them
+var streamExtNames = []string{".flv", ".mp4", ".ts", ".m4s"}
+
+const (
+	// maxPartSuffixBytes: "_part" and the index of the file, see recordingFiles.nextBaseName
+	maxPartSuffixBytes = len("_part") + 6
+	// maxCollisionSuffixBytes: "_" and the counter appended by files.UniqueName
+	maxCollisionSuffixBytes = len("_") + 4
+	// maxExtNameBytes: the longest extension name of files of a recording, which is the captured danmaku
+	maxExtNameBytes = len(".") + len(dmfile.ExtName)
+)
+
+// fileNameSuffixBytes is reserved in the last component of file names
+// for the part suffix, the collision suffix and extension names
+const fileNameSuffixBytes = maxPartSuffixBytes + maxCollisionSuffixBytes + maxExtNameBytes
+
+// expandFileName returns the file name without the extension name, relative to the save directory.
+// t is the time when the file is created, part is the index of the file in a live.
+// Each path component is sanitized by s, so values like titles cannot create directories or invalid names.
+func expandFileName(template string, info fileNameInfo, t time.Time, part int, s *files.Sanitizer) (string, error) {
+	utc := t.UTC()
+	values := map[string]string{
+		"room_id":     strconv.FormatUint(info.RoomId, 10),
+		"short_id":    strconv.Itoa(info.ShortId),
+		"uid":         strconv.Itoa(info.UID),
+		"name":        info.Name,
+		"title":       info.Title,
+		"area":        info.Area,
+		"parent_area": info.ParentArea,
+		"part":        strconv.Itoa(part),
+		"unix":        strconv.FormatInt(t.Unix(), 10),
+	}
+	for prefix, t := range map[string]time.Time{"": t, "utc_": utc} {
+		values[prefix+"yyyy"] = fmt.Sprintf("%04d", t.Year())
+		values[prefix+"mm"] = fmt.Sprintf("%02d", t.Month())
+		values[prefix+"dd"] = fmt.Sprintf("%02d", t.Day())
+		values[prefix+"hh"] = fmt.Sprintf("%02d", t.Hour())
+		values[prefix+"mi"] = fmt.Sprintf("%02d", t.Minute())
+		values[prefix+"ss"] = fmt.Sprintf("%02d", t.Second())
+	}
+
+	template = strings.ReplaceAll(template, `\`, "/")
+	if path.IsAbs(template) {
+		return "", fmt.Errorf("file name template must be a relative path: %v", template)
+	}
+	var components []string
+	for _, c := range strings.Split(template, "/") {
+		if c == "" || c == "." {
+			continue
+		}
+		if c == ".." {
+			// the file must be in the save directory
+			return "", fmt.Errorf("file name template must not contain \"..\": %v", template)
+		}
+		components = append(components, c)
+	}
+	if len(components) == 0 {
+		return "", fmt.Errorf("empty file name template: %v", template)
+	}
+	last := len(components) - 1
+	for _, ext := range streamExtNames {
+		if strings.HasSuffix(components[last], ext) {
+			components[last] = strings.TrimSuffix(components[last], ext)
+			break
+		}
+	}
+	if components[last] == "" {
+		return "", fmt.Errorf("file name template has no file name: %v", template)
+	}
+
+	for i, c := range components {
+		expanded, err := expandComponent(c, values, s)
+		if err != nil {
+			return "", fmt.Errorf("%w in file name template: %v", err, template)
+		}
+		maxBytes := s.MaxBytes()
+		if i == last {
+			maxBytes -= fileNameSuffixBytes
+		}
+		components[i] = s.Sanitize(expanded, maxBytes)
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// expandComponent replaces placeholders in a path component.
+func expandComponent(c string, values map[string]string, s *files.Sanitizer) (string, error) {
+	sb := strings.Builder{}
+	rest := c
+	for {
+		before, after, ok := strings.Cut(rest, "{")
+		sb.WriteString(before)
+		if !ok {
+			return sb.String(), nil
+		}
+		key, after, ok := strings.Cut(after, "}")
+		if !ok {
+			return "", fmt.Errorf("unclosed placeholder")
+		}
+		value, ok := values[key]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%v}", key)
+		}
+		// values must not create directories
+		sb.WriteString(s.ReplaceIllegal(value))
+		rest = after
+	}
+}
@@ -0,0 +1,86 @@
+package recording
+
+import (
+	"github.com/keuin/slbr/common/files"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandFileName(t *testing.T) {
+	info := fileNameInfo{
+		RoomId:     22625025,
+		ShortId:    1234,
+		UID:        5678,
+		Name:       "streamer",
+		Title:      "a/b",
+		Area:       "area",
+		ParentArea: "parent",
+	}
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("UTC+8", 8*3600))
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{defaultFileNameTemplate, "a_b_2024-01-02-03-04-05"},
+		{"{uid}/{yyyy}-{mm}/{title}.flv", "5678/2024-01/a_b"},
+		{"{room_id}-{short_id}-{name}-{area}-{parent_area}-{part}", "22625025-1234-streamer-area-parent-3"},
+		{"{utc_yyyy}{utc_mm}{utc_dd}{utc_hh}{utc_mi}{utc_ss}", "20240101190405"},
+		{"{unix}.ts", "1704135845"},
+		{"./x//{title}.mp4.flv", "x/a_b.mp4"},
+	}
+	for _, c := range cases {
+		name, err := expandFileName(c.template, info, tm, 3, files.DefaultSanitizer)
+		if err != nil {
+			t.Fatalf("%v: %v", c.template, err)
+		}
+		if name != c.expected {
+			t.Fatalf("%v: expected %v, got %v", c.template, c.expected, name)
+		}
+	}
+
+	for _, template := range []string{
+		"{title",
+		"{no_such_key}",
+		"/abs/{title}",
+		"../{title}",
+		"{title}/../..",
+		".flv",
+	} {
+		if _, err := expandFileName(template, info, tm, 1, files.DefaultSanitizer); err == nil {
+			t.Fatalf("invalid template is accepted: %v", template)
+		}
+	}
+}
+
+func TestExpandFileName_Sanitize(t *testing.T) {
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		title    string
+		expected string
+	}{
+		{"a:b?c*", "a_b_c_/x"},
+		{"..", "_/x"},
+		{"con", "con_/x"},
+		{"line\nbreak. ", "line_break/x"},
+		{strings.Repeat("直", 100), strings.Repeat("直", 85) + "/x"},
+	}
+	for _, c := range cases {
+		name, err := expandFileName("{title}/x", fileNameInfo{Title: c.title}, tm, 1, files.DefaultSanitizer)
+		if err != nil {
+			t.Fatalf("%q: %v", c.title, err)
+		}
+		if name != c.expected {
+			t.Fatalf("%q: expected %v, got %v", c.title, c.expected, name)
+		}
+	}
+
+	// the suffix is reserved in the last component
+	name, err := expandFileName("{title}", fileNameInfo{Title: strings.Repeat("a", 300)}, tm, 1, files.DefaultSanitizer)
+	if err != nil {
+		t.Fatalf("expandFileName: %v", err)
+	}
+	if len(name) != files.DefaultMaxBytes-fileNameSuffixBytes {
+		t.Fatalf("unexpected length: %v", len(name))
+	}
+}
@@ -0,0 +1,116 @@
+package recording
+
+/*
+In this file we implement built-in danmaku message handlers of a task.
+*/
+
+import (
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"github.com/keuin/slbr/logging"
+)
+
+// liveStatusHandler detects live status changes from server messages.
+type liveStatusHandler struct {
+	dmmsg.BaseHandler
+	onLiveStart func()
+	onLiveEnd   func()
+}
+
+func (h *liveStatusHandler) OnLive(dmmsg.LiveMessage) {
+	h.onLiveStart()
+}
+
+func (h *liveStatusHandler) OnPreparing(dmmsg.PreparingMessage) {
+	h.onLiveEnd()
+}
+
+func (h *liveStatusHandler) OnWarning(msg dmmsg.WarningMessage) {
+	if msg.IsCutOff() {
+		h.onLiveEnd()
+	}
+}
+
+// loggingHandler prints server messages to the task logger.
+type loggingHandler struct {
+	logger logging.Logger
+}
+
+func (h *loggingHandler) OnDanMu(msg dmmsg.DanMuMessage) {
+	h.logger.Info("Danmaku: %v", msg.String())
+}
+
+func (h *loggingHandler) OnInteractWord(msg dmmsg.InteractWordMessage) {
+	h.logger.Info("Interact word message: user: %v medal: %v", msg.UserName, msg.FansMedal.Name)
+}
+
+func (h *loggingHandler) OnWatchedChange(msg dmmsg.WatchedChangeMessage) {
+	h.logger.Info("The number of viewers: %v", msg.Num)
+}
+
+func (h *loggingHandler) OnSendGift(msg dmmsg.SendGiftMessage) {
+	h.logger.Info("Gift: %v", msg.String())
+}
+
+func (h *loggingHandler) OnComboSend(msg dmmsg.ComboSendMessage) {
+	h.logger.Info("Gift combo: %v", msg.String())
+}
+
+func (h *loggingHandler) OnSuperChat(msg dmmsg.SuperChatMessage) {
+	h.logger.Info("SuperChat: %v", msg.String())
+}
+
+func (h *loggingHandler) OnGuardBuy(msg dmmsg.GuardBuyMessage) {
+	h.logger.Info("Guard: %v", msg.String())
+}
+
+func (h *loggingHandler) OnRoomChange(msg dmmsg.RoomChangeMessage) {
+	h.logger.Info("Room info changed: title: %v, area: %v/%v",
+		msg.Title, msg.ParentAreaName, msg.AreaName)
+}
+
+func (h *loggingHandler) OnPreparing(dmmsg.PreparingMessage) {
+	h.logger.Info("The live is ended.")
+}
+
+func (h *loggingHandler) OnLive(dmmsg.LiveMessage) {
+	h.logger.Info("The live is started.")
+}
+
+func (h *loggingHandler) OnRoomBlock(msg dmmsg.RoomBlockMessage) {
+	h.logger.Info("User is blocked: %v (uid: %v)", msg.UserName, msg.UID)
+}
+
+func (h *loggingHandler) OnWarning(msg dmmsg.WarningMessage) {
+	if msg.IsCutOff() {
+		h.logger.Warning("The live is cut off by the administrator: %v", msg.Message)
+	} else {
+		h.logger.Warning("Warning from the administrator: %v", msg.Message)
+	}
+}
+
+func (h *loggingHandler) OnOnlineRankCount(msg dmmsg.OnlineRankCountMessage) {
+	h.logger.Info("Online rank count: %v", msg.Count)
+}
+
+func (h *loggingHandler) OnUnknown(cmd string, body []byte) {
+	switch cmd {
+	case "ENTRY_EFFECT", "ONLINE_RANK_V2", "STOP_LIVE_ROOM_LIST", "HOT_RANK_CHANGED_V2":
+		// useless message
+		h.logger.Debug("Ignore message: %v", cmd)
+	default:
+		h.logger.Info("Ignore unhandled server message %v %v", cmd, string(body))
+	}
+}
+
+// hookHandler triggers hooks of room changes.
+type hookHandler struct {
+	dmmsg.BaseHandler
+	hooks *hookRunner
+}
+
+func (h *hookHandler) OnRoomChange(msg dmmsg.RoomChangeMessage) {
+	// the message is also sent when only the area is changed
+	if h.hooks.setTitle(msg.Title) {
+		h.hooks.fire(HookEventData{Event: HookTitleChanged, Title: msg.Title})
+	}
+}
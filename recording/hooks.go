@@ -0,0 +1,252 @@
+package recording
+
+/*
+In this file we implement hooks, which are shell commands or webhooks triggered by task events,
+e.g. a file is finished. They are used to trigger remuxing, uploading and notifications.
+Hooks run asynchronously, so they never block recording. Running hooks are not waited for when the task is stopped,
+so a slow hook never blocks stopping or reloading the task. All hooks have timeouts, so they cannot run forever.
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type HookEvent string
+
+const (
+	// HookRecordingStarted is triggered when a new file is created
+	HookRecordingStarted HookEvent = "recording_started"
+	// HookFileFinished is triggered when a file is finished, after its manifest is written
+	HookFileFinished HookEvent = "file_finished"
+	// HookLiveEnded is triggered when the live is ended
+	HookLiveEnded HookEvent = "live_ended"
+	// HookTaskError is triggered when the task is interrupted by an error
+	HookTaskError HookEvent = "task_error"
+	// HookTitleChanged is triggered when the title of the live room is changed
+	HookTitleChanged HookEvent = "title_changed"
+)
+
+var hookEvents = map[HookEvent]bool{
+	HookRecordingStarted: true,
+	HookFileFinished:     true,
+	HookLiveEnded:        true,
+	HookTaskError:        true,
+	HookTitleChanged:     true,
+}
+
+func (e HookEvent) IsValid() bool {
+	return hookEvents[e]
+}
+
+// HookConfig is a shell command or a webhook. Exactly one of Command and Url should be set.
+type HookConfig struct {
+	// Events: which events trigger this hook, empty means all events
+	Events []HookEvent `mapstructure:"events"`
+	// Command: executed with "sh -c" ("cmd /C" on Windows), the event is passed in environment variables,
+	// see hookEnv for available variables
+	Command string `mapstructure:"command"`
+	// Url: the event is POSTed to this URL as a JSON object
+	Url string `mapstructure:"url"`
+	// TimeoutSeconds: the hook is killed or cancelled after this duration,
+	// 0 means defaultCommandTimeout for commands, and defaultWebhookTimeout for webhooks
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+}
+
+const (
+	// defaultCommandTimeout is long enough for remuxing or uploading a file
+	defaultCommandTimeout = time.Hour
+	defaultWebhookTimeout = 30 * time.Second
+)
+
+func (h HookConfig) validate() error {
+	if (h.Command == "") == (h.Url == "") {
+		return fmt.Errorf("exactly one of command and url should be set in a hook")
+	}
+	return nil
+}
+
+func (h HookConfig) accepts(e HookEvent) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, event := range h.Events {
+		if event == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (h HookConfig) timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	if h.Url != "" {
+		return defaultWebhookTimeout
+	}
+	return defaultCommandTimeout
+}
+
+// HookEventData is the body of webhook requests.
+type HookEventData struct {
+	Event  HookEvent    `json:"event"`
+	Time   time.Time    `json:"time"`
+	RoomId types.RoomId `json:"room_id"`
+	Title  string       `json:"title"`
+	// FilePath: the created file of recording_started, or the finished file of file_finished
+	FilePath string `json:"file_path,omitempty"`
+	// ManifestPath and Manifest: the manifest of file_finished
+	ManifestPath string    `json:"manifest_path,omitempty"`
+	Manifest     *Manifest `json:"manifest,omitempty"`
+	// Error: the error of task_error
+	Error string `json:"error,omitempty"`
+}
+
+// hookEnv returns environment variables which are passed to hook commands.
+func (d *HookEventData) hookEnv() []string {
+	env := []string{
+		"SLBR_EVENT=" + string(d.Event),
+		"SLBR_TIME=" + strconv.FormatInt(d.Time.Unix(), 10),
+		"SLBR_ROOM_ID=" + strconv.FormatUint(uint64(d.RoomId), 10),
+		"SLBR_TITLE=" + d.Title,
+		"SLBR_FILE_PATH=" + d.FilePath,
+		"SLBR_MANIFEST_PATH=" + d.ManifestPath,
+		"SLBR_ERROR=" + d.Error,
+	}
+	if d.Manifest != nil {
+		env = append(env, "SLBR_END_REASON="+d.Manifest.EndReason)
+	}
+	return env
+}
+
+// hookRunner triggers hooks of a task. It is safe to be used from multiple goroutines.
+type hookRunner struct {
+	hooks  []HookConfig
+	logger logging.Logger
+	client *http.Client
+	wg     sync.WaitGroup
+
+	lock   sync.Mutex
+	roomId types.RoomId
+	// title: the latest known title of the live room
+	title string
+}
+
+func newHookRunner(roomId types.RoomId, hooks []HookConfig, logger logging.Logger) *hookRunner {
+	return &hookRunner{
+		roomId: roomId,
+		hooks:  hooks,
+		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+// setTitle updates the title, which is used in events without a title.
+// It reports whether the title is different from the known one. If no title is known yet, it is not a change.
+func (h *hookRunner) setTitle(title string) (changed bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	changed = h.title != "" && h.title != title
+	h.title = title
+	return
+}
+
+// setRoomId updates the room, which is changed in tasks of a uid when the streamer moves to another room.
+func (h *hookRunner) setRoomId(roomId types.RoomId) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.roomId = roomId
+}
+
+// fire triggers hooks which accept the event asynchronously.
+// Time, RoomId and Title of data are filled if they are not set.
+func (h *hookRunner) fire(data HookEventData) {
+	if data.Time.IsZero() {
+		data.Time = time.Now()
+	}
+	h.lock.Lock()
+	data.RoomId = h.roomId
+	if data.Title == "" {
+		data.Title = h.title
+	}
+	h.lock.Unlock()
+	for _, hook := range h.hooks {
+		if !hook.accepts(data.Event) {
+			continue
+		}
+		h.wg.Add(1)
+		go func(hook HookConfig) {
+			defer h.wg.Done()
+			// hooks of the last file are triggered when the task is stopping, so the task context is not used
+			ctx, cancel := context.WithTimeout(context.Background(), hook.timeout())
+			defer cancel()
+			var err error
+			if hook.Command != "" {
+				err = runHookCommand(ctx, hook.Command, &data)
+			} else {
+				err = postWebhook(ctx, h.client, hook.Url, &data)
+			}
+			if err != nil {
+				h.logger.Error("Hook of event %v failed: %v", data.Event, err)
+			} else {
+				h.logger.Debug("Hook of event %v succeeded.", data.Event)
+			}
+		}(hook)
+	}
+}
+
+// Wait waits until all running hooks are finished. It is used in tests.
+func (h *hookRunner) Wait() {
+	h.wg.Wait()
+}
+
+func runHookCommand(ctx context.Context, command string, data *HookEventData) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// values are passed in environment variables, so they cannot be interpreted by the shell
+	cmd.Env = append(os.Environ(), data.hookEnv()...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("command %q: %w, output: %s", command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url string, data *HookEventData) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v: unexpected status %v", url, resp.Status)
+	}
+	return nil
+}
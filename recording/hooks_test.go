@@ -0,0 +1,105 @@
+package recording
+
+import (
+	"encoding/json"
+	"github.com/keuin/slbr/logging"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHookRunner_Command(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command requires sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	logger := logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test")
+	h := newHookRunner(1234, []HookConfig{
+		{
+			Events:  []HookEvent{HookFileFinished},
+			Command: `printf '%s %s %s %s' "$SLBR_EVENT" "$SLBR_ROOM_ID" "$SLBR_TITLE" "$SLBR_FILE_PATH" >> "` + out + `"`,
+		},
+	}, logger)
+	h.setTitle("a'b $c")
+	h.fire(HookEventData{Event: HookLiveEnded})
+	h.fire(HookEventData{Event: HookFileFinished, FilePath: "x.flv"})
+	h.Wait()
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if expected := "file_finished 1234 a'b $c x.flv"; string(b) != expected {
+		t.Fatalf("expected %q, got %q", expected, string(b))
+	}
+}
+
+func TestHookRunner_Webhook(t *testing.T) {
+	ch := make(chan HookEventData, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data HookEventData
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&data) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ch <- data
+	}))
+	defer server.Close()
+
+	logger := logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test")
+	h := newHookRunner(1234, []HookConfig{{Url: server.URL}}, logger)
+	h.fire(HookEventData{Event: HookTaskError, Error: "test"})
+	h.Wait()
+
+	select {
+	case data := <-ch:
+		if data.Event != HookTaskError || data.RoomId != 1234 || data.Error != "test" || data.Time.IsZero() {
+			t.Fatalf("unexpected event: %+v", data)
+		}
+	default:
+		t.Fatalf("the webhook is not called")
+	}
+}
+
+func TestHookRunner_SetTitle(t *testing.T) {
+	h := newHookRunner(1234, nil, logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test"))
+	if h.setTitle("a") {
+		t.Fatalf("the first title is reported as a change")
+	}
+	if h.setTitle("a") {
+		t.Fatalf("the same title is reported as a change")
+	}
+	if !h.setTitle("b") {
+		t.Fatalf("the title change is not reported")
+	}
+}
+
+func TestHookConfig_Timeout(t *testing.T) {
+	cases := []struct {
+		hook    HookConfig
+		timeout time.Duration
+	}{
+		{HookConfig{Command: "true"}, defaultCommandTimeout},
+		{HookConfig{Url: "http://localhost"}, defaultWebhookTimeout},
+		{HookConfig{Command: "true", TimeoutSeconds: 5}, 5 * time.Second},
+	}
+	for _, c := range cases {
+		if timeout := c.hook.timeout(); timeout != c.timeout {
+			t.Fatalf("unexpected timeout of %+v: %v, expected: %v", c.hook, timeout, c.timeout)
+		}
+	}
+}
+
+func TestHookConfig_Validate(t *testing.T) {
+	for _, hook := range []HookConfig{{}, {Command: "true", Url: "http://localhost"}} {
+		if err := (TaskConfig{Hooks: []HookConfig{hook}}).Validate(); err == nil {
+			t.Fatalf("invalid hook is accepted: %+v", hook)
+		}
+	}
+}
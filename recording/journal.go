@@ -0,0 +1,258 @@
+package recording
+
+/*
+In this file we implement crash recovery of recorded files.
+While a file is being written, its task keeps a journal entry in the save directory, keyed by the file name,
+so tasks of the same room never overwrite journal entries of each other.
+If the recorder is killed, the file is not finished: it may end with an incomplete FLV tag,
+has no keyframe index, and keeps the special extension name.
+RecoverFiles is called on startup to finish such files, using journal entries to know which task owned them.
+Files with the special extension name but without journal entries (e.g. recorded by older versions) are recovered too.
+*/
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/common/files"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"github.com/keuin/slbr/flv"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// journalDirName is the directory in save directories which contains journal entries, one file per file being written
+const journalDirName = ".slbr-journal"
+
+// orphanMinAge: files without journal entries are not recovered if they are modified recently,
+// since they may be written by another process
+const orphanMinAge = time.Minute
+
+// journalEntry describes a file being written.
+type journalEntry struct {
+	// RoomId is the room of the task which owns the file
+	RoomId types.RoomId `json:"room_id"`
+	// FilePath is the file being written
+	FilePath string `json:"file_path"`
+	// BasePath is the path without the extension name, which is shared by the danmaku and the manifest
+	BasePath             string    `json:"base_path"`
+	OriginalExtName      string    `json:"original_ext_name"`
+	DanmakuExportFormats []string  `json:"danmaku_export_formats,omitempty"`
+	Manifest             *Manifest `json:"manifest"`
+}
+
+// journalPath returns the path of the journal entry of a file.
+// baseName is the path of the file relative to the save directory, without the extension name.
+// It is hashed, since it may contain subdirectories and be as long as the longest file name.
+func journalPath(saveDir string, baseName string) string {
+	sum := sha1.Sum([]byte(filepath.ToSlash(filepath.Clean(baseName))))
+	return filepath.Join(saveDir, journalDirName, hex.EncodeToString(sum[:])+".json")
+}
+
+func writeJournal(saveDir string, baseName string, entry *journalEntry) error {
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := journalPath(saveDir, baseName)
+	if err := os.MkdirAll(filepath.Dir(p), 0775); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, b)
+}
+
+func removeJournal(saveDir string, baseName string) error {
+	err := os.Remove(journalPath(saveDir, baseName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RecoverFiles finishes files which are left unfinished in save directories, e.g. when the recorder is killed.
+// It must be called before any task writing to the directories is started, TaskManager does it for every task.
+func RecoverFiles(saveDirs []string, logger logging.Logger) {
+	visited := make(map[string]bool)
+	for _, dir := range saveDirs {
+		if dir == "" {
+			dir = "."
+		}
+		dir = filepath.Clean(dir)
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+		recovered := recoverJournal(dir, logger)
+		recoverOrphans(dir, recovered, logger)
+	}
+}
+
+// recoverJournal recovers files in journal entries of the save directory,
+// and returns paths of recovered files.
+func recoverJournal(saveDir string, logger logging.Logger) map[string]bool {
+	recovered := make(map[string]bool)
+	journalDir := filepath.Join(saveDir, journalDirName)
+	entries, err := os.ReadDir(journalDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("Cannot read journal directory \"%v\": %v", journalDir, err)
+		}
+		return recovered
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		p := filepath.Join(journalDir, e.Name())
+		b, err := os.ReadFile(p)
+		if err != nil {
+			logger.Error("Cannot read journal \"%v\": %v", p, err)
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(b, &entry); err != nil || entry.FilePath == "" || entry.Manifest == nil {
+			logger.Error("Invalid journal \"%v\": %v", p, err)
+			_ = os.Remove(p)
+			continue
+		}
+		recovered[filepath.Clean(entry.FilePath)] = true
+		if err := recoverJournalEntry(&entry, logger); err != nil {
+			// keep the journal, so we can try again next time
+			logger.Error("Cannot recover file \"%v\" of room %v: %v", entry.FilePath, entry.RoomId, err)
+			continue
+		}
+		_ = os.Remove(p)
+	}
+	return recovered
+}
+
+func recoverJournalEntry(entry *journalEntry, logger logging.Logger) error {
+	finalPath, err := recoverFile(entry.FilePath, entry.BasePath, entry.OriginalExtName, logger)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warning("File \"%v\" of room %v in journal does not exist, skip.", entry.FilePath, entry.RoomId)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("Recovered file \"%v\" of room %v.", finalPath, entry.RoomId)
+
+	dmPath := files.CombineFileName(entry.BasePath, dmfile.ExtName)
+	if _, err := os.Stat(dmPath); err == nil {
+		exportDanmaku(dmPath, entry.BasePath, entry.DanmakuExportFormats, logger)
+	}
+
+	m := entry.Manifest
+	m.File = filepath.Base(finalPath)
+	m.EndReason = EndReasonRecovered
+	if stat, err := os.Stat(finalPath); err == nil {
+		m.BytesWritten = stat.Size()
+		m.EndTime = stat.ModTime()
+	}
+	manifestPath := files.CombineFileName(entry.BasePath, ManifestExtName)
+	if err := writeManifest(manifestPath, m); err != nil {
+		logger.Error("Cannot write manifest \"%v\": %v", manifestPath, err)
+	}
+	return nil
+}
+
+// recoverOrphans recovers files with the special extension name which are not in journals.
+func recoverOrphans(saveDir string, recovered map[string]bool, logger logging.Logger) {
+	err := filepath.WalkDir(saveDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == saveDir {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == journalDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, "."+SpecialExtName) || recovered[filepath.Clean(p)] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || time.Since(info.ModTime()) < orphanMinAge {
+			return nil
+		}
+		extName, err := detectExtName(p)
+		if err != nil || extName == "" {
+			logger.Warning("Cannot detect the format of unfinished file \"%v\", skip.", p)
+			return nil
+		}
+		finalPath, err := recoverFile(p, strings.TrimSuffix(p, "."+SpecialExtName), extName, logger)
+		if err != nil {
+			logger.Error("Cannot recover file \"%v\": %v", p, err)
+			return nil
+		}
+		logger.Info("Recovered file \"%v\".", finalPath)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Cannot scan save directory \"%v\": %v", saveDir, err)
+	}
+}
+
+// recoverFile repairs FLV files, and renames the file to the original extension name.
+// It returns the path of the recovered file.
+func recoverFile(filePath string, basePath string, originalExtName string, logger logging.Logger) (string, error) {
+	if originalExtName == "flv" {
+		f, err := os.OpenFile(filePath, os.O_RDWR, 0)
+		if err != nil {
+			return "", err
+		}
+		result, err := flv.Repair(f)
+		_ = f.Close()
+		if err != nil {
+			return "", fmt.Errorf("cannot repair FLV file: %w", err)
+		}
+		logger.Info("Repaired FLV file \"%v\": %v bytes truncated, keyframe index written: %v",
+			filePath, result.Truncated, result.Indexed)
+	} else if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+
+	finalPath := files.CombineFileName(basePath, originalExtName)
+	if filepath.Clean(finalPath) == filepath.Clean(filePath) {
+		return finalPath, nil
+	}
+	if _, err := os.Lstat(finalPath); err == nil {
+		return "", fmt.Errorf("file \"%v\" already exists", finalPath)
+	}
+	if err := os.Rename(filePath, finalPath); err != nil {
+		return "", err
+	}
+	return finalPath, nil
+}
+
+// detectExtName returns the extension name of a recorded file by its content, empty if unknown.
+func detectExtName(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	// a TS packet is 188 bytes
+	b := make([]byte, 189)
+	n, _ := f.ReadAt(b, 0)
+	b = b[:n]
+	switch {
+	case n >= 3 && string(b[:3]) == "FLV":
+		return "flv", nil
+	case n >= 8 && string(b[4:8]) == "ftyp":
+		return hlsExtNames["fmp4"], nil
+	case n >= 189 && b[0] == 0x47 && b[188] == 0x47:
+		return hlsExtNames["ts"], nil
+	}
+	return "", nil
+}
@@ -0,0 +1,101 @@
+package recording
+
+import (
+	"encoding/json"
+	"github.com/keuin/slbr/flv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoverFiles(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{
+		FileNameTemplate:                 "{title}",
+		UseSpecialExtNameBeforeFinishing: true,
+	})
+	files.task.RoomId = 1234
+	files.info.RoomId = 1234
+	dir := files.task.Download.SaveDirectory
+
+	w := files.flvWriter()
+	stream := []byte{'F', 'L', 'V', 1, flv.FlagVideo, 0, 0, 0, flv.HeaderSize, 0, 0, 0, 0}
+	if _, err := w.Write(stream); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		tag := flv.Tag{Type: flv.TagVideo, Timestamp: uint32(i * 40), Data: []byte{0x17, 0x01, 0, 0, 0, byte(i)}}
+		if _, err := tag.WriteTo(w); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// the recorder is killed
+	if _, err := files.file.Write([]byte{0x09, 0x00, 0x00}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_ = files.file.Close()
+
+	// an unfinished file of older versions, without journal
+	ts := make([]byte, 188*2)
+	ts[0], ts[188] = 0x47, 0x47
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"orphan", "recent"} {
+		p := filepath.Join(dir, name+"."+SpecialExtName)
+		if err := os.WriteFile(p, ts, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if name == "orphan" {
+			_ = os.Chtimes(p, old, old)
+		}
+	}
+
+	RecoverFiles([]string{dir, dir + "/"}, files.logger)
+
+	for _, name := range []string{"test.flv", "test.json", "orphan.ts", "recent." + SpecialExtName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("file is not recovered: %v", err)
+		}
+	}
+	for _, name := range []string{"test." + SpecialExtName, "orphan." + SpecialExtName, journalPath(".", "test")} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Fatalf("file is not removed: %v", name)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "test.json"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	stat, _ := os.Stat(filepath.Join(dir, "test.flv"))
+	if m.RoomId != 1234 || m.File != "test.flv" || m.EndReason != EndReasonRecovered || m.BytesWritten != stat.Size() {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+}
+
+func TestJournal_SameRoom(t *testing.T) {
+	// e.g. a task of a uid and a task of its room
+	a := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "a"})
+	b := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "b"})
+	b.task.Download.SaveDirectory = a.task.Download.SaveDirectory
+	dir := a.task.Download.SaveDirectory
+	for _, files := range []*recordingFiles{a, b} {
+		files.task.RoomId = 1234
+		if _, err := files.create("flv"); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if journalPath(dir, "a") == journalPath(dir, "b") {
+		t.Fatalf("files share the same journal")
+	}
+	a.Close()
+	if _, err := os.Stat(journalPath(dir, "a")); err == nil {
+		t.Fatalf("the journal of the finished file is not removed")
+	}
+	if _, err := os.Stat(journalPath(dir, "b")); err != nil {
+		t.Fatalf("the journal of the other task is removed: %v", err)
+	}
+	b.Close()
+}
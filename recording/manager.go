@@ -0,0 +1,387 @@
+package recording
+
+/*
+In this file we implement the task manager,
+which allows adding, stopping and removing tasks at runtime,
+and reconciling running tasks with a new config.
+Unfinished files in a save directory are recovered before the first task of the directory is started.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/logging"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrTaskExists   = errors.New("task already exists")
+	ErrTaskNotFound = errors.New("task not found")
+)
+
+type managedTask struct {
+	task *RunningTask
+	// stop cancels the context of the task
+	stop context.CancelFunc
+	// done is closed when the task is stopped
+	done chan struct{}
+}
+
+// TaskManager manages a set of tasks, keyed by TaskKey.
+// All methods are safe to be called from multiple goroutines.
+type TaskManager struct {
+	ctx       context.Context
+	newLogger func(config TaskConfig) logging.Logger
+	lock      sync.Mutex
+	tasks     map[TaskKey]*managedTask
+	// order keeps the order in which tasks are added
+	order []TaskKey
+	wg    sync.WaitGroup
+	// newTaskHooks are called with every task created by this manager before it is started
+	newTaskHooks []func(t *RunningTask)
+	// reconciled are tasks managed by Reconcile.
+	// Tasks added by Add are not touched by Reconcile.
+	reconciled map[TaskKey]bool
+	// recoveredDirs are absolute paths of save directories which are recovered, see RecoverFiles
+	recoveredDirs map[string]bool
+}
+
+// NewTaskManager creates a task manager. All tasks are stopped when ctx is cancelled.
+// newLogger creates the logger of each task.
+func NewTaskManager(ctx context.Context, newLogger func(config TaskConfig) logging.Logger) *TaskManager {
+	return &TaskManager{
+		ctx:           ctx,
+		newLogger:     newLogger,
+		tasks:         make(map[TaskKey]*managedTask),
+		reconciled:    make(map[TaskKey]bool),
+		recoveredDirs: make(map[string]bool),
+	}
+}
+
+// OnNewTask registers a hook which is called with every task created by this manager
+// before it is started. This can be used to register danmaku handlers.
+func (m *TaskManager) OnNewTask(hook func(t *RunningTask)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.newTaskHooks = append(m.newTaskHooks, hook)
+}
+
+// Add creates a task and starts it.
+func (m *TaskManager) Add(config TaskConfig) error {
+	key := config.Key()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.tasks[key]; exists {
+		return fmt.Errorf("%w: %v", ErrTaskExists, key)
+	}
+	mt, err := m.startLocked(config)
+	if err != nil {
+		return err
+	}
+	m.tasks[key] = mt
+	m.order = append(m.order, key)
+	return nil
+}
+
+// Start restarts a stopped task with the same config.
+func (m *TaskManager) Start(key TaskKey) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mt, ok := m.tasks[key]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrTaskNotFound, key)
+	}
+	select {
+	case <-mt.done:
+	default:
+		return ErrTaskIsAlreadyStarted
+	}
+	newTask, err := m.startLocked(mt.task.TaskConfig)
+	if err != nil {
+		return err
+	}
+	m.tasks[key] = newTask
+	return nil
+}
+
+func (m *TaskManager) startLocked(config TaskConfig) (*managedTask, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("task manager is stopped: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config of task %v: %w", config.Key(), err)
+	}
+	logger := m.newLogger(config)
+	m.recoverSaveDirLocked(config.Download.SaveDirectory, logger)
+	ctx, cancel := context.WithCancel(m.ctx)
+	done := make(chan struct{})
+	task := NewRunningTask(
+		config,
+		ctx,
+		func() {},
+		func() {
+			close(done)
+			m.wg.Done()
+		},
+		logger,
+	)
+	for _, hook := range m.newTaskHooks {
+		hook(&task)
+	}
+	m.wg.Add(1)
+	if err := task.StartTask(); err != nil {
+		m.wg.Done()
+		cancel()
+		return nil, err
+	}
+	return &managedTask{
+		task: &task,
+		stop: cancel,
+		done: done,
+	}, nil
+}
+
+// recoverSaveDirLocked recovers unfinished files in the save directory, if it is not recovered yet.
+// Save directories are only recovered once, since files in them are written by running tasks later.
+func (m *TaskManager) recoverSaveDirLocked(dir string, logger logging.Logger) {
+	if dir == "" {
+		dir = "."
+	}
+	// the same directory may be given in different forms
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	if m.recoveredDirs[dir] {
+		return
+	}
+	m.recoveredDirs[dir] = true
+	RecoverFiles([]string{dir}, logger)
+}
+
+// Stop stops a task and waits until it is stopped. The stopped task is kept and can be started again.
+func (m *TaskManager) Stop(key TaskKey) error {
+	m.lock.Lock()
+	mt, ok := m.tasks[key]
+	m.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrTaskNotFound, key)
+	}
+	mt.stop()
+	<-mt.done
+	return nil
+}
+
+// Remove stops a task, waits until it is stopped and removes it from this manager.
+func (m *TaskManager) Remove(key TaskKey) error {
+	m.lock.Lock()
+	mt, ok := m.tasks[key]
+	if ok {
+		delete(m.tasks, key)
+		delete(m.reconciled, key)
+		for i, id := range m.order {
+			if id == key {
+				m.order = append(m.order[:i], m.order[i+1:]...)
+				break
+			}
+		}
+	}
+	m.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrTaskNotFound, key)
+	}
+	mt.stop()
+	<-mt.done
+	return nil
+}
+
+// Task returns the information of a task.
+func (m *TaskManager) Task(key TaskKey) (TaskInfo, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mt, ok := m.tasks[key]
+	if !ok {
+		return TaskInfo{}, false
+	}
+	return mt.task.Info(), true
+}
+
+// Tasks returns the information of all tasks, in the order they are added.
+func (m *TaskManager) Tasks() []TaskInfo {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	infos := make([]TaskInfo, 0, len(m.order))
+	for _, id := range m.order {
+		infos = append(infos, m.tasks[id].task.Info())
+	}
+	return infos
+}
+
+// Wait blocks until all tasks are stopped.
+func (m *TaskManager) Wait() {
+	m.wg.Wait()
+}
+
+// Metrics returns the metrics of all tasks, in the order they are added.
+func (m *TaskManager) Metrics() []TaskMetrics {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	metrics := make([]TaskMetrics, 0, len(m.order))
+	for _, id := range m.order {
+		metrics = append(metrics, m.tasks[id].task.Metrics())
+	}
+	return metrics
+}
+
+// ReconcileResult describes the changes made by Reconcile.
+type ReconcileResult struct {
+	Added     []TaskKey
+	Removed   []TaskKey
+	Restarted []TaskKey
+}
+
+// Reconcile makes the tasks managed by previous calls to Reconcile match the given configs.
+// New tasks are started, removed tasks are stopped gracefully,
+// and only tasks whose config is changed are restarted. Other tasks are not interrupted.
+// A task stopped with Stop, or stopped by an unrecoverable error, stays stopped if its config is not changed,
+// it can be started again with Start. A stopped task whose config is changed is started with the new config.
+// Tasks added by Add are left untouched, unless they are present in configs.
+// Failures do not abort the reconciliation, they are joined into the returned error.
+func (m *TaskManager) Reconcile(configs []TaskConfig) (result ReconcileResult, err error) {
+	m.lock.Lock()
+	current := make(map[TaskKey]TaskConfig, len(m.reconciled))
+	for id := range m.reconciled {
+		if mt, ok := m.tasks[id]; ok {
+			current[id] = mt.task.TaskConfig
+		}
+	}
+	unmanaged := make(map[TaskKey]TaskConfig)
+	for id, mt := range m.tasks {
+		if !m.reconciled[id] {
+			unmanaged[id] = mt.task.TaskConfig
+		}
+	}
+	m.lock.Unlock()
+
+	diff, err := diffTasks(current, unmanaged, configs)
+	var failures []error
+	if err != nil {
+		failures = append(failures, err)
+	}
+	for _, id := range diff.remove {
+		if err := m.Remove(id); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		result.Removed = append(result.Removed, id)
+	}
+	for _, c := range diff.restart {
+		if err := m.replace(c); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		result.Restarted = append(result.Restarted, c.Key())
+	}
+	for _, c := range diff.add {
+		err := m.Add(c)
+		if errors.Is(err, ErrTaskExists) {
+			// added by someone else in the meantime, just take it over
+			err = m.replace(c)
+		}
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		result.Added = append(result.Added, c.Key())
+	}
+	for _, c := range diff.keep {
+		m.markReconciled(c.Key())
+	}
+	return result, errors.Join(failures...)
+}
+
+func (m *TaskManager) markReconciled(key TaskKey) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.tasks[key]; ok {
+		m.reconciled[key] = true
+	}
+}
+
+// replace stops a task and starts it again with the new config.
+// The task is added if it does not exist.
+func (m *TaskManager) replace(config TaskConfig) error {
+	key := config.Key()
+	m.lock.Lock()
+	mt, ok := m.tasks[key]
+	m.lock.Unlock()
+	if ok {
+		mt.stop()
+		<-mt.done
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if cur, ok := m.tasks[key]; ok && cur != mt {
+		// replaced by someone else in the meantime
+		return fmt.Errorf("%w: %v", ErrTaskExists, key)
+	}
+	newTask, err := m.startLocked(config)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		m.order = append(m.order, key)
+	}
+	m.tasks[key] = newTask
+	m.reconciled[key] = true
+	return nil
+}
+
+type taskDiff struct {
+	add     []TaskConfig
+	remove  []TaskKey
+	restart []TaskConfig
+	keep    []TaskConfig
+}
+
+// diffTasks compares the configs of current tasks with new configs by task key.
+// current are tasks managed by Reconcile, unmanaged are other tasks, which are never removed.
+// If a task appears more than once in configs, only the first one is used.
+func diffTasks(
+	current map[TaskKey]TaskConfig,
+	unmanaged map[TaskKey]TaskConfig,
+	configs []TaskConfig,
+) (diff taskDiff, err error) {
+	seen := make(map[TaskKey]bool, len(configs))
+	var failures []error
+	for _, c := range configs {
+		key := c.Key()
+		if seen[key] {
+			failures = append(failures, fmt.Errorf("duplicated task %v is ignored", key))
+			continue
+		}
+		seen[key] = true
+		old, ok := current[key]
+		if !ok {
+			old, ok = unmanaged[key]
+		}
+		if !ok {
+			diff.add = append(diff.add, c)
+		} else if reflect.DeepEqual(old, c) {
+			diff.keep = append(diff.keep, c)
+		} else {
+			diff.restart = append(diff.restart, c)
+		}
+	}
+	for id := range current {
+		if !seen[id] {
+			diff.remove = append(diff.remove, id)
+		}
+	}
+	sort.Slice(diff.remove, func(i, j int) bool { return diff.remove[i] < diff.remove[j] })
+	return diff, errors.Join(failures...)
+}
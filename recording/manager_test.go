@@ -0,0 +1,112 @@
+package recording
+
+import (
+	"context"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffTasks(t *testing.T) {
+	config := func(roomId types.RoomId, saveDir string) TaskConfig {
+		return TaskConfig{
+			RoomId:    roomId,
+			Transport: DefaultTransportConfig(),
+			Download:  DownloadConfig{SaveDirectory: saveDir},
+		}
+	}
+	current := map[TaskKey]TaskConfig{
+		"1": config(1, "a"),
+		"2": config(2, "a"),
+		"3": config(3, "a"),
+	}
+	unmanaged := map[TaskKey]TaskConfig{
+		"10": config(10, "a"),
+		"11": config(11, "a"),
+	}
+	diff, err := diffTasks(current, unmanaged, []TaskConfig{
+		config(1, "a"),  // unchanged
+		config(2, "b"),  // changed
+		config(4, "a"),  // new
+		config(10, "a"), // taken over from unmanaged tasks
+		config(4, "c"),  // duplicated
+		{Uid: 4, Transport: DefaultTransportConfig()}, // a streamer is not the same task as a room
+	})
+	if err == nil {
+		t.Fatalf("duplicated room is not reported")
+	}
+	expected := taskDiff{
+		add:     []TaskConfig{config(4, "a"), {Uid: 4, Transport: DefaultTransportConfig()}},
+		remove:  []TaskKey{"3"},
+		restart: []TaskConfig{config(2, "b")},
+		keep:    []TaskConfig{config(1, "a"), config(10, "a")},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("unexpected diff: %+v, expected: %+v", diff, expected)
+	}
+}
+
+func TestParseTaskKey(t *testing.T) {
+	for input, expected := range map[string]TaskKey{
+		"1234":     TaskConfig{RoomId: 1234}.Key(),
+		"01234":    "1234",
+		"uid:5678": TaskConfig{Uid: 5678}.Key(),
+	} {
+		key, err := ParseTaskKey(input)
+		if err != nil {
+			t.Fatalf("ParseTaskKey(%q): %v", input, err)
+		}
+		if key != expected {
+			t.Fatalf("ParseTaskKey(%q): got %v, expected %v", input, key, expected)
+		}
+	}
+	for _, input := range []string{"", "0", "abc", "uid:", "uid:0", "uid:abc", "-1"} {
+		if key, err := ParseTaskKey(input); err == nil {
+			t.Fatalf("ParseTaskKey(%q) should fail, got %v", input, key)
+		}
+	}
+}
+
+func TestTaskManager_RecoverSaveDir(t *testing.T) {
+	logger := logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test")
+	m := NewTaskManager(context.Background(), func(TaskConfig) logging.Logger { return logger })
+	dir := t.TempDir()
+	unfinished := func(name string) string {
+		p := filepath.Join(dir, name+"."+SpecialExtName)
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		err := writeJournal(dir, name, &journalEntry{
+			RoomId:          1234,
+			FilePath:        p,
+			BasePath:        filepath.Join(dir, name),
+			OriginalExtName: "ts",
+			Manifest:        &Manifest{RoomId: 1234},
+		})
+		if err != nil {
+			t.Fatalf("writeJournal: %v", err)
+		}
+		return p
+	}
+
+	p := unfinished("a")
+	m.recoverSaveDirLocked(dir, logger)
+	if _, err := os.Stat(filepath.Join(dir, "a.ts")); err != nil {
+		t.Fatalf("the file is not recovered: %v", err)
+	}
+	if _, err := os.Stat(p); err == nil {
+		t.Fatalf("the unfinished file is kept")
+	}
+
+	// files written by running tasks are not touched, even if the directory is given in another form
+	p = unfinished("b")
+	m.recoverSaveDirLocked(dir+string(filepath.Separator)+".", logger)
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("the file of a running task is recovered: %v", err)
+	}
+}
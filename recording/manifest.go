@@ -0,0 +1,141 @@
+package recording
+
+/*
+In this file we write manifests of recorded files.
+A manifest is a JSON file beside the video file with the same base name,
+which describes the live room, the stream, and why the file is ended.
+It is written when the file is finished, so downstream tools can wait for it.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/types"
+	"io"
+	"os"
+	"time"
+)
+
+// ManifestExtName is the extension name of manifest files.
+const ManifestExtName = "json"
+
+// Reasons why a file is ended. Recording errors are represented by names of their types, e.g. "stream_copy".
+const (
+	// EndReasonEOF means the stream is closed by the server normally
+	EndReasonEOF = "eof"
+	// EndReasonCanceled means the task is stopped
+	EndReasonCanceled = "canceled"
+	// EndReasonSplit means the file reaches the split limit, or the stream parameters are changed
+	EndReasonSplit = "split"
+	// EndReasonRecovered means the recorder was killed, and the file is recovered on the next startup
+	EndReasonRecovered = "recovered"
+	// EndReasonUnknown means the file is ended by an unexpected error
+	EndReasonUnknown = "unknown"
+)
+
+// Manifest describes a recorded file.
+type Manifest struct {
+	RoomId     uint64 `json:"room_id"`
+	ShortId    int    `json:"short_id"`
+	UID        int    `json:"uid"`
+	Name       string `json:"name,omitempty"`
+	Title      string `json:"title"`
+	Area       string `json:"area"`
+	ParentArea string `json:"parent_area"`
+	// File is the name of the video file, in the same directory as the manifest
+	File      string    `json:"file"`
+	Part      int       `json:"part"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// Stream is the stream when the file is created
+	Stream       ManifestStream `json:"stream"`
+	BytesWritten int64          `json:"bytes_written"`
+	// EndReason is one of EndReason* or the name of an error type
+	EndReason string `json:"end_reason"`
+	Error     string `json:"error,omitempty"`
+	// Reconnects are reconnections which continue this file
+	Reconnects []ManifestReconnect `json:"reconnects"`
+}
+
+// ManifestStream is the source of a recorded file.
+type ManifestStream struct {
+	Host          string               `json:"host"`
+	QualityNumber int                  `json:"qn"`
+	Protocol      types.StreamProtocol `json:"protocol,omitempty"`
+	Format        string               `json:"format,omitempty"`
+	Codec         types.StreamCodec    `json:"codec,omitempty"`
+}
+
+// ManifestReconnect is a reconnection in the middle of a file.
+type ManifestReconnect struct {
+	Time time.Time `json:"time"`
+	// Reason is why the previous connection is ended
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+	// Stream is the stream after reconnecting
+	Stream ManifestStream `json:"stream"`
+}
+
+func newManifestStream(stream types.StreamingUrlInfo) ManifestStream {
+	return ManifestStream{
+		Host:          streamHost(stream),
+		QualityNumber: stream.QualityNumber,
+		Protocol:      stream.Protocol,
+		Format:        stream.Format,
+		Codec:         stream.Codec,
+	}
+}
+
+// streamEnd is the result of a stream copy.
+type streamEnd struct {
+	reason string
+	err    error
+}
+
+// newStreamEnd converts the result of a stream copy to an end reason.
+// If ctx is cancelled, its cause is used, e.g. the live is ended.
+func newStreamEnd(ctx context.Context, err error) streamEnd {
+	if ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	var taskErr errs.TaskError
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		return streamEnd{reason: EndReasonEOF}
+	case errors.As(err, &taskErr):
+		return streamEnd{reason: taskErr.Type().Name(), err: err}
+	case errors.Is(err, context.Canceled):
+		return streamEnd{reason: EndReasonCanceled}
+	default:
+		return streamEnd{reason: EndReasonUnknown, err: err}
+	}
+}
+
+func (e streamEnd) errorString() string {
+	if e.err == nil {
+		return ""
+	}
+	return e.err.Error()
+}
+
+// writeManifest writes the manifest to filePath. The file is replaced atomically,
+// so readers never see an incomplete manifest.
+func writeManifest(filePath string, m *Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, b)
+}
+
+// writeFileAtomic writes to a temporary file, then replaces filePath with it.
+func writeFileAtomic(filePath string, b []byte) error {
+	tmp := filePath + ".tmp"
+	err := os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
@@ -0,0 +1,150 @@
+package recording
+
+/*
+In this file we collect metrics of tasks,
+which are exported by the HTTP API in Prometheus format.
+*/
+
+import (
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"github.com/keuin/slbr/types"
+	"time"
+)
+
+// TaskMetrics is a snapshot of the metrics of a task.
+// Counters are reset when the task is recreated.
+type TaskMetrics struct {
+	RoomId types.RoomId
+	Uid    uint64
+	Status TaskStatus
+	// Recording reports if a file is being recorded
+	Recording bool
+	// BytesWritten is the total number of bytes recorded
+	BytesWritten int64
+	// RecordingDuration is how long current file has been recorded, zero if not recording
+	RecordingDuration time.Duration
+	// RecordedDuration is the total duration of all recorded files, including current one
+	RecordedDuration time.Duration
+	// Retries is how many times the task is retried, by error type
+	Retries map[errs.Type]int64
+	// Messages is how many server messages are received, by command
+	Messages          map[string]int64
+	HeartbeatFailures int64
+	// Viewers is the last number reported by WATCHED_CHANGE messages
+	Viewers int64
+}
+
+// Metrics returns the metrics of this task. It is safe to call Metrics from any goroutine.
+func (t *RunningTask) Metrics() TaskMetrics {
+	s := t.state
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m := TaskMetrics{
+		RoomId:            t.roomIdLocked(),
+		Uid:               t.Uid,
+		Status:            s.status,
+		Recording:         s.currentFile != "",
+		BytesWritten:      s.bytesWritten.Load(),
+		RecordedDuration:  s.recordedDuration,
+		Retries:           make(map[errs.Type]int64, len(s.retries)),
+		Messages:          make(map[string]int64, len(s.messages)),
+		HeartbeatFailures: s.heartbeatFailures.Load(),
+		Viewers:           s.viewers.Load(),
+	}
+	if !s.recordingStart.IsZero() {
+		m.RecordingDuration = time.Since(s.recordingStart)
+		m.RecordedDuration += m.RecordingDuration
+	}
+	for k, v := range s.retries {
+		m.Retries[k] = v
+	}
+	for k, v := range s.messages {
+		m.Messages[k] = v
+	}
+	return m
+}
+
+func (s *taskState) addRetry(err errs.TaskError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.retries == nil {
+		s.retries = make(map[errs.Type]int64)
+	}
+	s.retries[err.Type()]++
+}
+
+func (s *taskState) addMessage(cmd string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.messages == nil {
+		s.messages = make(map[string]int64)
+	}
+	s.messages[cmd]++
+}
+
+// metricsHandler counts server messages and records the number of viewers.
+type metricsHandler struct {
+	state *taskState
+}
+
+func (h *metricsHandler) OnDanMu(dmmsg.DanMuMessage) {
+	h.state.addMessage(dmmsg.CmdDanMu)
+}
+
+func (h *metricsHandler) OnInteractWord(dmmsg.InteractWordMessage) {
+	h.state.addMessage(dmmsg.CmdInteractWord)
+}
+
+func (h *metricsHandler) OnWatchedChange(msg dmmsg.WatchedChangeMessage) {
+	h.state.addMessage(dmmsg.CmdWatchedChange)
+	h.state.viewers.Store(int64(msg.Num))
+}
+
+func (h *metricsHandler) OnSendGift(dmmsg.SendGiftMessage) {
+	h.state.addMessage(dmmsg.CmdSendGift)
+}
+
+func (h *metricsHandler) OnComboSend(dmmsg.ComboSendMessage) {
+	h.state.addMessage(dmmsg.CmdComboSend)
+}
+
+func (h *metricsHandler) OnSuperChat(dmmsg.SuperChatMessage) {
+	h.state.addMessage(dmmsg.CmdSuperChat)
+}
+
+func (h *metricsHandler) OnGuardBuy(dmmsg.GuardBuyMessage) {
+	h.state.addMessage(dmmsg.CmdGuardBuy)
+}
+
+func (h *metricsHandler) OnRoomChange(dmmsg.RoomChangeMessage) {
+	h.state.addMessage(dmmsg.CmdRoomChange)
+}
+
+func (h *metricsHandler) OnPreparing(dmmsg.PreparingMessage) {
+	h.state.addMessage(dmmsg.CmdPreparing)
+}
+
+func (h *metricsHandler) OnLive(dmmsg.LiveMessage) {
+	h.state.addMessage(dmmsg.CmdLive)
+}
+
+func (h *metricsHandler) OnRoomBlock(dmmsg.RoomBlockMessage) {
+	h.state.addMessage(dmmsg.CmdRoomBlock)
+}
+
+func (h *metricsHandler) OnWarning(msg dmmsg.WarningMessage) {
+	if msg.IsCutOff() {
+		h.state.addMessage(dmmsg.CmdCutOff)
+	} else {
+		h.state.addMessage(dmmsg.CmdWarning)
+	}
+}
+
+func (h *metricsHandler) OnOnlineRankCount(dmmsg.OnlineRankCountMessage) {
+	h.state.addMessage(dmmsg.CmdOnlineRankCount)
+}
+
+func (h *metricsHandler) OnUnknown(cmd string, _ []byte) {
+	h.state.addMessage(cmd)
+}
@@ -0,0 +1,372 @@
+package recording
+
+/*
+In this file we manage files of a recording.
+A file is created when the stream is started, and finished when the stream is interrupted,
+or when a new file is started (splitting). FLV files are continued across reconnects if possible.
+Finishing a file writes the keyframe index of FLV files, renames the file to the real extension name,
+exports captured danmaku, and writes the manifest.
+*/
+
+import (
+	"context"
+	"fmt"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/common/files"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"github.com/keuin/slbr/flv"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// recordingFiles creates and finishes video files of a task. It is not thread-safe.
+type recordingFiles struct {
+	task       *TaskConfig
+	state      *taskState
+	dmRecorder *danmakuRecorder
+	hooks      *hookRunner
+	storage    *storageGuard
+	logger     logging.Logger
+	// information of the live room, which is used in file names
+	info fileNameInfo
+	// part is the index of the current file, it is used in file names if files are split
+	part int
+	// the stream being copied, and how the last stream copy is ended
+	stream    types.StreamingUrlInfo
+	streamEnd streamEnd
+
+	// the current file, nil if no file is being written
+	file            *os.File
+	baseName        string
+	extName         string
+	originalExtName string
+	dmOpened        bool
+	manifest        *Manifest
+
+	// flv normalizes FLV streams and writes to the current file, nil if the current file is not written by it
+	flv *flv.Writer
+	// lastFlvWrite: when flv wrote to the current file last time
+	lastFlvWrite time.Time
+}
+
+func newRecordingFiles(
+	task *TaskConfig,
+	state *taskState,
+	dmRecorder *danmakuRecorder,
+	hooks *hookRunner,
+	logger logging.Logger,
+) *recordingFiles {
+	return &recordingFiles{
+		task:       task,
+		state:      state,
+		dmRecorder: dmRecorder,
+		hooks:      hooks,
+		storage:    newStorageGuard(task, logger),
+		logger:     logger,
+	}
+}
+
+// flvWriter returns the FLV writer which accepts a new FLV stream.
+// The current file is continued if the new stream is compatible with it.
+func (r *recordingFiles) flvWriter() *flv.Writer {
+	if r.flv != nil {
+		// the gap until the next tag is removed from the video, so the danmaku clock is paused,
+		// it is resumed by flvFileWriter
+		r.dmRecorder.Pause(r.lastFlvWrite)
+		r.flv.Reset()
+		return r.flv
+	}
+	r.finish(r.streamEnd)
+	r.flv = flv.NewWriter(func() (io.Writer, error) {
+		if r.file != nil {
+			r.logger.Info("Splitting the FLV stream, starting a new file...")
+		}
+		w, err := r.create("flv")
+		if err != nil {
+			return nil, errs.Wrap(errs.FileCreation, err)
+		}
+		return &flvFileWriter{w: w, files: r}, nil
+	})
+	r.flv.SetSplitLimit(r.task.Download.splitLimit())
+	return r.flv
+}
+
+// flvFileWriter writes the output of the FLV writer to the current file.
+// It records the time of writes, and resumes the danmaku clock paused by flvWriter.
+type flvFileWriter struct {
+	w     io.Writer
+	files *recordingFiles
+}
+
+func (w *flvFileWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	w.files.lastFlvWrite = now
+	w.files.dmRecorder.Resume(now)
+	return w.w.Write(p)
+}
+
+// create finishes the current file and creates a new one.
+// originalExtName is the real extension name, which may be replaced before the file is finished.
+func (r *recordingFiles) create(originalExtName string) (io.Writer, error) {
+	r.finish(streamEnd{reason: EndReasonSplit})
+
+	extName := originalExtName
+	if r.task.Download.UseSpecialExtNameBeforeFinishing {
+		extName = SpecialExtName
+	}
+	if err := r.storage.beforeCreate(); err != nil {
+		return nil, err
+	}
+	r.part++
+	baseName, err := r.nextBaseName(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	saveDir := r.task.Download.SaveDirectory
+	filePath := path.Join(saveDir, files.CombineFileName(baseName, extName))
+
+	// the template may contain subdirectories
+	err = os.MkdirAll(path.Dir(filePath), 0775)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create save directory: %w", err)
+	}
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r.file = f
+	r.baseName = baseName
+	r.extName = extName
+	r.originalExtName = originalExtName
+	r.manifest = &Manifest{
+		RoomId:     r.info.RoomId,
+		ShortId:    r.info.ShortId,
+		UID:        r.info.UID,
+		Name:       r.info.Name,
+		Title:      r.info.Title,
+		Area:       r.info.Area,
+		ParentArea: r.info.ParentArea,
+		Part:       r.part,
+		StartTime:  time.Now(),
+		Stream:     newManifestStream(r.stream),
+		Reconnects: []ManifestReconnect{},
+	}
+	err = writeJournal(saveDir, baseName, &journalEntry{
+		RoomId:               r.task.RoomId,
+		FilePath:             filePath,
+		BasePath:             path.Join(saveDir, baseName),
+		OriginalExtName:      originalExtName,
+		DanmakuExportFormats: r.task.Download.DanmakuExportFormats,
+		Manifest:             r.manifest,
+	})
+	if err != nil {
+		// the file can still be recovered without the journal, if it has the special extension name
+		r.logger.Error("Cannot write journal: %v", err)
+	}
+	r.state.setCurrentFile(filePath)
+	r.logger.Info("Recording live stream to file \"%v\"...", filePath)
+	r.hooks.fire(HookEventData{
+		Event:    HookRecordingStarted,
+		Title:    r.info.Title,
+		FilePath: filePath,
+	})
+	// danmaku offsets are relative to the time when the video file is created,
+	// gaps removed from FLV files are excluded, see flvWriter
+	dmPath := path.Join(saveDir, files.CombineFileName(baseName, dmfile.ExtName))
+	if err := r.dmRecorder.Open(dmPath, time.Now()); err != nil {
+		// the video is more important, just go on recording
+		r.logger.Error("Cannot save danmaku: %v", err)
+	} else {
+		r.dmOpened = true
+	}
+	var w io.Writer = &countingWriter{w: f, n: &r.state.bytesWritten}
+	if r.task.Storage.StopFreeBytes > 0 {
+		w = &guardedWriter{w: w, guard: r.storage}
+	}
+	return w, nil
+}
+
+// nextBaseName returns the path of the next file relative to the save directory, without the extension name.
+func (r *recordingFiles) nextBaseName(t time.Time) (string, error) {
+	template := r.task.Download.FileNameTemplate
+	if template == "" {
+		template = defaultFileNameTemplate
+	}
+	sanitizer, err := r.task.Download.fileNameSanitizer()
+	if err != nil {
+		return "", err
+	}
+	name, err := expandFileName(template, r.info, t, r.part, sanitizer)
+	if err != nil {
+		return "", err
+	}
+	maxDuration, maxSize := r.task.Download.splitLimit()
+	if (maxDuration > 0 || maxSize > 0) && !strings.Contains(template, "{part}") {
+		// parts must have different names
+		name = fmt.Sprintf("%s_part%d", name, r.part)
+	}
+	// different titles may result in the same name after sanitizing, never overwrite existing files
+	return files.UniqueName(name, r.isUsed), nil
+}
+
+// isUsed reports whether any file of the recording with the base name exists.
+func (r *recordingFiles) isUsed(baseName string) bool {
+	extNames := append([]string{SpecialExtName, dmfile.ExtName, ManifestExtName}, streamExtNames...)
+	extNames = append(extNames, r.task.Download.DanmakuExportFormats...)
+	for _, ext := range extNames {
+		ext = strings.TrimPrefix(ext, ".")
+		name := path.Join(r.task.Download.SaveDirectory, files.CombineFileName(baseName, ext))
+		if _, err := os.Lstat(name); err == nil || !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// startStream is called before a stream is copied. If the current file is continued,
+// the reconnection is recorded in its manifest.
+func (r *recordingFiles) startStream(stream types.StreamingUrlInfo) {
+	r.stream = stream
+	if r.file != nil {
+		r.manifest.Reconnects = append(r.manifest.Reconnects, ManifestReconnect{
+			Time:   time.Now(),
+			Reason: r.streamEnd.reason,
+			Error:  r.streamEnd.errorString(),
+			Stream: newManifestStream(stream),
+		})
+	}
+}
+
+// endStream is called after a stream copy is ended, err is the result of the copy.
+func (r *recordingFiles) endStream(ctx context.Context, err error) {
+	r.streamEnd = newStreamEnd(ctx, err)
+}
+
+// finish closes the current file, renames it to the real extension name, exports danmaku,
+// and writes the manifest with the end reason. It is a no-op if no file is being written.
+func (r *recordingFiles) finish(end streamEnd) {
+	if r.file == nil {
+		return
+	}
+	saveDir := r.task.Download.SaveDirectory
+	if r.flv != nil {
+		// make the file seekable
+		if err := r.flv.WriteIndex(r.file); err != nil {
+			r.logger.Error("Cannot write keyframe index to file \"%v\": %v", r.file.Name(), err)
+		}
+	}
+	if stat, err := r.file.Stat(); err == nil {
+		r.manifest.BytesWritten = stat.Size()
+	}
+	_ = r.file.Close()
+	r.file = nil
+	r.state.setCurrentFile("")
+
+	// rename the extension name to originalExtName when finish writing
+	if r.extName != r.originalExtName {
+		from := path.Join(saveDir, files.CombineFileName(r.baseName, r.extName))
+		to := path.Join(saveDir, files.CombineFileName(r.baseName, r.originalExtName))
+		err := os.Rename(from, to)
+		if err != nil {
+			r.logger.Error("Cannot rename %v to %v: %v", from, to, err)
+		} else {
+			r.logger.Info("Rename file \"%s\" to \"%s\".", from, to)
+		}
+	}
+
+	r.dmRecorder.Close()
+	if r.dmOpened {
+		r.dmOpened = false
+		dmPath := path.Join(saveDir, files.CombineFileName(r.baseName, dmfile.ExtName))
+		exportDanmaku(dmPath, path.Join(saveDir, r.baseName), r.task.Download.DanmakuExportFormats, r.logger)
+	}
+
+	m := r.manifest
+	r.manifest = nil
+	m.File = path.Base(files.CombineFileName(r.baseName, r.originalExtName))
+	m.EndTime = time.Now()
+	m.EndReason = end.reason
+	m.Error = end.errorString()
+	manifestPath := path.Join(saveDir, files.CombineFileName(r.baseName, ManifestExtName))
+	if err := writeManifest(manifestPath, m); err != nil {
+		r.logger.Error("Cannot write manifest \"%v\": %v", manifestPath, err)
+		manifestPath = ""
+	}
+	if err := removeJournal(saveDir, r.baseName); err != nil {
+		r.logger.Error("Cannot remove journal: %v", err)
+	}
+	r.hooks.fire(HookEventData{
+		Event:        HookFileFinished,
+		Title:        m.Title,
+		FilePath:     path.Join(saveDir, files.CombineFileName(r.baseName, r.originalExtName)),
+		ManifestPath: manifestPath,
+		Manifest:     m,
+	})
+}
+
+// Close finishes the current file. The next stream will be saved to a new file.
+func (r *recordingFiles) Close() {
+	r.finish(r.streamEnd)
+	r.flv = nil
+}
+
+// splitWriter writes an HLS stream to files, a new file is started when the current one reaches the split limit.
+// The stream is only split between segments, see bilibili.HlsSplitter.
+type splitWriter struct {
+	files           *recordingFiles
+	originalExtName string
+	maxDuration     time.Duration
+	maxSize         int64
+
+	out   io.Writer
+	size  int64
+	start time.Time
+}
+
+// newSplitWriter creates the first file and returns a writer which splits the stream between HLS segments.
+// If no limit is set, the file is returned directly.
+func (r *recordingFiles) newSplitWriter(originalExtName string) (io.Writer, error) {
+	out, err := r.create(originalExtName)
+	if err != nil {
+		return nil, err
+	}
+	maxDuration, maxSize := r.task.Download.splitLimit()
+	if maxDuration == 0 && maxSize == 0 {
+		return out, nil
+	}
+	return &splitWriter{
+		files:           r,
+		originalExtName: originalExtName,
+		maxDuration:     maxDuration,
+		maxSize:         maxSize,
+		out:             out,
+		start:           time.Now(),
+	}, nil
+}
+
+// NextSegment implements bilibili.HlsSplitter.
+// A file may exceed the size limit by a segment, since segments are never split.
+func (s *splitWriter) NextSegment() (newFile bool, err error) {
+	if !(s.maxSize > 0 && s.size >= s.maxSize) && !(s.maxDuration > 0 && time.Since(s.start) >= s.maxDuration) {
+		return false, nil
+	}
+	s.files.logger.Info("Splitting the stream, starting a new file...")
+	s.out, err = s.files.create(s.originalExtName)
+	if err != nil {
+		return false, errs.Wrap(errs.FileCreation, err)
+	}
+	s.size = 0
+	s.start = time.Now()
+	return true, nil
+}
+
+func (s *splitWriter) Write(p []byte) (n int, err error) {
+	n, err = s.out.Write(p)
+	s.size += int64(n)
+	return n, err
+}
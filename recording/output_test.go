@@ -0,0 +1,291 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/bilibili"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"github.com/keuin/slbr/flv"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRecordingFiles(t *testing.T, download DownloadConfig) *recordingFiles {
+	download.SaveDirectory = t.TempDir()
+	logger := logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test")
+	files := newRecordingFiles(
+		&TaskConfig{Download: download},
+		&taskState{},
+		newDanmakuRecorder(logger),
+		newHookRunner(0, nil, logger),
+		logger,
+	)
+	files.info.Title = "test"
+	return files
+}
+
+func TestSplitWriter(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{
+		SplitSizeBytes:                   10,
+		UseSpecialExtNameBeforeFinishing: true,
+	})
+	w, err := files.newSplitWriter("mp4")
+	if err != nil {
+		t.Fatalf("newSplitWriter: %v", err)
+	}
+	splitter, ok := w.(bilibili.HlsSplitter)
+	if !ok {
+		t.Fatalf("the writer cannot split HLS streams")
+	}
+	// segments are never split, even if they exceed the limit
+	if _, err := w.Write([]byte("<init><segment-1>")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, seg := range []string{"<segment-2>", "<segment-3>"} {
+		newFile, err := splitter.NextSegment()
+		if err != nil {
+			t.Fatalf("NextSegment: %v", err)
+		}
+		if !newFile {
+			t.Fatalf("the limit is reached, but no file is started")
+		}
+		// CopyHlsStream writes the initialization section to every new file
+		if _, err := w.Write([]byte("<init>" + seg)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	files.Close()
+
+	expected := []string{"<init><segment-1>", "<init><segment-2>", "<init><segment-3>"}
+	for i, content := range expected {
+		matches, _ := filepath.Glob(filepath.Join(files.task.Download.SaveDirectory, fmt.Sprintf("*_part%d.mp4", i+1)))
+		if len(matches) != 1 {
+			t.Fatalf("part %v: expected 1 file, got %v", i+1, matches)
+		}
+		b, err := os.ReadFile(matches[0])
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(b) != content {
+			t.Fatalf("part %v: expected %v, got %v", i+1, content, string(b))
+		}
+	}
+	// every part is renamed when it is finished
+	partials, _ := filepath.Glob(filepath.Join(files.task.Download.SaveDirectory, "*."+SpecialExtName))
+	if len(partials) != 0 {
+		t.Fatalf("parts are not renamed: %v", partials)
+	}
+}
+
+func TestSplitWriter_RawFlv(t *testing.T) {
+	// parts of a raw FLV stream would have no headers
+	config := TaskConfig{Download: DownloadConfig{RawFlv: true, SplitSizeBytes: 10}}
+	if err := config.Validate(); err == nil {
+		t.Fatalf("raw_flv is accepted with split limits")
+	}
+	config.Download.SplitSizeBytes = 0
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestSplitWriter_NoLimit(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{})
+	w, err := files.newSplitWriter("ts")
+	if err != nil {
+		t.Fatalf("newSplitWriter: %v", err)
+	}
+	if _, ok := w.(*splitWriter); ok {
+		t.Fatalf("the stream is split without limits")
+	}
+	files.Close()
+	matches, _ := filepath.Glob(filepath.Join(files.task.Download.SaveDirectory, "test_*.ts"))
+	if len(matches) != 1 || strings.Contains(matches[0], "_part") {
+		t.Fatalf("unexpected files: %v", matches)
+	}
+}
+
+func TestRecordingFiles_Template(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "{uid}/{title}/{part}.flv"})
+	files.info = fileNameInfo{UID: 5678, Title: "test"}
+	for i := 0; i < 2; i++ {
+		if _, err := files.create("flv"); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	files.Close()
+	for _, name := range []string{"5678/test/1.flv", "5678/test/2.flv"} {
+		if _, err := os.Stat(filepath.Join(files.task.Download.SaveDirectory, name)); err != nil {
+			t.Fatalf("file is not created: %v", err)
+		}
+	}
+}
+
+func TestRecordingFiles_Collision(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "{title}"})
+	for _, title := range []string{"a/b", "a:b", "a?b"} {
+		files.info.Title = title
+		if _, err := files.create("flv"); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	files.Close()
+	for _, name := range []string{"a_b.flv", "a_b_2.flv", "a_b_3.flv"} {
+		if _, err := os.Stat(filepath.Join(files.task.Download.SaveDirectory, name)); err != nil {
+			t.Fatalf("file is not created: %v", err)
+		}
+	}
+}
+
+func TestRecordingFiles_LongTitle(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{
+		SplitSizeBytes:                   10,
+		UseSpecialExtNameBeforeFinishing: true,
+		DanmakuExportFormats:             []string{"xml", "ass"},
+	})
+	files.info.Title = strings.Repeat("a", 300)
+	files.part = 99998
+	// files created in the same second collide, and get another suffix
+	for i := 0; i < 2; i++ {
+		if _, err := files.create("flv"); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	files.Close()
+	entries, err := os.ReadDir(files.task.Download.SaveDirectory)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) < 2 {
+		t.Fatalf("files are not created: %v", names)
+	}
+	for _, name := range names {
+		if len(name) > 255 {
+			t.Fatalf("file name is too long (%v bytes): %v", len(name), name)
+		}
+	}
+
+	// the longest suffix and extension name still fit
+	name := GenerateFileName(files.info.Title, time.Now()) + "_part999999_9999." + dmfile.ExtName
+	if len(name) > 255 {
+		t.Fatalf("generated file name is too long (%v bytes): %v", len(name), name)
+	}
+}
+
+func TestRecordingFiles_DanmakuReconnect(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "{title}"})
+	header := []byte{'F', 'L', 'V', 1, flv.FlagVideo, 0, 0, 0, flv.HeaderSize, 0, 0, 0, 0}
+	writeStream := func(w io.Writer) {
+		if _, err := w.Write(header); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		tag := flv.Tag{Type: flv.TagVideo, Data: []byte{0x17, 0x01, 0, 0, 0, 0}}
+		if _, err := tag.WriteTo(w); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	const gap = 200 * time.Millisecond
+
+	writeStream(files.flvWriter())
+	// the stream is interrupted, the gap is removed from the video when reconnected
+	w := files.flvWriter()
+	files.dmRecorder.OnDanMu(dmmsg.DanMuMessage{Content: "in the gap"})
+	time.Sleep(gap)
+	writeStream(w)
+	files.dmRecorder.OnDanMu(dmmsg.DanMuMessage{Content: "after the gap"})
+	files.Close()
+
+	f, err := os.Open(filepath.Join(files.task.Download.SaveDirectory, "test."+dmfile.ExtName))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	entries, err := dmfile.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Offset() >= gap/2 {
+			t.Fatalf("the gap is not excluded from the offset of %q: %v", e.Message.Content, e.Offset())
+		}
+	}
+}
+
+func TestRecordingFiles_Manifest(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{FileNameTemplate: "{title}"})
+	files.info = fileNameInfo{RoomId: 1234, UID: 5678, Title: "test"}
+	stream := types.StreamingUrlInfo{URL: "https://host-a/live.flv", QualityNumber: 10000}
+	ctx := context.Background()
+
+	files.startStream(stream)
+	w, err := files.create("flv")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := w.Write([]byte("0123")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files.endStream(ctx, errs.NewError(errs.StreamCopy, io.ErrUnexpectedEOF))
+	stream.URL = "https://host-b/live.flv"
+	files.startStream(stream)
+	files.endStream(ctx, io.EOF)
+	files.Close()
+
+	b, err := os.ReadFile(filepath.Join(files.task.Download.SaveDirectory, "test.json"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if m.RoomId != 1234 || m.UID != 5678 || m.Title != "test" || m.File != "test.flv" || m.Part != 1 {
+		t.Fatalf("unexpected room info: %+v", m)
+	}
+	if m.Stream.Host != "host-a" || m.Stream.QualityNumber != 10000 || m.BytesWritten != 4 {
+		t.Fatalf("unexpected stream info: %+v", m)
+	}
+	if m.EndReason != EndReasonEOF || m.EndTime.Before(m.StartTime) {
+		t.Fatalf("unexpected end: %+v", m)
+	}
+	if len(m.Reconnects) != 1 || m.Reconnects[0].Reason != "stream_copy" || m.Reconnects[0].Stream.Host != "host-b" {
+		t.Fatalf("unexpected reconnects: %+v", m.Reconnects)
+	}
+}
+
+func TestNewStreamEnd(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if end := newStreamEnd(ctx, nil); end.reason != EndReasonEOF {
+		t.Fatalf("unexpected reason: %v", end.reason)
+	}
+	if end := newStreamEnd(ctx, errors.New("test")); end.reason != EndReasonUnknown || end.err == nil {
+		t.Fatalf("unexpected reason: %v", end.reason)
+	}
+	cancel(errLiveEnded)
+	if end := newStreamEnd(ctx, context.Canceled); end.reason != "live_ended" {
+		t.Fatalf("unexpected reason: %v", end.reason)
+	}
+	ctx, cancel = context.WithCancelCause(context.Background())
+	cancel(nil)
+	if end := newStreamEnd(ctx, context.Canceled); end.reason != EndReasonCanceled {
+		t.Fatalf("unexpected reason: %v", end.reason)
+	}
+}
@@ -0,0 +1,112 @@
+package recording
+
+/*
+In this file we resolve rooms of task configs to canonical room ids before tasks are started.
+Tasks of rooms are identified by room ids, so the same room given in different forms is the same task.
+Rooms of tasks of a uid are not resolved here, they are looked up when the task runs, see `streamer.go`.
+Results are cached, so reloading the config file does not resolve rooms again,
+and a temporary failure does not change the room id of a running task.
+Invalid configs are distinguished from rooms which cannot be resolved now, the latter should be resolved again later.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/bilibili"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrInvalidRoomConfig is returned by Resolve if the room of the config is malformed,
+	// or conflicting fields are set. Resolving it again does not help.
+	ErrInvalidRoomConfig = errors.New("invalid task config")
+	// ErrRoomUnresolved is returned by Resolve if the room cannot be resolved now,
+	// e.g. because of network errors, or the live room is not created yet. It may be resolved later.
+	ErrRoomUnresolved = errors.New("cannot resolve room")
+)
+
+// RoomResolver sets RoomId of task configs. It is safe to be used from multiple goroutines.
+type RoomResolver struct {
+	logger logging.Logger
+	// resolve is replaced in tests
+	resolve func(input string) (types.RoomId, error)
+
+	lock  sync.Mutex
+	cache map[string]types.RoomId
+}
+
+func NewRoomResolver(logger logging.Logger) *RoomResolver {
+	bi := bilibili.NewBilibili(logger)
+	return &RoomResolver{
+		logger:  logger,
+		resolve: bi.ResolveRoom,
+		cache:   make(map[string]types.RoomId),
+	}
+}
+
+// Resolve sets RoomId of the config to the canonical room id of Room or RoomId.
+// If a numeric room id cannot be resolved because of network errors, it is used as is.
+// Configs of a uid are left unchanged. The returned error is ErrInvalidRoomConfig or ErrRoomUnresolved.
+func (r *RoomResolver) Resolve(config *TaskConfig) error {
+	if config.Uid != 0 {
+		if config.Room != "" || config.RoomId != 0 {
+			return fmt.Errorf("%w: uid cannot be set with room or room_id", ErrInvalidRoomConfig)
+		}
+		return nil
+	}
+	input := config.Room
+	if input == "" {
+		if config.RoomId == 0 {
+			return fmt.Errorf("%w: room, room_id or uid is required", ErrInvalidRoomConfig)
+		}
+		input = strconv.FormatUint(uint64(config.RoomId), 10)
+	} else if config.RoomId != 0 {
+		return fmt.Errorf("%w: room and room_id cannot be set at the same time", ErrInvalidRoomConfig)
+	}
+
+	r.lock.Lock()
+	roomId, ok := r.cache[input]
+	r.lock.Unlock()
+	if ok {
+		config.RoomId = roomId
+		return nil
+	}
+
+	roomId, err := r.resolve(input)
+	if err != nil {
+		if errors.Is(err, bilibili.ErrInvalidRoomInput) {
+			return fmt.Errorf("%w: %w", ErrInvalidRoomConfig, err)
+		}
+		if config.Room == "" && !errors.Is(err, bilibili.ErrRoomNotFound) {
+			r.logger.Warning("Cannot resolve room %v, it is used as is: %v", input, err)
+			return nil
+		}
+		return fmt.Errorf("%w %q: %w", ErrRoomUnresolved, input, err)
+	}
+	if strconv.FormatUint(uint64(roomId), 10) != input {
+		r.logger.Info("Room %v is resolved to %v.", input, roomId)
+	}
+	r.lock.Lock()
+	r.cache[input] = roomId
+	r.lock.Unlock()
+	config.RoomId = roomId
+	return nil
+}
+
+// ResolveAll resolves configs and returns the resolved ones. Configs which cannot be resolved are skipped,
+// their errors are joined into the returned error, see Resolve.
+func (r *RoomResolver) ResolveAll(configs []TaskConfig) ([]TaskConfig, error) {
+	var resolved []TaskConfig
+	var failures []error
+	for _, c := range configs {
+		if err := r.Resolve(&c); err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		resolved = append(resolved, c)
+	}
+	return resolved, errors.Join(failures...)
+}
@@ -0,0 +1,103 @@
+package recording
+
+import (
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/bilibili"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"log"
+	"testing"
+)
+
+func newTestRoomResolver(calls map[string]int, online *bool) *RoomResolver {
+	r := NewRoomResolver(logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test"))
+	r.resolve = func(input string) (types.RoomId, error) {
+		calls[input]++
+		if input == "not a room" {
+			return 0, fmt.Errorf("%w %q", bilibili.ErrInvalidRoomInput, input)
+		}
+		if !*online {
+			return 0, errors.New("network is unreachable")
+		}
+		switch input {
+		case "6", "https://live.bilibili.com/6":
+			return 7734200, nil
+		case "1234":
+			return 1234, nil
+		}
+		return 0, fmt.Errorf("%w: %v", bilibili.ErrRoomNotFound, input)
+	}
+	return r
+}
+
+func TestRoomResolver_Resolve(t *testing.T) {
+	calls := make(map[string]int)
+	online := true
+	r := newTestRoomResolver(calls, &online)
+
+	configs, err := r.ResolveAll([]TaskConfig{
+		{RoomId: 6},
+		{Room: "https://live.bilibili.com/6"},
+		{RoomId: 1234},
+		{RoomId: 4321},
+		{Room: "https://live.bilibili.com/4321"},
+		{},
+		{RoomId: 6, Room: "https://live.bilibili.com/6"},
+		{Uid: 9617619},
+		{Uid: 9617619, RoomId: 6},
+	})
+	if err == nil {
+		t.Fatalf("unresolved rooms are not reported")
+	}
+	if len(configs) != 4 {
+		t.Fatalf("expected 4 resolved configs, got %v", configs)
+	}
+	if configs[3].Uid != 9617619 || configs[3].RoomId != 0 {
+		t.Fatalf("config of a uid is changed: %v", configs[3])
+	}
+	for i, expected := range []types.RoomId{7734200, 7734200, 1234} {
+		if configs[i].RoomId != expected {
+			t.Fatalf("config %v: expected room %v, got %v", i, expected, configs[i].RoomId)
+		}
+	}
+	if configs[1].Room != "https://live.bilibili.com/6" {
+		t.Fatalf("room input is changed: %v", configs[1].Room)
+	}
+
+	// results are cached, and room ids are used as is if they cannot be resolved temporarily
+	online = false
+	configs, err = r.ResolveAll([]TaskConfig{
+		{RoomId: 6},
+		{Room: "https://live.bilibili.com/6"},
+		{RoomId: 5678},
+	})
+	if err != nil {
+		t.Fatalf("ResolveAll: %v", err)
+	}
+	if configs[0].RoomId != 7734200 || configs[1].RoomId != 7734200 || configs[2].RoomId != 5678 {
+		t.Fatalf("unexpected configs: %v", configs)
+	}
+	if calls["6"] != 1 || calls["https://live.bilibili.com/6"] != 1 {
+		t.Fatalf("results are not cached: %v", calls)
+	}
+
+	// URLs cannot be used without resolving, they should be resolved again later
+	config := TaskConfig{Room: "https://live.bilibili.com/5678"}
+	if err := r.Resolve(&config); !errors.Is(err, ErrRoomUnresolved) {
+		t.Fatalf("expected ErrRoomUnresolved, got %v", err)
+	}
+
+	// invalid configs are never resolved
+	for _, config := range []TaskConfig{
+		{Room: "not a room"},
+		{},
+		{RoomId: 6, Room: "https://live.bilibili.com/6"},
+		{Uid: 9617619, RoomId: 6},
+	} {
+		if err := r.Resolve(&config); !errors.Is(err, ErrInvalidRoomConfig) {
+			t.Fatalf("expected ErrInvalidRoomConfig for %+v, got %v", config, err)
+		}
+	}
+}
@@ -15,13 +15,17 @@ import (
 	"github.com/keuin/slbr/common"
 	"github.com/keuin/slbr/common/files"
 	"github.com/keuin/slbr/common/myurl"
+	"github.com/keuin/slbr/danmaku/dmmsg"
+	"github.com/keuin/slbr/danmaku/dmpkg"
 	"github.com/keuin/slbr/logging"
 	"github.com/keuin/slbr/types"
 	"github.com/samber/mo"
 	"io"
-	"os"
-	"path"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +39,15 @@ const SpecialExtName = "partial"
 
 var errLiveEnded = errs.NewError(errs.LiveEnded)
 
+// recordRestartMinInterval: if a recording fails sooner than this, wait for the retry interval before restarting
+const recordRestartMinInterval = 10 * time.Second
+
+// hlsExtNames are extension names of recorded HLS streams, by segment format
+var hlsExtNames = map[string]string{
+	"fmp4": "mp4",
+	"ts":   "ts",
+}
+
 // runTaskWithAutoRestart
 // start a monitor&download task.
 // The task will be restarted infinitely until the context is closed,
@@ -42,44 +55,86 @@ var errLiveEnded = errs.NewError(errs.LiveEnded)
 // During the process, its status may change.
 // Note: this method is blocking.
 func (t *RunningTask) runTaskWithAutoRestart() {
-	t.status = StRunning
+	t.state.setStatus(StRunning)
 loop:
 	for {
-		err := tryRunTask(t)
+		var err error
+		if t.Uid != 0 {
+			err = runInStreamerRoom(t)
+		} else {
+			err = tryRunTask(t)
+		}
 		if errors.Is(err, context.Canceled) {
 			break
 		}
-		switch err.(type) {
-		case nil:
+		// task errors may be wrapped, e.g. a DiskFull error returned by the writer of HLS segments
+		var taskErr errs.TaskError
+		switch {
+		case err == nil:
 			t.logger.Info("Task stopped: %v", t.String())
-		case errs.TaskError:
-			if !errors.Is(err, errLiveEnded) {
+		case errors.As(err, &taskErr):
+			if taskErr.Type() == errs.DiskFull {
+				// retrying does not help until the disk is cleaned up
+				t.logger.Error("Stop the task: %v", err)
+				t.state.setLastError(err)
+				t.hooks.fire(HookEventData{Event: HookTaskError, Error: err.Error()})
+				break loop
+			}
+			if errors.Is(err, errLiveEnded) {
+				t.hooks.fire(HookEventData{Event: HookLiveEnded})
+			} else if errors.Is(err, errRoomChanged) {
+				t.logger.Info("Restarting the task in the new room...")
+			} else {
 				t.logger.Error("Temporary error: %v", err)
+				t.state.setLastError(err)
+				t.state.addRetry(taskErr)
+				t.hooks.fire(HookEventData{Event: HookTaskError, Error: err.Error()})
 			}
-			t.status = StRestarting
+			t.state.setStatus(StRestarting)
 		default:
 			t.logger.Error("Cannot recover from error: %v", err)
+			t.state.setLastError(err)
+			t.hooks.fire(HookEventData{Event: HookTaskError, Error: err.Error()})
 			break loop
 		}
 	}
 	t.logger.Info("Task stopped: %v", t.String())
 }
 
+// newTaskClient creates the Bilibili client of a run of the task, with its transport config and cookies.
+func newTaskClient(t *RunningTask) (*bilibili.Bilibili, error) {
+	netTypes := t.Transport.AllowedNetworkTypes
+	t.logger.Info("Network types: %v", netTypes)
+	bi := bilibili.NewBilibiliWithNetType(netTypes, t.logger)
+	bi.SetSocketTimeout(time.Duration(t.Transport.SocketTimeoutSeconds) * time.Second)
+	bi.SetStallDetection(t.Transport.StallDetection())
+	if t.CookieFile != "" {
+		// the file is read every time, so it can be updated with `slbr login` without restarting the task
+		cookies, err := bilibili.ReadCookieFile(t.CookieFile)
+		if err != nil {
+			return nil, errs.NewError(errs.LoadCookies, err)
+		}
+		bi.SetCookies(cookies)
+	}
+	return bi, nil
+}
+
 // tryRunTask does the actual work. It will return when in the following cases:
 // RecoverableError (end of live, IO error)
 // UnrecoverableError (protocol error)
 // context.Cancelled (the task is stopping)
 func tryRunTask(t *RunningTask) error {
-	netTypes := t.Transport.AllowedNetworkTypes
-	t.logger.Info("Network types: %v", netTypes)
-	bi := bilibili.NewBilibiliWithNetType(netTypes, t.logger)
+	bi, err := newTaskClient(t)
+	if err != nil {
+		return err
+	}
 	t.logger.Info("Start task: room %v", t.RoomId)
 
 	t.logger.Info("Getting notification server info...")
 
 	dmInfo, err := AutoRetryWithTask(
 		t, func() (*danmakuServerInfo, error) {
-			return getDanmakuServer(&t.TaskConfig, bi)
+			return getDanmakuServer(&t.TaskConfig, bi, t.logger)
 		},
 	)
 	if err != nil {
@@ -106,8 +161,38 @@ func tryRunTask(t *RunningTask) error {
 	// run live status watcher asynchronously
 	t.logger.Info("Starting watcher...")
 
+	// the watcher keeps running while recording, so the signal may be sent more than once
+	chLiveStart := make(chan struct{}, 1)
+	onLiveStart := func() {
+		select {
+		case chLiveStart <- struct{}{}:
+		default:
+		}
+	}
+	// the recorder is stopped as soon as the server tells us the live is ended,
+	// since the stream server may not close the connection in time
+	ctxRecord, stopRecord := context.WithCancelCause(t.ctx)
+	defer stopRecord(nil)
+	var isRecording atomic.Bool
+	onLiveEnd := func() {
+		if isRecording.Load() {
+			stopRecord(errLiveEnded)
+		}
+	}
+	dmRecorder := newDanmakuRecorder(t.logger)
+	dispatcher := dmmsg.NewDispatcher(
+		&liveStatusHandler{onLiveStart: onLiveStart, onLiveEnd: onLiveEnd},
+		&loggingHandler{logger: t.logger},
+		&metricsHandler{state: t.state},
+		&hookHandler{hooks: t.hooks},
+		dmRecorder,
+	)
+	for _, h := range t.danmakuHandlers {
+		dispatcher.Register(h)
+	}
+
 	wg.Add(1)
-	chWatcherError := make(chan error)
+	chWatcherError := make(chan error, 1)
 	ctxWatcher, stopWatcher := context.WithCancel(t.ctx)
 	defer stopWatcher()
 	go func() {
@@ -117,17 +202,24 @@ func tryRunTask(t *RunningTask) error {
 		cd := common.CoolDown{
 			MinInterval: time.Second * 10,
 		}
+		// the server in use, it is kept until the connection fails
+		serverIndex := 0
 	loop:
 		for run {
-			t.logger.Info("Start watching, ws url: %v, auth key: %v, buvid3: %v",
-				dmInfo.DanmakuWebsocketUrl, dmInfo.AuthKey, dmInfo.BUVID3)
+			wsUrl := dmInfo.DanmakuWebsocketUrls[serverIndex]
+			t.logger.Info("Start watching, ws url: %v, uid: %v, auth key: %v, buvid3: %v",
+				wsUrl, dmInfo.UID, dmInfo.AuthKey, dmInfo.BUVID3)
 			err = watch(
 				ctxWatcher,
 				t.TaskConfig,
-				dmInfo.DanmakuWebsocketUrl,
+				wsUrl,
+				dmInfo.UID,
 				dmInfo.AuthKey,
 				dmInfo.BUVID3,
 				liveStatusChecker,
+				onLiveStart,
+				dispatcher,
+				t.state,
 				t.logger,
 				bi,
 			)
@@ -136,15 +228,15 @@ func tryRunTask(t *RunningTask) error {
 				break loop
 			}
 			switch err := err.(type) {
-			case nil:
-				// live is started, stop watcher loop and start the recorder
-				break loop
 			case errs.TaskError:
 				if err.IsRecoverable() {
-					// if the watcher fails and recoverable, just try to recover
-					// because the recorder has not started yet
+					// if the watcher fails and recoverable, just try to recover,
+					// the recorder does not depend on the watcher connection
 					run = true
 					t.logger.Error("Error occurred in live status watcher: %v", err)
+					t.state.addRetry(err)
+					// the server may be unhealthy, try the next one
+					serverIndex = (serverIndex + 1) % len(dmInfo.DanmakuWebsocketUrls)
 				} else {
 					// the watcher cannot recover, so the task should be stopped
 					run = false
@@ -167,22 +259,36 @@ func tryRunTask(t *RunningTask) error {
 	}()
 
 	// wait for live start signal or the watcher stops abnormally
-	switch errWatcher := <-chWatcherError; err := errWatcher.(type) {
-	case nil:
+	var errWatcher error
+	select {
+	case <-chLiveStart:
 		// live is started, start recording
-		// (now the watcher should have stopped)
+		// (the watcher is still running to capture danmaku)
+		isRecording.Store(true)
 		return func() error {
+			// files are kept across reconnects, and finished when the recording is stopped
+			outputs := newRecordingFiles(&t.TaskConfig, t.state, dmRecorder, t.hooks, t.logger)
+			defer outputs.Close()
 			var err error
 			run := true
 			for run {
-				err = record(t.ctx, bi, &t.TaskConfig, t.logger)
+				t.state.setStatus(StRunning)
+				recordStart := time.Now()
+				err = record(ctxRecord, bi, &t.TaskConfig, t.state, outputs, t.logger)
+				if t.ctx.Err() == nil && errors.Is(context.Cause(ctxRecord), errLiveEnded) {
+					// stopped by the watcher
+					t.logger.Info("The live is ended. Restarting current task...")
+					return errLiveEnded
+				}
 				if err == nil {
 					// live is ended
 					t.logger.Info("The live is ended. Restarting current task...")
 					return errLiveEnded
 				}
-				if err, ok := err.(errs.TaskError); ok && err.IsRecoverable() {
+				var taskErr errs.TaskError
+				if errors.As(err, &taskErr) && taskErr.IsRecoverable() {
 					run = true
+					t.state.addRetry(taskErr)
 					// here we don't know if the live is ended, so we have to do a check
 					t.logger.Warning("Recording is interrupted. Checking live status...")
 					isLiving, err2 := AutoRetryWithTask(t, liveStatusChecker)
@@ -191,11 +297,19 @@ func tryRunTask(t *RunningTask) error {
 					}
 					if isLiving {
 						t.logger.Info("This is a temporary error. Restarting recording...")
-					} else {
-						t.logger.Info("The live is ended. Restarting current task...")
-						return errLiveEnded
+						// reconnect right away, unless the recording fails repeatedly
+						if time.Since(recordStart) < recordRestartMinInterval {
+							timer := time.NewTimer(time.Duration(t.Transport.RetryIntervalSeconds) * time.Second)
+							select {
+							case <-ctxRecord.Done():
+								timer.Stop()
+							case <-timer.C:
+							}
+						}
+						continue
 					}
-					run = isLiving
+					t.logger.Info("The live is ended. Restarting current task...")
+					return errLiveEnded
 				}
 				// unrecoverable or unexpected errors
 				run = false
@@ -212,6 +326,9 @@ func tryRunTask(t *RunningTask) error {
 			}
 			return err
 		}()
+	case errWatcher = <-chWatcherError:
+	}
+	switch err := errWatcher.(type) {
 	case errs.TaskError:
 		if !err.IsRecoverable() {
 			// watcher is stopped and cannot restart
@@ -246,6 +363,8 @@ func record(
 	ctx context.Context,
 	bi *bilibili.Bilibili,
 	task *TaskConfig,
+	state *taskState,
+	outputs *recordingFiles,
 	logger logging.Logger,
 ) error {
 	logger.Info("Getting room profile...")
@@ -265,13 +384,31 @@ func record(
 		return errs.NewError(errs.GetRoomInfo, err)
 	}
 
-	logger.Info("Getting stream url...")
+	outputs.hooks.setTitle(profile.Data.Title)
+	info := fileNameInfo{
+		RoomId:     uint64(profile.Data.RoomID),
+		ShortId:    profile.Data.ShortID,
+		UID:        profile.Data.UID,
+		Title:      profile.Data.Title,
+		Area:       profile.Data.AreaName,
+		ParentArea: profile.Data.ParentAreaName,
+	}
+	if info.UID == 0 && task.Uid != 0 {
+		// the streamer is known in tasks of a uid, even if the profile does not have it
+		info.UID = int(task.Uid)
+	}
+	if strings.Contains(task.Download.FileNameTemplate, "{name}") {
+		info.Name = getStreamerName(ctx, bi, task, info.UID, logger)
+	}
+
+	pref := task.Stream.Preference()
+	logger.Info("Getting stream url (%v)...", pref)
 	urlInfo, err := AutoRetryWithConfig(
 		ctx,
 		logger,
 		task,
 		func() (types.RoomUrlInfoResponse, error) {
-			return bi.GetStreamingInfo(task.RoomId)
+			return bi.GetStreamingInfo(task.RoomId, pref)
 		},
 	)
 	if err != nil {
@@ -288,87 +425,151 @@ func record(
 		logger.Error("No stream was provided. Response: %v", string(j))
 		return errs.NewError(errs.InvalidLiveInfo, fmt.Errorf("no stream provided"))
 	}
-	streamSource := urlInfo.Data.URLs[0]
 
-	var extName string
+	// try all streams in turn, starting from the CDN host which worked last time
+	streams := sortStreamsByHost(urlInfo.Data.URLs, state.getCdnHost())
+	var taskErr errs.TaskError
+	for i, streamSource := range streams {
+		host := streamHost(streamSource)
+		logger.Info("Selected stream (%v/%v): host %v, qn %v, codec %v, format %v",
+			i+1, len(streams), host, streamSource.QualityNumber, streamSource.Codec, streamSource.Format)
+		var created bool
+		created, err = recordStream(ctx, bi, task, outputs, logger, info, streamSource)
+		if created {
+			state.setCdnHost(host)
+		} else if state.getCdnHost() == host {
+			state.setCdnHost("")
+		}
+		if errors.As(err, &taskErr) && !taskErr.IsRecoverable() {
+			logger.Error("Cannot record: %v", err)
+			return err
+		} else if errors.Is(err, context.Canceled) || err == nil {
+			return err
+		}
+		logger.Error("Error when copying live stream from %v: %v", host, err)
+		if i+1 < len(streams) {
+			logger.Info("Trying next stream...")
+		}
+	}
+	if errors.As(err, &taskErr) {
+		return err
+	}
+	return errs.NewError(errs.StreamCopy, err)
+}
 
-	// the real extension name (without renaming)
-	originalExtName := mo.TupleToResult(myurl.Url(streamSource.URL).FileExtension()).OrElse("flv")
+// recordStream records a stream. FLV streams may be appended to the current file,
+// other streams are saved to a new file.
+// created reports whether the file is created or continued, which means the stream is working.
+func recordStream(
+	ctx context.Context,
+	bi *bilibili.Bilibili,
+	task *TaskConfig,
+	outputs *recordingFiles,
+	logger logging.Logger,
+	info fileNameInfo,
+	streamSource types.StreamingUrlInfo,
+) (created bool, err error) {
+	outputs.info = info
+	writeBufferSize := task.Download.DiskWriteBufferBytes
+	logger.Info("Write buffer size: %v byte", writeBufferSize)
 
-	if task.Download.UseSpecialExtNameBeforeFinishing {
-		extName = SpecialExtName
-	} else {
-		extName = originalExtName
+	if streamSource.Protocol != types.ProtocolHls && !task.Download.RawFlv {
+		// timestamps and headers are fixed, so the file can be continued after reconnecting
+		outputs.startStream(streamSource)
+		w := outputs.flvWriter()
+		err = bi.CopyLiveStream(ctx, task.RoomId, streamSource, func() (io.Writer, error) {
+			created = true
+			return w, nil
+		}, writeBufferSize)
+		outputs.endStream(ctx, err)
+		return created, err
 	}
 
-	baseName := GenerateFileName(profile.Data.Title, time.Now())
-	fileName := files.CombineFileName(baseName, extName)
-	saveDir := task.Download.SaveDirectory
-	filePath := path.Join(saveDir, fileName)
-
-	var file *os.File
+	// the real extension name (without renaming)
+	originalExtName := mo.TupleToResult(myurl.Url(streamSource.URL).FileExtension()).OrElse("flv")
+	copyStream := bi.CopyLiveStream
+	if streamSource.Protocol == types.ProtocolHls {
+		// segments are concatenated into a single file
+		originalExtName = hlsExtNames[streamSource.Format]
+		copyStream = bi.CopyHlsStream
+	}
 
-	// TODO refactor, move file close logic to CopyLiveStream
-	// rename the extension name to originalExtName when finish writing
+	outputs.Close()
+	outputs.startStream(streamSource)
 	defer func() {
-		if file == nil {
-			// the file is not created
-			return
-		}
-		if extName == originalExtName {
-			return
-		}
-		from := filePath
-		to := path.Join(saveDir, files.CombineFileName(baseName, originalExtName))
-		err := os.Rename(from, to)
-		if err != nil {
-			logger.Error("Cannot rename %v to %v: %v", from, to, err)
-			return
-		}
-		logger.Info("Rename file \"%s\" to \"%s\".", from, to)
+		outputs.endStream(ctx, err)
+		outputs.Close()
 	}()
-	defer func() { _ = file.Close() }()
+	err = copyStream(ctx, task.RoomId, streamSource, func() (io.Writer, error) {
+		w, err := outputs.newSplitWriter(originalExtName)
+		created = err == nil
+		return w, err
+	}, writeBufferSize)
+	return created, err
+}
 
-	writeBufferSize := task.Download.DiskWriteBufferBytes
-	logger.Info("Write buffer size: %v byte", writeBufferSize)
-	err = bi.CopyLiveStream(ctx, task.RoomId, streamSource, func() (f *os.File, e error) {
-		dirInfo, err := os.Stat(saveDir)
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			return nil, err
-		}
-		err = nil
-		if dirInfo == nil || !dirInfo.IsDir() {
-			err = os.Mkdir(saveDir, 0775)
-			if err != nil {
-				return nil, fmt.Errorf("cannot create save directory: %w", err)
-			}
-		}
-		f, e = os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if e != nil {
-			file = f
+// getStreamerName returns the user name of the streamer. The UID is returned if the name is not available.
+func getStreamerName(
+	ctx context.Context,
+	bi *bilibili.Bilibili,
+	task *TaskConfig,
+	uid int,
+	logger logging.Logger,
+) string {
+	resp, err := AutoRetryWithConfig(
+		ctx,
+		logger,
+		task,
+		func() (types.StreamerInfoResponse, error) {
+			return bi.GetStreamerInfo(uid)
+		},
+	)
+	if err == nil && resp.Code != 0 {
+		err = fmt.Errorf("bilibili API error: %v", resp.Message)
+	}
+	if err != nil || resp.Data.Info.Uname == "" {
+		logger.Warning("Cannot get the name of streamer %v, use UID instead: %v", uid, err)
+		return strconv.Itoa(uid)
+	}
+	return resp.Data.Info.Uname
+}
+
+// streamHost returns the host of a stream URL, which identifies the CDN node.
+func streamHost(stream types.StreamingUrlInfo) string {
+	u, err := url.Parse(stream.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// sortStreamsByHost moves streams on the given host to the front. The order is kept otherwise.
+func sortStreamsByHost(streams []types.StreamingUrlInfo, host string) []types.StreamingUrlInfo {
+	sorted := make([]types.StreamingUrlInfo, 0, len(streams))
+	var others []types.StreamingUrlInfo
+	for _, s := range streams {
+		if host != "" && streamHost(s) == host {
+			sorted = append(sorted, s)
+		} else {
+			others = append(others, s)
 		}
-		logger.Info("Recording live stream to file \"%v\"...", filePath)
-		return
-	}, writeBufferSize)
-	if err, ok := err.(errs.TaskError); ok && !err.IsRecoverable() {
-		logger.Error("Cannot record: %v", err)
-		return err
-	} else if errors.Is(err, context.Canceled) || err == nil {
-		return err
 	}
-	logger.Error("Error when copying live stream: %v", err)
-	return errs.NewError(errs.StreamCopy, err)
+	return append(sorted, others...)
 }
 
 type danmakuServerInfo struct {
-	DanmakuWebsocketUrl string
-	AuthKey             string
-	BUVID3              string
+	// DanmakuWebsocketUrls: all available servers, they are tried in turn when the connection fails
+	DanmakuWebsocketUrls []string
+	// UID: the logged-in user, or dmpkg.UidGuest
+	UID     uint64
+	AuthKey string
+	BUVID3  string
 }
 
 func getDanmakuServer(
 	task *TaskConfig,
 	bi *bilibili.Bilibili,
+	logger logging.Logger,
 ) (*danmakuServerInfo, error) {
 	buvid3, err := bi.GetBUVID()
 	if err != nil {
@@ -382,6 +583,20 @@ func getDanmakuServer(
 		}
 		return nil, fmt.Errorf("failed to get LIVE_BUVID with api `webBanner`: invalid response: %v", resp)
 	}
+	uid := uint64(dmpkg.UidGuest)
+	if task.CookieFile != "" {
+		nav, err := bi.GetLoginInfo()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get login status: %w", err)
+		}
+		if nav.Data.IsLogin {
+			uid = nav.Data.Mid
+		} else {
+			// the token is still usable, with the limitation of guests
+			logger.Warning("The session in cookie file \"%v\" is expired, connect as a guest. "+
+				"Run `slbr login` to log in again.", task.CookieFile)
+		}
+	}
 	dmInfo, err := bi.GetDanmakuServerInfo(task.RoomId)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stream server info: %w", err)
@@ -390,17 +605,22 @@ func getDanmakuServer(
 		return nil, fmt.Errorf("no available stream server")
 	}
 
-	// get authkey and ws url
+	// get authkey and ws urls
 	authKey := dmInfo.Data.Token
-	host := dmInfo.Data.HostList[0]
-	url := fmt.Sprintf("wss://%s:%d/sub", host.Host, host.WssPort)
+	var urls []string
+	for _, host := range dmInfo.Data.HostList {
+		urls = append(urls, fmt.Sprintf("wss://%s:%d/sub", host.Host, host.WssPort))
+	}
 	return &danmakuServerInfo{
-		DanmakuWebsocketUrl: url,
-		AuthKey:             authKey,
-		BUVID3:              buvid3,
+		DanmakuWebsocketUrls: urls,
+		UID:                  uid,
+		AuthKey:              authKey,
+		BUVID3:               buvid3,
 	}, nil
 }
 
+// GenerateFileName returns the default file name of a recording without the extension name,
+// roomName is sanitized. The name is short enough to add suffixes and extension names.
 func GenerateFileName(roomName string, t time.Time) string {
 	ts := fmt.Sprintf(
 		"%d-%02d-%02d-%02d-%02d-%02d",
@@ -411,5 +631,17 @@ func GenerateFileName(roomName string, t time.Time) string {
 		t.Minute(),
 		t.Second(),
 	)
-	return fmt.Sprintf("%s_%s", roomName, ts)
+	return files.DefaultSanitizer.Sanitize(fmt.Sprintf("%s_%s", roomName, ts), files.DefaultMaxBytes-fileNameSuffixBytes)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n.Add(int64(n))
+	return
 }
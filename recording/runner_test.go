@@ -0,0 +1,79 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"github.com/keuin/slbr/bilibili"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSortStreamsByHost(t *testing.T) {
+	streams := []types.StreamingUrlInfo{
+		{URL: "https://a.example.com/live.flv?1"},
+		{URL: "https://b.example.com/live.flv?2"},
+		{URL: "https://c.example.com/live.flv?3"},
+		{URL: "https://b.example.com/live.m3u8?4"},
+	}
+	check := func(host string, expected ...string) {
+		sorted := sortStreamsByHost(streams, host)
+		if len(sorted) != len(expected) {
+			t.Fatalf("host %v: unexpected length %v", host, len(sorted))
+		}
+		for i := range expected {
+			if sorted[i].URL != expected[i] {
+				t.Fatalf("host %v: stream %v: expected %v, got %v", host, i, expected[i], sorted[i].URL)
+			}
+		}
+	}
+	check("b.example.com",
+		"https://b.example.com/live.flv?2",
+		"https://b.example.com/live.m3u8?4",
+		"https://a.example.com/live.flv?1",
+		"https://c.example.com/live.flv?3",
+	)
+	// unknown or empty host keeps the order
+	check("", streams[0].URL, streams[1].URL, streams[2].URL, streams[3].URL)
+	check("d.example.com", streams[0].URL, streams[1].URL, streams[2].URL, streams[3].URL)
+}
+
+func TestRecordStream_HlsDiskFull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.m3u8" {
+			_, _ = io.WriteString(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+				"#EXTINF:1.0,\n0.ts\n#EXTINF:1.0,\n1.ts\n#EXTINF:1.0,\n2.ts\n")
+			return
+		}
+		_, _ = io.WriteString(w, "<"+r.URL.Path+">")
+	}))
+	defer server.Close()
+
+	files := newTestRecordingFiles(t, DownloadConfig{DiskWriteBufferBytes: 1024})
+	files.task.Storage = StorageConfig{MinFreeBytes: 50, StopFreeBytes: 50}
+	files.storage = newStorageGuard(files.task, files.logger)
+	free := uint64(100)
+	files.storage.freeSpace = func(string) (uint64, error) {
+		// the free space is checked on every write, and the disk is full after the file is created
+		files.storage.lastCheck = time.Time{}
+		f := free
+		free = 0
+		return f, nil
+	}
+
+	bi := bilibili.NewBilibili(files.logger)
+	stream := types.StreamingUrlInfo{URL: server.URL + "/index.m3u8", Protocol: types.ProtocolHls, Format: "ts"}
+	created, err := recordStream(context.Background(), bi, files.task, files, files.logger, files.info, stream)
+	if !created {
+		t.Fatalf("the file is not created")
+	}
+	// the DiskFull error is wrapped by the HLS downloader, it must not be taken as a recoverable one
+	var taskErr errs.TaskError
+	if !errors.As(err, &taskErr) || taskErr.Type() != errs.DiskFull || taskErr.IsRecoverable() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
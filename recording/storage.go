@@ -0,0 +1,238 @@
+package recording
+
+/*
+In this file we protect the disk of the save directory.
+New files are not created if the free space is low, and recording is stopped
+if the free space drops below the stop threshold while writing.
+Finished recordings of the room can be pruned by age or total size. They are found by their manifests,
+so files which are not created by us are never deleted.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/common/files"
+	"github.com/keuin/slbr/common/pretty"
+	"github.com/keuin/slbr/danmaku/dmfile"
+	"github.com/keuin/slbr/logging"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StorageConfig limits the disk usage of a task. Zero values disable the corresponding check.
+type StorageConfig struct {
+	// MinFreeBytes: do not create new files if the free space of the save directory is less than this
+	MinFreeBytes int64 `mapstructure:"min_free_bytes"`
+	// StopFreeBytes: stop recording if the free space is less than this while writing
+	StopFreeBytes int64 `mapstructure:"stop_free_bytes"`
+	// RetentionMaxAgeHours: delete finished recordings of this room which are older than this
+	RetentionMaxAgeHours int `mapstructure:"retention_max_age_hours"`
+	// RetentionMaxBytes: delete the oldest finished recordings of this room while their total size exceeds this
+	RetentionMaxBytes int64 `mapstructure:"retention_max_bytes"`
+}
+
+func (s StorageConfig) validate() error {
+	if s.MinFreeBytes < 0 || s.StopFreeBytes < 0 || s.RetentionMaxAgeHours < 0 || s.RetentionMaxBytes < 0 {
+		return fmt.Errorf("storage limits must not be negative")
+	}
+	if s.MinFreeBytes > 0 && s.StopFreeBytes > s.MinFreeBytes {
+		return fmt.Errorf("stop_free_bytes must not be greater than min_free_bytes")
+	}
+	return nil
+}
+
+// storageCheckInterval is how often the free space is checked while writing
+const storageCheckInterval = 10 * time.Second
+
+// storageGuard checks the free space of the save directory and prunes old recordings. It is not thread-safe.
+type storageGuard struct {
+	config StorageConfig
+	dir    string
+	roomId uint64
+	logger logging.Logger
+	// freeSpace is replaced in tests
+	freeSpace func(dir string) (uint64, error)
+	// unsupported is set if the free space cannot be queried on this platform
+	unsupported bool
+	lastCheck   time.Time
+}
+
+func newStorageGuard(task *TaskConfig, logger logging.Logger) *storageGuard {
+	dir := task.Download.SaveDirectory
+	if dir == "" {
+		dir = "."
+	}
+	return &storageGuard{
+		config:    task.Storage,
+		dir:       dir,
+		roomId:    uint64(task.RoomId),
+		logger:    logger,
+		freeSpace: files.FreeSpace,
+	}
+}
+
+// checkFree returns a DiskFull error if the free space is less than threshold.
+func (g *storageGuard) checkFree(threshold int64) error {
+	if threshold <= 0 || g.unsupported {
+		return nil
+	}
+	free, err := g.freeSpace(g.dir)
+	if errors.Is(err, files.ErrFreeSpaceUnsupported) {
+		g.logger.Warning("Free disk space is not checked: %v", err)
+		g.unsupported = true
+		return nil
+	}
+	if err != nil {
+		// do not stop recording because of a failed query
+		g.logger.Error("Cannot get free space of \"%v\": %v", g.dir, err)
+		return nil
+	}
+	if free < uint64(threshold) {
+		return errs.NewError(errs.DiskFull, fmt.Errorf("free space of \"%v\" is %v, less than %v",
+			g.dir, pretty.Bytes(free), pretty.Bytes(uint64(threshold))))
+	}
+	return nil
+}
+
+// beforeCreate prunes old recordings, then checks whether a new file can be created.
+func (g *storageGuard) beforeCreate() error {
+	if g.config.RetentionMaxAgeHours > 0 || g.config.RetentionMaxBytes > 0 {
+		g.prune(time.Now())
+	}
+	g.lastCheck = time.Now()
+	return g.checkFree(g.config.MinFreeBytes)
+}
+
+// checkWrite checks the free space periodically while writing.
+func (g *storageGuard) checkWrite() error {
+	if g.config.StopFreeBytes <= 0 || time.Since(g.lastCheck) < storageCheckInterval {
+		return nil
+	}
+	g.lastCheck = time.Now()
+	return g.checkFree(g.config.StopFreeBytes)
+}
+
+// finishedRecording is a recording found by its manifest.
+type finishedRecording struct {
+	files   []string
+	size    int64
+	endTime time.Time
+}
+
+// findRecordings returns finished recordings of the room in the save directory, the oldest first.
+func (g *storageGuard) findRecordings() ([]finishedRecording, error) {
+	var recordings []finishedRecording
+	err := filepath.WalkDir(g.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == g.dir {
+				return err
+			}
+			// skip unreadable directories
+			return nil
+		}
+		if d.IsDir() && d.Name() == journalDirName {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(p, "."+ManifestExtName) {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		var m Manifest
+		if json.Unmarshal(b, &m) != nil || m.File == "" || m.EndTime.IsZero() {
+			return nil
+		}
+		if m.RoomId != g.roomId && (m.ShortId == 0 || uint64(m.ShortId) != g.roomId) {
+			return nil
+		}
+		base := strings.TrimSuffix(p, "."+ManifestExtName)
+		r := finishedRecording{endTime: m.EndTime}
+		candidates := []string{
+			filepath.Join(filepath.Dir(p), m.File),
+			files.CombineFileName(base, dmfile.ExtName),
+			files.CombineFileName(base, DanmakuFormatXml),
+			files.CombineFileName(base, DanmakuFormatAss),
+		}
+		for _, f := range candidates {
+			if stat, err := os.Stat(f); err == nil && stat.Mode().IsRegular() {
+				r.files = append(r.files, f)
+				r.size += stat.Size()
+			}
+		}
+		// the manifest is deleted at last, so the recording can be found again if deleting fails
+		r.files = append(r.files, p)
+		r.size += int64(len(b))
+		recordings = append(recordings, r)
+		return nil
+	})
+	sort.SliceStable(recordings, func(i, j int) bool {
+		return recordings[i].endTime.Before(recordings[j].endTime)
+	})
+	return recordings, err
+}
+
+// prune deletes finished recordings of the room which exceed the retention limits.
+func (g *storageGuard) prune(now time.Time) {
+	recordings, err := g.findRecordings()
+	if err != nil {
+		g.logger.Error("Cannot find old recordings in \"%v\": %v", g.dir, err)
+		return
+	}
+	var total int64
+	for _, r := range recordings {
+		total += r.size
+	}
+	maxAge := time.Duration(g.config.RetentionMaxAgeHours) * time.Hour
+	for _, r := range recordings {
+		tooOld := maxAge > 0 && now.Sub(r.endTime) > maxAge
+		tooLarge := g.config.RetentionMaxBytes > 0 && total > g.config.RetentionMaxBytes
+		if !tooOld && !tooLarge {
+			// recordings are sorted, the rest are newer
+			break
+		}
+		g.logger.Info("Deleting old recording %v (%v, finished at %v)...",
+			r.files[0], pretty.Bytes(uint64(r.size)), r.endTime.Format(time.RFC3339))
+		for _, f := range r.files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				g.logger.Error("Cannot delete \"%v\": %v", f, err)
+			}
+		}
+		total -= r.size
+		g.removeEmptyDirs(filepath.Dir(r.files[0]))
+	}
+}
+
+// removeEmptyDirs removes dir and its parents if they are empty, the save directory is kept.
+func (g *storageGuard) removeEmptyDirs(dir string) {
+	for ; ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(g.dir, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// guardedWriter stops writing when the free space is too low.
+type guardedWriter struct {
+	w     io.Writer
+	guard *storageGuard
+}
+
+func (w *guardedWriter) Write(p []byte) (n int, err error) {
+	if err := w.guard.checkWrite(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
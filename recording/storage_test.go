@@ -0,0 +1,106 @@
+package recording
+
+import (
+	"errors"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageGuard_FreeSpace(t *testing.T) {
+	files := newTestRecordingFiles(t, DownloadConfig{})
+	files.task.Storage = StorageConfig{MinFreeBytes: 100, StopFreeBytes: 50}
+	files.storage = newStorageGuard(files.task, files.logger)
+	free := uint64(100)
+	files.storage.freeSpace = func(string) (uint64, error) {
+		return free, nil
+	}
+
+	w, err := files.create("flv")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	free = 60
+	files.storage.lastCheck = time.Time{}
+	if _, err := w.Write([]byte("test")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	free = 40
+	// the free space is checked periodically
+	if _, err := w.Write([]byte("test")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files.storage.lastCheck = time.Time{}
+	_, err = w.Write([]byte("test"))
+	var taskErr errs.TaskError
+	if !errors.As(err, &taskErr) || taskErr.Type() != errs.DiskFull || taskErr.IsRecoverable() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	free = 99
+	_, err = files.create("flv")
+	if !errors.As(err, &taskErr) || taskErr.Type() != errs.DiskFull {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files.Close()
+}
+
+func TestStorageGuard_Prune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, roomId uint64, age time.Duration, size int) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0775); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".flv"), make([]byte, size), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".xml"), nil, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		err := writeManifest(filepath.Join(dir, name+".json"), &Manifest{
+			RoomId:  roomId,
+			File:    filepath.Base(name) + ".flv",
+			EndTime: now.Add(-age),
+		})
+		if err != nil {
+			t.Fatalf("writeManifest: %v", err)
+		}
+	}
+	write("sub/oldest", 1, 72*time.Hour, 1000)
+	write("old", 1, 48*time.Hour, 1000)
+	write("other", 2, 96*time.Hour, 1000)
+	write("new", 1, time.Hour, 1000)
+	write("newest", 1, 0, 1000)
+	if err := os.WriteFile(filepath.Join(dir, "unknown.flv"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	g := newStorageGuard(&TaskConfig{
+		RoomId:   1,
+		Download: DownloadConfig{SaveDirectory: dir},
+		Storage:  StorageConfig{RetentionMaxAgeHours: 60, RetentionMaxBytes: 3000},
+	}, newTestRecordingFiles(t, DownloadConfig{}).logger)
+	g.prune(now)
+
+	var remaining []string
+	_ = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && p != dir {
+			rel, _ := filepath.Rel(dir, p)
+			remaining = append(remaining, rel)
+		}
+		return nil
+	})
+	expected := []string{
+		"new.flv", "new.json", "new.xml",
+		"newest.flv", "newest.json", "newest.xml",
+		"other.flv", "other.json", "other.xml",
+		"unknown.flv",
+	}
+	if strings.Join(remaining, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected files: %v", remaining)
+	}
+}
@@ -0,0 +1,126 @@
+package recording
+
+/*
+In this file we run tasks of streamers, which are keyed by uid instead of room id.
+The live room of the streamer is looked up before each run, and checked periodically while the task is running.
+If the room is changed, the run is restarted in the new room, so the task follows the streamer.
+A recording is never interrupted by a room change, the new room is used after the recording is finished.
+*/
+
+import (
+	"context"
+	"errors"
+	"github.com/keuin/slbr/bilibili"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/types"
+	"time"
+)
+
+var errRoomChanged = errs.NewError(errs.RoomChanged)
+
+// runInStreamerRoom runs the task once in the current live room of the streamer, like tryRunTask.
+// errRoomChanged is returned if the run is stopped because the streamer has moved to another room.
+func runInStreamerRoom(t *RunningTask) error {
+	// the room is looked up with cookies of the task like other requests, guests are limited by risk control
+	bi, err := newTaskClient(t)
+	if err != nil {
+		return err
+	}
+
+	interval := t.Watch.RoomCheckInterval()
+	roomId, err := waitStreamerRoom(t, bi.GetUserRoomId, interval)
+	if err != nil {
+		return err
+	}
+	t.state.setRoomId(roomId)
+	t.hooks.setRoomId(roomId)
+
+	ctx, cancel := context.WithCancelCause(t.ctx)
+	defer cancel(nil)
+	// the run works on a copy, so the config of the task is not changed
+	run := *t
+	run.ctx = ctx
+	run.RoomId = roomId
+	go checkStreamerRoom(t, bi.GetUserRoomId, interval, ctx, roomId, cancel)
+
+	err = tryRunTask(&run)
+	if t.ctx.Err() == nil && errors.Is(context.Cause(ctx), errRoomChanged) {
+		return errRoomChanged
+	}
+	return err
+}
+
+// roomLookup returns the live room of a streamer, see bilibili.GetUserRoomId.
+type roomLookup func(uid uint64) (types.RoomId, error)
+
+// waitStreamerRoom returns the current live room of the streamer.
+// If the streamer has no live room, it checks again after interval until the room is created.
+func waitStreamerRoom(t *RunningTask, lookup roomLookup, interval time.Duration) (types.RoomId, error) {
+	for {
+		t.logger.Info("Getting live room of streamer %v...", t.Uid)
+		roomId, err := AutoRetryWithTask(t, func() (types.RoomId, error) {
+			roomId, err := lookup(t.Uid)
+			if errors.Is(err, bilibili.ErrRoomNotFound) {
+				// retrying does not help, wait for the next check
+				return 0, nil
+			}
+			return roomId, err
+		})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return 0, err
+			}
+			return 0, errs.NewError(errs.GetRoomInfo, err)
+		}
+		if roomId != 0 {
+			t.logger.Info("The live room of streamer %v is %v.", t.Uid, roomId)
+			return roomId, nil
+		}
+		t.logger.Warning("Streamer %v has no live room, check again in %v.", t.Uid, interval)
+		timer := time.NewTimer(interval)
+		select {
+		case <-t.ctx.Done():
+			timer.Stop()
+			return 0, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// checkStreamerRoom checks the live room of the streamer every interval until ctx is cancelled.
+// If the room is not roomId and the task is not recording, stop is called with errRoomChanged.
+func checkStreamerRoom(
+	t *RunningTask,
+	lookup roomLookup,
+	interval time.Duration,
+	ctx context.Context,
+	roomId types.RoomId,
+	stop context.CancelCauseFunc,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		newRoomId, err := lookup(t.Uid)
+		if err != nil {
+			// the current room is kept, it still works until the streamer starts a live in another room
+			t.logger.Warning("Cannot check the live room of streamer %v: %v", t.Uid, err)
+			continue
+		}
+		if newRoomId == roomId {
+			continue
+		}
+		if t.state.isRecording() {
+			t.logger.Info("The live room of streamer %v is changed from %v to %v, "+
+				"the new room will be used after current recording.", t.Uid, roomId, newRoomId)
+			continue
+		}
+		t.logger.Info("The live room of streamer %v is changed from %v to %v.", t.Uid, roomId, newRoomId)
+		stop(errRoomChanged)
+		return
+	}
+}
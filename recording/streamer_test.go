@@ -0,0 +1,120 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/keuin/slbr/bilibili"
+	errs "github.com/keuin/slbr/bilibili/errors"
+	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStreamerTask(ctx context.Context) *RunningTask {
+	logger := logging.NewWrappedLogger(log.New(io.Discard, "", 0), "test")
+	task := NewRunningTask(TaskConfig{
+		Uid:       9617619,
+		Transport: TransportConfig{MaxRetryTimes: 1},
+	}, ctx, func() {}, func() {}, logger)
+	return &task
+}
+
+// fakeRoomLookup returns rooms in order, the last one is kept
+type fakeRoomLookup struct {
+	lock    sync.Mutex
+	results []interface{}
+}
+
+func (f *fakeRoomLookup) lookup(uid uint64) (types.RoomId, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r := f.results[0]
+	if len(f.results) > 1 {
+		f.results = f.results[1:]
+	}
+	switch r := r.(type) {
+	case types.RoomId:
+		return r, nil
+	case error:
+		return 0, r
+	}
+	panic(fmt.Errorf("invalid result: %v", r))
+}
+
+func TestWaitStreamerRoom(t *testing.T) {
+	task := newTestStreamerTask(context.Background())
+	f := &fakeRoomLookup{results: []interface{}{
+		fmt.Errorf("%w: user %v has no live room", bilibili.ErrRoomNotFound, task.Uid),
+		errors.New("network is unreachable"),
+		types.RoomId(7734200),
+	}}
+	roomId, err := waitStreamerRoom(task, f.lookup, time.Millisecond)
+	if err != nil {
+		t.Fatalf("waitStreamerRoom: %v", err)
+	}
+	if roomId != 7734200 {
+		t.Fatalf("unexpected room: %v", roomId)
+	}
+
+	// waiting is stopped with the task
+	ctx, cancel := context.WithCancel(context.Background())
+	task = newTestStreamerTask(ctx)
+	f = &fakeRoomLookup{results: []interface{}{bilibili.ErrRoomNotFound}}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := waitStreamerRoom(task, f.lookup, time.Millisecond); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCheckStreamerRoom(t *testing.T) {
+	task := newTestStreamerTask(context.Background())
+	ctx, stop := context.WithCancelCause(context.Background())
+	defer stop(nil)
+	f := &fakeRoomLookup{results: []interface{}{
+		types.RoomId(7734200),
+		errors.New("network is unreachable"),
+		types.RoomId(1234),
+	}}
+
+	// the room is not changed while recording
+	task.state.setCurrentFile("a.flv")
+	done := make(chan struct{})
+	go func() {
+		checkStreamerRoom(task, f.lookup, time.Millisecond, ctx, 7734200, stop)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatalf("the task is stopped while recording: %v", context.Cause(ctx))
+	}
+
+	task.state.setCurrentFile("")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("the room change is not detected")
+	}
+	if !errors.Is(context.Cause(ctx), errRoomChanged) {
+		t.Fatalf("expected errRoomChanged, got %v", context.Cause(ctx))
+	}
+}
+
+func TestRunInStreamerRoom_CookieFile(t *testing.T) {
+	// the room is looked up with cookies of the task, so a broken cookie file is reported before any request
+	task := newTestStreamerTask(context.Background())
+	task.CookieFile = filepath.Join(t.TempDir(), "missing.json")
+	err := runInStreamerRoom(task)
+	var taskErr errs.TaskError
+	if !errors.As(err, &taskErr) || taskErr.Type() != errs.LoadCookies {
+		t.Fatalf("expected LoadCookies error, got %v", err)
+	}
+}
@@ -8,8 +8,13 @@ Concrete task works are done in the `runner.go` file.
 import (
 	"context"
 	"fmt"
+	errs "github.com/keuin/slbr/bilibili/errors"
 	"github.com/keuin/slbr/common/retry"
+	"github.com/keuin/slbr/danmaku/dmmsg"
 	"github.com/keuin/slbr/logging"
+	"github.com/keuin/slbr/types"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +27,24 @@ const (
 	StStopped
 )
 
+var taskStatusStringMap = map[TaskStatus]string{
+	StNotStarted: "not_started",
+	StRunning:    "running",
+	StRestarting: "restarting",
+	StStopped:    "stopped",
+}
+
+func (s TaskStatus) String() string {
+	if str, ok := taskStatusStringMap[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("<TaskStatus %d>", int(s))
+}
+
+func (s TaskStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 var (
 	ErrTaskIsAlreadyStarted = fmt.Errorf("task is already started")
 	ErrTaskIsStopped        = fmt.Errorf("restarting a stopped task is not allowed")
@@ -33,14 +56,18 @@ type RunningTask struct {
 	TaskConfig
 	// ctx: the biggest context this task uses. It may create children contexts.
 	ctx context.Context
-	// status: running status
-	status TaskStatus
+	// state: volatile runtime state, which may be read by other goroutines
+	state *taskState
 	// hookStarted: called asynchronously when the task is started. This won't be called when restarting.
 	hookStarted func()
 	// hookStopped: called asynchronously when the task is stopped. This won't be called when restarting.
 	hookStopped func()
+	// hooks: user-defined hooks triggered by task events
+	hooks *hookRunner
 	// logger: where to print logs
 	logger logging.Logger
+	// danmakuHandlers: user-defined handlers which receive server messages of this task
+	danmakuHandlers []dmmsg.DanmakuMessageHandler
 }
 
 func NewRunningTask(
@@ -53,22 +80,34 @@ func NewRunningTask(
 	return RunningTask{
 		TaskConfig:  config,
 		ctx:         ctx,
-		status:      StNotStarted,
+		state:       &taskState{},
 		hookStarted: hookStarted,
 		hookStopped: hookStopped,
+		hooks:       newHookRunner(config.RoomId, config.Hooks, logger),
 		logger:      logger,
 	}
 }
 
+// RegisterDanmakuHandler adds a handler which receives server messages of this task,
+// including danmaku, gifts and live status changes.
+// This must be called before the task is started.
+func (t *RunningTask) RegisterDanmakuHandler(h dmmsg.DanmakuMessageHandler) {
+	t.danmakuHandlers = append(t.danmakuHandlers, h)
+}
+
 func (t *RunningTask) StartTask() error {
-	st := t.status
+	t.state.lock.Lock()
+	defer t.state.lock.Unlock()
+	st := t.state.status
 	switch st {
 	case StNotStarted:
-		// TODO real start
+		t.state.status = StRunning
 		go func() {
-			defer func() { t.status = StStopped }()
 			t.hookStarted()
+			// uploading or remuxing may still be running, they are not waited for,
+			// so stopping, removing or reloading the task is never blocked by hooks
 			defer t.hookStopped()
+			defer t.state.setStatus(StStopped)
 			// do the task
 			t.runTaskWithAutoRestart()
 		}()
@@ -86,6 +125,132 @@ func (t *RunningTask) StartTask() error {
 	panic(fmt.Errorf("invalid task status: %v", st))
 }
 
+// TaskInfo is a snapshot of the runtime information of a task.
+type TaskInfo struct {
+	// Key identifies the task in TaskManager
+	Key TaskKey `json:"key"`
+	// RoomId is the room being recorded, which is looked up when the task runs for tasks of a uid
+	RoomId types.RoomId `json:"room_id"`
+	Uid    uint64       `json:"uid,omitempty"`
+	Status TaskStatus   `json:"status"`
+	// CurrentFile is the path of the file being recorded, empty if the task is not recording
+	CurrentFile string `json:"current_file"`
+	// BytesWritten is the total number of bytes recorded by this task
+	BytesWritten  int64      `json:"bytes_written"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// Info returns the runtime information of this task. It is safe to call Info from any goroutine.
+func (t *RunningTask) Info() TaskInfo {
+	t.state.lock.Lock()
+	defer t.state.lock.Unlock()
+	info := TaskInfo{
+		Key:          t.Key(),
+		RoomId:       t.roomIdLocked(),
+		Uid:          t.Uid,
+		Status:       t.state.status,
+		CurrentFile:  t.state.currentFile,
+		BytesWritten: t.state.bytesWritten.Load(),
+	}
+	if t.state.lastError != nil {
+		info.LastError = t.state.lastError.Error()
+		errTime := t.state.lastErrorTime
+		info.LastErrorTime = &errTime
+	}
+	return info
+}
+
+// roomIdLocked returns the room being recorded. The lock of state must be held.
+func (t *RunningTask) roomIdLocked() types.RoomId {
+	if t.state.roomId != 0 {
+		return t.state.roomId
+	}
+	return t.RoomId
+}
+
+// Status returns current running status of this task.
+func (t *RunningTask) Status() TaskStatus {
+	t.state.lock.Lock()
+	defer t.state.lock.Unlock()
+	return t.state.status
+}
+
+// taskState contains the volatile runtime state of a task.
+type taskState struct {
+	lock          sync.Mutex
+	status        TaskStatus
+	currentFile   string
+	bytesWritten  atomic.Int64
+	lastError     error
+	lastErrorTime time.Time
+	// recordingStart: when current file is created, zero if not recording
+	recordingStart time.Time
+	// recordedDuration: total duration of finished files
+	recordedDuration time.Duration
+	// retries: how many times the task is retried, by error type
+	retries map[errs.Type]int64
+	// messages: how many server messages are received, by command
+	messages          map[string]int64
+	heartbeatFailures atomic.Int64
+	viewers           atomic.Int64
+	// cdnHost: the stream host which worked last time, it is tried first when recording
+	cdnHost string
+	// roomId: the current room of the streamer in tasks of a uid, zero if it is not looked up yet
+	roomId types.RoomId
+}
+
+func (s *taskState) setStatus(status TaskStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.status = status
+}
+
+func (s *taskState) setCurrentFile(filePath string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.currentFile = filePath
+	if !s.recordingStart.IsZero() {
+		s.recordedDuration += time.Since(s.recordingStart)
+		s.recordingStart = time.Time{}
+	}
+	if filePath != "" {
+		s.recordingStart = time.Now()
+	}
+}
+
+func (s *taskState) setRoomId(roomId types.RoomId) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roomId = roomId
+}
+
+// isRecording reports if a file is being recorded.
+func (s *taskState) isRecording() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.currentFile != ""
+}
+
+func (s *taskState) getCdnHost() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cdnHost
+}
+
+func (s *taskState) setCdnHost(host string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cdnHost = host
+}
+
+func (s *taskState) setLastError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastError = err
+	s.lastErrorTime = time.Now()
+}
+
 func AutoRetryWithTask[T any](
 	t *RunningTask,
 	supplier func() (T, error),
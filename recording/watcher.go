@@ -3,7 +3,7 @@ package recording
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"github.com/keuin/slbr/bilibili"
 	errs "github.com/keuin/slbr/bilibili/errors"
 	"github.com/keuin/slbr/danmaku"
@@ -13,18 +13,6 @@ import (
 	"time"
 )
 
-type liveCommand string
-
-const (
-	CommandLiveStart       = "LIVE"
-	CommandStreamPreparing = "PREPARING"
-)
-
-type liveInfo struct {
-	Command liveCommand            `json:"cmd"`
-	Data    map[string]interface{} `json:"data"`
-}
-
 const (
 	heartBeatInterval = 30 * time.Second
 )
@@ -32,8 +20,11 @@ const (
 // watch monitors live room status by subscribing messages from Bilibili danmaku server,
 // which talks to the client via a WebSocket or TCP connection.
 // In our implementation, we use WebSocket over SSL/TLS.
-// This function will return after the live is started,
-// since one connection cannot receive more than one live start event.
+// onLiveStart is called when the live is started.
+// Server messages are passed to the dispatcher, which feeds the live status detection, logging and sinks.
+// Heartbeat failures are counted in state.
+// This function does not return after the live is started,
+// the connection is kept open to capture danmaku messages while recording.
 // Error types:
 // - UnrecoverableError
 // - RecoverableError
@@ -42,8 +33,12 @@ func watch(
 	ctx context.Context,
 	t TaskConfig,
 	url string,
+	uid uint64,
 	authKey, buvid3 string,
 	liveStatusChecker func() (bool, error),
+	onLiveStart func(),
+	dispatcher *dmmsg.Dispatcher,
+	state *taskState,
 	logger logging.Logger,
 	bi *bilibili.Bilibili,
 ) error {
@@ -64,7 +59,7 @@ func watch(
 
 	// the danmaku server requires an auth token and room id when connected
 	logger.Info("ws connected. Authenticating...")
-	err = dm.Authenticate(t.RoomId, authKey, buvid3)
+	err = dm.Authenticate(t.RoomId, uid, authKey, buvid3)
 	if err != nil {
 		return errs.NewError(errs.InvalidAuthProtocol, err)
 	}
@@ -77,6 +72,7 @@ func watch(
 			logger.Info("Heartbeat sent OK.")
 		} else {
 			logger.Error("Failed to send heartbeat: %v", err)
+			state.heartbeatFailures.Add(1)
 		}
 		return err
 	}
@@ -98,7 +94,7 @@ func watch(
 	}
 	if isLiving {
 		logger.Info("The live is already started. Start recording immediately.")
-		return nil
+		onLiveStart()
 	} else {
 		logger.Info("The live is not started yet. Waiting...")
 	}
@@ -109,7 +105,7 @@ func watch(
 		for {
 			select {
 			case <-heartBeatTimer.C:
-				err = heartbeat()
+				err := heartbeat()
 				if err != nil {
 					logger.Error("heartbeat failed: %v", err)
 				}
@@ -130,84 +126,29 @@ func watch(
 			if err != nil {
 				return errs.NewError(errs.DanmakuExchangeRead, err)
 			}
-			// the exchange may be compressed
-			msg, err = msg.Inflate()
+			// the exchange may be compressed, and a compressed exchange contains multiple messages
+			var messages []dmpkg.DanmakuExchange
+			messages, err = msg.InflateAll()
 			if err != nil {
 				return errs.NewError(errs.MessageDecompression, err)
 			}
 
-			switch msg.Operation {
-			case dmpkg.OpLayer7Data:
-				//logger.Printf("server message: op %v, body %v", msg.Operation, string(msg.Body))
-				var info liveInfo
-				err := json.Unmarshal(msg.Body, &info)
-				if err != nil {
-					logger.Error("Invalid JSON: \"%v\", exchange: %v", string(msg.Body), msg)
-					return errs.NewError(errs.JsonDecode, err)
-				}
-				switch info.Command {
-				case CommandLiveStart:
-					return nil
-				case CommandStreamPreparing:
-					break
-				default:
-					switch info.Command {
-					case "ENTRY_EFFECT":
-						fallthrough
-					case "ONLINE_RANK_V2":
-						fallthrough
-					case "ONLINE_RANK_COUNT":
-						fallthrough
-					case "STOP_LIVE_ROOM_LIST":
-						// useless message
-						fallthrough
-					case "HOT_RANK_CHANGED_V2":
-						// useless message
-						logger.Info("Ignore message: %v", info.Command)
-					case "WATCHED_CHANGE":
-						// number of watched people changed
-						obj, exists := info.Data["num"]
-						if !exists {
-							continue
-						}
-						viewersNum, ok := obj.(float64)
-						if !ok {
-							logger.Error("Cannot parse watched people number: %v", obj)
-							continue
-						}
-						logger.Info("The number of viewers (room: %v): %v", t.RoomId, viewersNum)
-					case "INTERACT_WORD":
-						var raw dmmsg.RawInteractWordMessage
-						err = json.Unmarshal(msg.Body, &raw)
-						if err != nil {
-							logger.Error("Cannot parse RawInteractWordMessage JSON: %v", err)
-							continue
-						}
-						logger.Info("Interact word message: user: %v medal: %v",
-							raw.Data.UserName, raw.Data.FansMedal.Name)
-					case "DANMU_MSG":
-						var raw dmmsg.RawDanMuMessage
-						err = json.Unmarshal(msg.Body, &raw)
-						if err != nil {
-							logger.Error("Cannot parse danmaku message as JSON: %v", err)
-							continue
-						}
-						dmm, err := dmmsg.ParseDanmakuMessage(raw)
-						if err != nil {
-							logger.Error("Cannot parse danmaku message JSON: %v, raw data (base64 encoded): %v",
-								err, base64.StdEncoding.EncodeToString(msg.Body))
-							continue
-						}
-						logger.Info("Danmaku: %v", dmm.String())
-					default:
-						logger.Info("Ignore unhandled server message %v %v %v",
-							info.Command, msg.Operation, string(msg.Body))
+			for _, msg := range messages {
+				switch msg.Operation {
+				case dmpkg.OpLayer7Data:
+					err = dispatcher.Dispatch(msg)
+					var decodeErr *dmmsg.DecodeError
+					if errors.As(err, &decodeErr) {
+						logger.Error("Cannot decode server message: %v, raw data (base64 encoded): %v",
+							err, base64.StdEncoding.EncodeToString(decodeErr.Body))
+					} else if err != nil {
+						logger.Error("Invalid JSON: \"%v\", exchange: %v", string(msg.Body), msg)
+						return errs.NewError(errs.JsonDecode, err)
 					}
+				default:
+					logger.Info("Server message: %v", msg.String())
 				}
-			default:
-				logger.Info("Server message: %v", msg.String())
 			}
-
 		}
 	}
 }
@@ -0,0 +1,87 @@
+package main
+
+/*
+In this file we implement config file hot-reloading.
+The config file is reloaded when it is changed or SIGHUP is received,
+then running tasks are reconciled with the new config.
+*/
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reloadDelay merges change events in a short period,
+// since editors may write a file more than once when saving.
+const reloadDelay = time.Second
+
+// watchConfig reloads the config file on changes or SIGHUP until ctx is cancelled.
+func watchConfig(
+	ctx context.Context,
+	configFile string,
+	applier *taskApplier,
+	logger *log.Logger,
+) {
+	chReload := make(chan struct{}, 1)
+	requestReload := func() {
+		select {
+		case chReload <- struct{}{}:
+		default:
+		}
+	}
+
+	chSigHup := make(chan os.Signal, 1)
+	signal.Notify(chSigHup, syscall.SIGHUP)
+	defer signal.Stop(chSigHup)
+
+	// this viper instance is only used to watch the file
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.OnConfigChange(func(fsnotify.Event) {
+		requestReload()
+	})
+	v.WatchConfig()
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-chSigHup:
+			logger.Println("SIGHUP received, reloading config file...")
+			reloadConfig(configFile, applier, logger)
+		case <-chReload:
+			timer.Reset(reloadDelay)
+		case <-timer.C:
+			logger.Println("Config file is changed, reloading...")
+			reloadConfig(configFile, applier, logger)
+		}
+	}
+}
+
+func reloadConfig(
+	configFile string,
+	applier *taskApplier,
+	logger *log.Logger,
+) {
+	config, err := readConfigFile(configFile)
+	if err != nil {
+		logger.Printf("Cannot reload config file: %v. Running tasks are not changed.", err)
+		return
+	}
+	// invalid rooms are skipped, rooms which cannot be resolved now are retried in background
+	result, err := applier.apply(config.Tasks, true)
+	if err != nil {
+		logger.Printf("Error occurred while reconciling tasks: %v", err)
+	}
+	logger.Printf("Config file is reloaded. Added: %v, removed: %v, restarted: %v.",
+		result.Added, result.Removed, result.Restarted)
+}
@@ -0,0 +1,43 @@
+package types
+
+type qrCodeGenerate struct {
+	// Url is the content of the QR code, which is scanned with the bilibili app
+	Url       string `json:"url"`
+	QrCodeKey string `json:"qrcode_key"`
+}
+
+type QrCodeGenerateResponse = BaseResponse[qrCodeGenerate]
+
+// QrCodeStatus is the status of a QR code login.
+type QrCodeStatus int
+
+const (
+	QrCodeConfirmed QrCodeStatus = 0
+	QrCodeExpired   QrCodeStatus = 86038
+	// QrCodeScanned means the QR code is scanned but not confirmed
+	QrCodeScanned QrCodeStatus = 86090
+	QrCodeWaiting QrCodeStatus = 86101
+)
+
+type qrCodePoll struct {
+	Url          string       `json:"url"`
+	RefreshToken string       `json:"refresh_token"`
+	Timestamp    int64        `json:"timestamp"`
+	Code         QrCodeStatus `json:"code"`
+	Message      string       `json:"message"`
+}
+
+type QrCodePollResponse = BaseResponse[qrCodePoll]
+
+type navInfo struct {
+	IsLogin bool   `json:"isLogin"`
+	Mid     uint64 `json:"mid"`
+	Uname   string `json:"uname"`
+	WbiImg  struct {
+		ImgUrl string `json:"img_url"`
+		SubUrl string `json:"sub_url"`
+	} `json:"wbi_img"`
+}
+
+// NavResponse is the login status of the current session. Code is -101 if not logged in.
+type NavResponse = BaseResponse[navInfo]
@@ -17,12 +17,56 @@ type qualityDescription struct {
 	Description   string `json:"desc"`
 }
 
+// PlayurlInfo is the stream list returned by the v2 getRoomPlayInfo API.
+// Streams are grouped by protocol, format and codec.
+type PlayurlInfo struct {
+	Playurl struct {
+		Cid         uint64               `json:"cid"`
+		QualityDesc []qualityDescription `json:"g_qn_desc"`
+		Stream      []PlayStream         `json:"stream"`
+	} `json:"playurl"`
+}
+
+type PlayStream struct {
+	// ProtocolName: "http_stream" or "http_hls"
+	ProtocolName string       `json:"protocol_name"`
+	Format       []PlayFormat `json:"format"`
+}
+
+type PlayFormat struct {
+	// FormatName: "flv", "ts" or "fmp4"
+	FormatName string      `json:"format_name"`
+	Codec      []PlayCodec `json:"codec"`
+}
+
+type PlayCodec struct {
+	// CodecName: "avc" or "hevc"
+	CodecName string `json:"codec_name"`
+	// CurrentQn is the quality of the URLs
+	CurrentQn int   `json:"current_qn"`
+	AcceptQn  []int `json:"accept_qn"`
+	// BaseUrl is the path of the stream, the full URL is Host + BaseUrl + Extra
+	BaseUrl string        `json:"base_url"`
+	UrlInfo []PlayUrlHost `json:"url_info"`
+}
+
+type PlayUrlHost struct {
+	Host      string `json:"host"`
+	Extra     string `json:"extra"`
+	StreamTtl int    `json:"stream_ttl"`
+}
+
 type StreamingUrlInfo struct {
 	URL        string `json:"url"`
 	Length     int    `json:"length"`
 	Order      int    `json:"order"`
 	StreamType int    `json:"stream_type"`
 	P2pType    int    `json:"p2p_type"`
+	// the following fields are only available in streams selected from PlayurlInfo
+	Protocol      StreamProtocol `json:"protocol,omitempty"`
+	Format        string         `json:"format,omitempty"`
+	Codec         StreamCodec    `json:"codec,omitempty"`
+	QualityNumber int            `json:"qn,omitempty"`
 }
 
 type roomProfile struct {
@@ -100,6 +144,24 @@ type roomProfile struct {
 
 type RoomProfileResponse = BaseResponse[roomProfile]
 
+type streamerInfo struct {
+	Info struct {
+		UID   int    `json:"uid"`
+		Uname string `json:"uname"`
+		Face  string `json:"face"`
+	} `json:"info"`
+	FollowerNum int    `json:"follower_num"`
+	RoomID      RoomId `json:"room_id"`
+	MedalName   string `json:"medal_name"`
+	RoomNews    struct {
+		Content   string `json:"content"`
+		Ctime     string `json:"ctime"`
+		CtimeText string `json:"ctime_text"`
+	} `json:"room_news"`
+}
+
+type StreamerInfoResponse = BaseResponse[streamerInfo]
+
 type LiveStatus int
 
 const (
@@ -129,7 +191,7 @@ type roomPlayInfo struct {
 	LiveTime        int           `json:"live_time"`
 	RoomShield      int           `json:"room_shield"`
 	AllSpecialTypes []interface{} `json:"all_special_types"`
-	PlayurlInfo     interface{}   `json:"playurl_info"`
+	PlayurlInfo     *PlayurlInfo  `json:"playurl_info"`
 }
 
 type RoomPlayInfoResponse = BaseResponse[roomPlayInfo]
@@ -158,3 +220,26 @@ type danmakuInfo struct {
 		WsPort  int    `json:"ws_port"`
 	} `json:"host_list"`
 }
+
+type roomInit struct {
+	// RoomID is the canonical room id, ShortID is 0 if the room has no short id
+	RoomID     RoomId     `json:"room_id"`
+	ShortID    int        `json:"short_id"`
+	UID        int        `json:"uid"`
+	LiveStatus LiveStatus `json:"live_status"`
+	IsHidden   bool       `json:"is_hidden"`
+	IsLocked   bool       `json:"is_locked"`
+}
+
+type RoomInitResponse = BaseResponse[roomInit]
+
+type userRoomInfo struct {
+	// RoomStatus is 0 if the user has no live room
+	RoomStatus int        `json:"roomStatus"`
+	LiveStatus LiveStatus `json:"liveStatus"`
+	Url        string     `json:"url"`
+	Title      string     `json:"title"`
+	RoomID     RoomId     `json:"roomid"`
+}
+
+type UserRoomInfoResponse = BaseResponse[userRoomInfo]
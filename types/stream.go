@@ -0,0 +1,27 @@
+package types
+
+// StreamCodec is the video codec of a live stream.
+type StreamCodec string
+
+const (
+	CodecAvc  StreamCodec = "avc"
+	CodecHevc StreamCodec = "hevc"
+)
+
+func (c StreamCodec) IsValid() bool {
+	return c == CodecAvc || c == CodecHevc
+}
+
+// StreamProtocol is how a live stream is delivered.
+type StreamProtocol string
+
+const (
+	// ProtocolFlv is an FLV file served over HTTP (http_stream)
+	ProtocolFlv StreamProtocol = "flv"
+	// ProtocolHls is an HLS playlist of fMP4 or TS segments (http_hls)
+	ProtocolHls StreamProtocol = "hls"
+)
+
+func (p StreamProtocol) IsValid() bool {
+	return p == ProtocolFlv || p == ProtocolHls
+}